@@ -0,0 +1,698 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justclimber/fda-lang/dap"
+	"github.com/justclimber/fda-lang/fdalang"
+	"github.com/justclimber/fda-lang/lsp"
+	"github.com/justclimber/fda-lang/repl"
+)
+
+const stdinName = "-"
+
+// errUsage is returned when arguments are wrong, the usage is already printed
+var errUsage = errors.New("usage")
+
+func (c *cli) flags(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: fda %s %s\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags returns exit code if the command should not be continued
+func (c *cli) parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// source reads the only file argument, no argument or '-' means stdin
+func (c *cli) source(flags *flag.FlagSet) (string, string, error) {
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return "", "", errUsage
+	}
+	path := flags.Arg(0)
+	if path == "" {
+		path = stdinName
+	}
+	source, name, err := c.readSource(path)
+	return terminated(source), name, err
+}
+
+func (c *cli) readSource(path string) (string, string, error) {
+	var content []byte
+	var err error
+	if path == stdinName {
+		content, err = ioutil.ReadAll(c.stdin)
+		path = "<stdin>"
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return "", "", err
+	}
+	return string(content), path, nil
+}
+
+// terminated adds the newline the last statement should end with, editors could omit it
+func terminated(source string) string {
+	if source != "" && !strings.HasSuffix(source, "\n") {
+		return source + "\n"
+	}
+	return source
+}
+
+// position formats error position as editors and terminals recognize it
+func position(path string, line, col int) string {
+	return fmt.Sprintf("%s:%d:%d", path, line, col)
+}
+
+// reportError prints the error of the program, position of parse and runtime errors is moved to the front
+func (c *cli) reportError(path string, err error) {
+	msg := err.Error()
+	var line, col int
+	if i := strings.LastIndex(msg, "\nline:"); i >= 0 {
+		if _, scanErr := fmt.Sscanf(msg[i:], "\nline:%d, pos %d", &line, &col); scanErr == nil {
+			fmt.Fprintf(c.stderr, "%s: %s\n", position(path, line, col), strings.TrimSpace(msg[:i]))
+			return
+		}
+	}
+	fmt.Fprintf(c.stderr, "%s: %s\n", path, strings.TrimSpace(msg))
+}
+
+func loadFixtures(path string) (*fdalang.Fixtures, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return fdalang.LoadFixtures(f)
+}
+
+// programOptions declares values and structs of the fixtures to type check the program with them
+func programOptions(fixtures *fdalang.Fixtures) (fdalang.ProgramOptions, error) {
+	if fixtures == nil {
+		return fdalang.ProgramOptions{}, nil
+	}
+	env := fdalang.NewEnvironment()
+	if err := fixtures.Apply(env); err != nil {
+		return fdalang.ProgramOptions{}, fmt.Errorf("fixtures: %s", err.Error())
+	}
+	return fdalang.ProgramOptions{Env: env}, nil
+}
+
+type runOptions struct {
+	fixtures string
+	budget   int
+	depth    int
+	alloc    int
+	seed     int64
+	// coverage is collected if set, it's not a flag as it depends on the program
+	coverage *fdalang.Coverage
+}
+
+func (o *runOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.fixtures, "fixtures", "", "JSON file with host values and events dispatched after the run")
+	flags.IntVar(&o.budget, "budget", 0, "maximum number of operations, 0 means no limit")
+	flags.IntVar(&o.depth, "depth", 0, "maximum depth of nested function calls, 0 means no limit")
+	flags.IntVar(&o.alloc, "alloc", 0, "maximum number of allocated objects, 0 means no limit")
+	flags.Int64Var(&o.seed, "seed", 0, "seed of the random generator")
+}
+
+func (o *runOptions) configure(executor *fdalang.ExecAstVisitor, output io.Writer) {
+	executor.SetOutput(output)
+	executor.SetRandSeed(o.seed)
+	executor.SetAllocLimit(o.alloc)
+	executor.SetLimits(fdalang.ExecLimits{Operations: o.budget, CallDepth: o.depth})
+	if o.coverage != nil {
+		executor.SetCoverage(o.coverage)
+	}
+}
+
+// execute runs the program and dispatches events of the fixtures, print builtin writes to the output
+func execute(source string, o *runOptions, output io.Writer) (*fdalang.Runtime, error) {
+	fixtures, err := loadFixtures(o.fixtures)
+	if err != nil {
+		return nil, err
+	}
+	options, err := programOptions(fixtures)
+	if err != nil {
+		return nil, err
+	}
+	program, err := fdalang.NewProgramWithOptions(source, options)
+	if err != nil {
+		return nil, err
+	}
+	runtime := fdalang.NewRuntime(program)
+	o.configure(runtime.Executor(), output)
+	if fixtures == nil {
+		_, err = runtime.Run()
+		return runtime, err
+	}
+
+	if err = fixtures.Apply(runtime.Env()); err != nil {
+		return nil, fmt.Errorf("fixtures: %s", err.Error())
+	}
+	if _, err = runtime.Run(); err != nil {
+		return runtime, err
+	}
+	events, err := fixtures.EventList(runtime.Env())
+	if err != nil {
+		return runtime, fmt.Errorf("fixtures: %s", err.Error())
+	}
+	_, err = runtime.Dispatch(events)
+	return runtime, err
+}
+
+func runCommand(c *cli, args []string) int {
+	flags := c.flags("run", "[flags] [file]")
+	var o runOptions
+	o.register(flags)
+	jsonEnv := flags.Bool("json", false, "print variables of the environment as JSON after the run")
+	stats := flags.Bool("stats", false, "print execution stats to stderr")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	source, path, err := c.source(flags)
+	if err != nil {
+		return exitUsage
+	}
+
+	runtime, err := execute(source, &o, c.stdout)
+	if runtime != nil && *stats {
+		s := runtime.Executor().Stats()
+		fmt.Fprintf(c.stderr, "operations: %d, max call depth: %d, allocations: %d, elapsed: %s\n",
+			s.TotalOperations(), s.MaxCallDepth, s.Allocations, s.Elapsed)
+	}
+	if err != nil {
+		c.reportError(path, err)
+		return exitFailure
+	}
+	if *jsonEnv {
+		vars, err := runtime.Env().GetVarsAsJson()
+		if err != nil {
+			fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+			return exitFailure
+		}
+		fmt.Fprintln(c.stdout, string(vars))
+	}
+	return exitOK
+}
+
+func checkCommand(c *cli, args []string) int {
+	flags := c.flags("check", "[flags] [file]")
+	fixturesPath := flags.String("fixtures", "", "JSON file with host values the program is checked against")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	source, path, err := c.source(flags)
+	if err != nil {
+		return exitUsage
+	}
+	env := fdalang.NewEnvironment()
+	fixtures, err := loadFixtures(*fixturesPath)
+	if err == nil && fixtures != nil {
+		err = fixtures.Apply(env)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: fixtures: %s\n", err.Error())
+		return exitUsage
+	}
+
+	program, parseErrors := fdalang.NewParser(fdalang.NewLexer(source)).ParseWithRecovery()
+	info := fdalang.NewExecAstVisitor().Check(program, env)
+	type checkError struct {
+		line, col int
+		msg       string
+	}
+	var errs []checkError
+	for _, e := range parseErrors {
+		errs = append(errs, checkError{e.Line, e.Col, e.Msg})
+	}
+	for _, e := range info.Errors {
+		errs = append(errs, checkError{e.Line, e.Col, e.Msg})
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].line < errs[j].line || errs[i].line == errs[j].line && errs[i].col < errs[j].col
+	})
+	for _, e := range errs {
+		fmt.Fprintf(c.stderr, "%s: %s\n", position(path, e.line, e.col), strings.TrimSpace(e.msg))
+	}
+	if len(errs) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// lintIssue is the issue of the file in the JSON output of lint
+type lintIssue struct {
+	File string `json:"file"`
+	*fdalang.LintIssue
+}
+
+func lintCommand(c *cli, args []string) int {
+	flags := c.flags("lint", "[flags] [path...]")
+	fixturesPath := flags.String("fixtures", "", "JSON file with host values the programs are checked against")
+	enable := flags.String("enable", "", "comma separated rules to run, all rules by default")
+	disable := flags.String("disable", "", "comma separated rules not to run")
+	asJSON := flags.Bool("json", false, "print issues as JSON array")
+	listRules := flags.Bool("rules", false, "list rules and exit")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	if *listRules {
+		for _, rule := range fdalang.LintRules() {
+			fmt.Fprintf(c.stdout, "%-16s %s\n", rule.Name, rule.Doc)
+		}
+		return exitOK
+	}
+	rules, err := fdalang.SelectLintRules(splitList(*enable), splitList(*disable))
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return exitUsage
+	}
+	fixtures, err := loadFixtures(*fixturesPath)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: fixtures: %s\n", err.Error())
+		return exitUsage
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := sourceFiles(paths)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return exitUsage
+	}
+
+	code := exitOK
+	issues := make([]lintIssue, 0)
+	for _, path := range files {
+		source, _, err := c.readSource(path)
+		if err != nil {
+			return exitUsage
+		}
+		program, err := fdalang.NewParser(fdalang.NewLexer(terminated(source))).Parse()
+		if err != nil {
+			c.reportError(path, err)
+			code = exitFailure
+			continue
+		}
+		env := fdalang.NewEnvironment()
+		if fixtures != nil {
+			if err = fixtures.Apply(env); err != nil {
+				fmt.Fprintf(c.stderr, "fda: fixtures: %s\n", err.Error())
+				return exitUsage
+			}
+		}
+		info := fdalang.NewExecAstVisitor().Check(program, env)
+		for _, issue := range fdalang.Lint(program, info, rules) {
+			issues = append(issues, lintIssue{File: path, LintIssue: issue})
+		}
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(issues, "", "  ")
+		if err != nil {
+			fmt.Fprintln(c.stderr, err.Error())
+			return exitFailure
+		}
+		fmt.Fprintln(c.stdout, string(data))
+	} else {
+		for _, issue := range issues {
+			fmt.Fprintf(c.stdout, "%s: %s (%s)\n", position(issue.File, issue.Line, issue.Col), issue.Msg, issue.Rule)
+		}
+	}
+	if len(issues) > 0 {
+		code = exitFailure
+	}
+	return code
+}
+
+// splitList splits the comma separated flag value, empty value is empty list
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func tokensCommand(c *cli, args []string) int {
+	flags := c.flags("tokens", "[file]")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	source, path, err := c.source(flags)
+	if err != nil {
+		return exitUsage
+	}
+
+	l := fdalang.NewLexer(source)
+	code := exitOK
+	for {
+		token, err := l.NextToken()
+		if err != nil {
+			c.reportError(path, err)
+			code = exitFailure
+		}
+		if token.ID == fdalang.TokenEOC {
+			return code
+		}
+		fmt.Fprintf(c.stdout, "%d:%d\t%s\t%q\n", token.Line, token.Col, token.ID, token.Value)
+	}
+}
+
+func astCommand(c *cli, args []string) int {
+	flags := c.flags("ast", "[-json] [file]")
+	asJSON := flags.Bool("json", false, "print the tree as JSON, see fdalang.MarshalAst")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	source, path, err := c.source(flags)
+	if err != nil {
+		return exitUsage
+	}
+
+	program, err := fdalang.NewParser(fdalang.NewLexer(source)).Parse()
+	if err != nil {
+		c.reportError(path, err)
+		return exitFailure
+	}
+	if *asJSON {
+		data, err := fdalang.MarshalAst(program)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err.Error())
+			return exitFailure
+		}
+		fmt.Fprintln(c.stdout, string(data))
+		return exitOK
+	}
+	if err = fdalang.DumpAst(c.stdout, program); err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func fmtCommand(c *cli, args []string) int {
+	flags := c.flags("fmt", "[-w] [-l] [file...]")
+	write := flags.Bool("w", false, "write result to the source file instead of stdout")
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{stdinName}
+	}
+
+	code := exitOK
+	for _, path := range paths {
+		source, name, err := c.readSource(path)
+		if err != nil {
+			return exitUsage
+		}
+		formatted, err := fdalang.Format(source)
+		if err != nil {
+			c.reportError(name, err)
+			code = exitFailure
+			continue
+		}
+		changed := formatted != source
+		if *list && changed {
+			fmt.Fprintln(c.stdout, name)
+		}
+		if *write && path != stdinName {
+			if changed {
+				if err = ioutil.WriteFile(path, []byte(formatted), 0644); err != nil {
+					fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+					return exitFailure
+				}
+			}
+		} else if !*list {
+			fmt.Fprint(c.stdout, formatted)
+		}
+	}
+	return code
+}
+
+const sourceExt = ".fda"
+
+func testCommand(c *cli, args []string) int {
+	flags := c.flags("test", "[flags] [path...]")
+	var o runOptions
+	o.register(flags)
+	verbose := flags.Bool("v", false, "print output of the programs and passed tests")
+	run := flags.String("run", "", "run only tests with names matching the regular expression")
+	cover := flags.Bool("cover", false, "print coverage of statements and branches")
+	coverProfile := flags.String("coverprofile", "", "write coverage profile in the format of go test to the file")
+	coverReport := flags.String("coverreport", "", "write annotated sources to the file, HTML if the file name ends with .html")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: -run: %s\n", err.Error())
+		return exitUsage
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	fixtures, err := loadFixtures(o.fixtures)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: fixtures: %s\n", err.Error())
+		return exitUsage
+	}
+	options, err := programOptions(fixtures)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return exitUsage
+	}
+	files, err := sourceFiles(paths)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return exitUsage
+	}
+
+	output := ioutil.Discard
+	if *verbose {
+		output = c.stdout
+	}
+	collectCoverage := *cover || *coverProfile != "" || *coverReport != ""
+	var coverages []*fdalang.Coverage
+	code := exitOK
+	for _, path := range files {
+		source, _, err := c.readSource(path)
+		if err != nil {
+			return exitUsage
+		}
+		started := time.Now()
+		program, err := fdalang.NewProgramWithOptions(terminated(source), options)
+		o.coverage = nil
+		if err == nil && collectCoverage {
+			o.coverage = fdalang.NewCoverage(path, source, program.Ast())
+			coverages = append(coverages, o.coverage)
+		}
+		if err == nil && len(program.Tests()) == 0 {
+			// files without tests are checked to run without errors
+			_, err = execute(terminated(source), &o, output)
+		} else if err == nil {
+			err = c.runTests(path, program, fdalang.TestOptions{
+				Fixtures: fixtures,
+				Setup:    func(r *fdalang.Runtime) { o.configure(r.Executor(), output) },
+				Filter:   filter.MatchString,
+			}, *verbose)
+		}
+		if err != nil {
+			fmt.Fprintf(c.stdout, "FAIL\t%s", path)
+			if *cover && o.coverage != nil {
+				fmt.Fprintf(c.stdout, "\tcoverage: %s", o.coverage.Summary())
+			}
+			fmt.Fprintln(c.stdout)
+			if err != errTestsFailed {
+				c.reportError(path, err)
+			}
+			code = exitFailure
+			continue
+		}
+		fmt.Fprintf(c.stdout, "ok\t%s\t%s", path, time.Since(started).Round(time.Microsecond))
+		if *cover {
+			fmt.Fprintf(c.stdout, "\tcoverage: %s", o.coverage.Summary())
+		}
+		fmt.Fprintln(c.stdout)
+	}
+
+	if err = c.writeCoverage(*coverProfile, *coverReport, coverages); err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return exitUsage
+	}
+	return code
+}
+
+// writeCoverage writes coverage of all programs to the files, empty path means the file is not needed
+func (c *cli) writeCoverage(profilePath, reportPath string, coverages []*fdalang.Coverage) error {
+	write := func(path string, content func(w io.Writer) error) error {
+		if path == "" {
+			return nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err = content(f); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	err := write(profilePath, func(w io.Writer) error {
+		return fdalang.WriteCoverProfile(w, coverages...)
+	})
+	if err != nil {
+		return err
+	}
+	return write(reportPath, func(w io.Writer) error {
+		if strings.HasSuffix(reportPath, ".html") {
+			return fdalang.WriteCoverHTML(w, coverages...)
+		}
+		for _, coverage := range coverages {
+			if err := coverage.WriteReport(w); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	})
+}
+
+// errTestsFailed is returned by runTests when failures are already reported
+var errTestsFailed = errors.New("tests failed")
+
+// runTests runs `test` blocks of the program, each in its own environment, and reports failed ones
+func (c *cli) runTests(path string, program *fdalang.Program, options fdalang.TestOptions, verbose bool) error {
+	failed := false
+	for _, result := range fdalang.RunTests(program, options) {
+		elapsed := result.Elapsed.Round(time.Microsecond)
+		if result.Err == nil {
+			if verbose {
+				fmt.Fprintf(c.stdout, "--- PASS: %s (%s)\n", result.Test.Name, elapsed)
+			}
+			continue
+		}
+		failed = true
+		fmt.Fprintf(c.stdout, "--- FAIL: %s (%s)\n", result.Test.Name, elapsed)
+		c.reportError(path, result.Err)
+	}
+	if failed {
+		return errTestsFailed
+	}
+	return nil
+}
+
+// sourceFiles returns the files and *.fda files of the directories, recursively
+func sourceFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(file) == sourceExt {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func dapCommand(c *cli, args []string) int {
+	// stdout is used by the protocols of the servers, so logs go to stderr only
+	if err := dap.NewServer(c.stdin, c.stdout).Serve(); err != nil {
+		fmt.Fprintf(c.stderr, "Debug adapter error: %s\n", err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+func lspCommand(c *cli, args []string) int {
+	if err := lsp.NewServer(c.stdin, c.stdout).Serve(); err != nil {
+		fmt.Fprintf(c.stderr, "Language server error: %s\n", err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+func replCommand(c *cli, args []string) int {
+	flags := c.flags("repl", "[flags]")
+	fixturesPath := flags.String("fixtures", "", "JSON file with host values available in the environment")
+	budget := flags.Int("budget", 0, "maximum number of operations of one input, 0 means no limit")
+	depth := flags.Int("depth", 0, "maximum depth of nested function calls, 0 means no limit")
+	seed := flags.Int64("seed", 0, "seed of the random generator")
+	if code, ok := c.parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	r := repl.New(c.stdin, c.stdout)
+	executor := r.Executor()
+	executor.SetRandSeed(*seed)
+	executor.SetLimits(fdalang.ExecLimits{Operations: *budget, CallDepth: *depth})
+	fixtures, err := loadFixtures(*fixturesPath)
+	if err == nil && fixtures != nil {
+		err = r.SetFixtures(fixtures)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "fda: fixtures: %s\n", err.Error())
+		return exitUsage
+	}
+	if err = r.Run(); err != nil {
+		fmt.Fprintf(c.stderr, "fda: %s\n", err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
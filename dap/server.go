@@ -0,0 +1,551 @@
+// Package dap implements Debug Adapter Protocol server for FDALang programs,
+// so the programs could be debugged from VS Code and other editors.
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/justclimber/fda-lang/fdalang"
+	"github.com/justclimber/fda-lang/internal/baseprotocol"
+)
+
+// threadID is the only thread of the program
+const threadID = 1
+
+var errNotStopped = errors.New("program is not stopped")
+
+// stopCommand is executed in the program goroutine while it's stopped in the debugger,
+// it returns true when the program should be resumed with the action
+type stopCommand func(state *fdalang.DebugState) (fdalang.DebugAction, bool)
+
+// Server serves one debug session over the reader and writer, usually stdin and stdout.
+// Requests are handled in the Serve goroutine, the program runs in its own goroutine
+// and inspection of the stopped program is done by commands executed in the program goroutine.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex
+	seq     int
+
+	debugger *fdalang.Debugger
+	commands chan stopCommand
+
+	// mu protects fields below
+	mu         sync.Mutex
+	runtime    *fdalang.Runtime
+	fixtures   *fdalang.Fixtures
+	sourcePath string
+	configured bool
+	started    bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+	// stop is closed when the program leaves the current stop, nil while the program runs
+	stop chan struct{}
+
+	// refs are variables references of the current stop, used only in the program goroutine
+	refs []interface{}
+}
+
+// scopeRef is the reference to variables of a scope
+type scopeRef struct {
+	vars map[string]fdalang.Object
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		in:       bufio.NewReader(in),
+		out:      out,
+		commands: make(chan stopCommand),
+		done:     make(chan struct{}),
+	}
+	s.debugger = fdalang.NewDebugger(s.onStop)
+	return s
+}
+
+// Serve handles requests until disconnect request or the end of input
+func (s *Server) Serve() error {
+	defer s.terminate()
+	for {
+		content, err := baseprotocol.ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err = json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("invalid message: %s", err.Error())
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if req.Command == "disconnect" {
+			s.terminate()
+			s.respond(&req, nil)
+			return nil
+		}
+		if err = s.handle(&req); err != nil {
+			s.respondError(&req, err)
+		}
+	}
+}
+
+func (s *Server) handle(req *request) error {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		})
+		s.sendEvent("initialized", nil)
+		return nil
+	case "launch":
+		var args launchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return err
+		}
+		if err := s.launch(args); err != nil {
+			return err
+		}
+		s.respond(req, nil)
+		s.startIfReady()
+		return nil
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return err
+		}
+		s.respond(req, map[string]interface{}{"breakpoints": s.setBreakpoints(args)})
+		return nil
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+		s.respond(req, nil)
+		s.startIfReady()
+		return nil
+	case "threads":
+		s.respond(req, map[string]interface{}{"threads": []thread{{ID: threadID, Name: "main"}}})
+		return nil
+	case "pause":
+		s.debugger.Pause()
+		s.respond(req, nil)
+		return nil
+	case "terminate":
+		s.terminate()
+		s.respond(req, nil)
+		return nil
+	case "continue":
+		return s.resume(req, fdalang.DebugContinue, map[string]interface{}{"allThreadsContinued": true})
+	case "next":
+		return s.resume(req, fdalang.DebugStepOver, nil)
+	case "stepIn":
+		return s.resume(req, fdalang.DebugStepIn, nil)
+	case "stepOut":
+		return s.resume(req, fdalang.DebugStepOut, nil)
+	case "stackTrace":
+		return s.inspect(func(state *fdalang.DebugState) (interface{}, error) {
+			return s.stackTrace(state), nil
+		}, req)
+	case "scopes":
+		var args frameArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return err
+		}
+		return s.inspect(func(state *fdalang.DebugState) (interface{}, error) {
+			return s.scopes(state, args.FrameID)
+		}, req)
+	case "variables":
+		var args variablesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return err
+		}
+		return s.inspect(func(state *fdalang.DebugState) (interface{}, error) {
+			return s.variables(args.VariablesReference)
+		}, req)
+	case "evaluate":
+		var args evaluateArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return err
+		}
+		return s.inspect(func(state *fdalang.DebugState) (interface{}, error) {
+			return s.evaluate(state, args)
+		}, req)
+	default:
+		return fmt.Errorf("unsupported command '%s'", req.Command)
+	}
+}
+
+func (s *Server) launch(args launchArguments) error {
+	sourceCode, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	var fixtures *fdalang.Fixtures
+	if args.Fixtures != "" {
+		f, err := os.Open(args.Fixtures)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if fixtures, err = fdalang.LoadFixtures(f); err != nil {
+			return err
+		}
+	}
+	// the program is checked with declarations of the fixtures
+	var options fdalang.ProgramOptions
+	if fixtures != nil {
+		options.Env = fdalang.NewEnvironment()
+		if err = fixtures.Apply(options.Env); err != nil {
+			return fmt.Errorf("fixtures: %s", err.Error())
+		}
+	}
+	program, err := fdalang.NewProgramWithOptions(string(sourceCode), options)
+	if err != nil {
+		return fmt.Errorf("parsing error: %s", err.Error())
+	}
+	runtime := fdalang.NewRuntime(program)
+	runtime.Executor().SetOutput(&outputWriter{server: s, category: "stdout"})
+	if fixtures != nil {
+		if err = fixtures.Apply(runtime.Env()); err != nil {
+			return fmt.Errorf("fixtures: %s", err.Error())
+		}
+	}
+	if !args.NoDebug {
+		runtime.Executor().SetDebugger(s.debugger)
+		if args.StopOnEntry {
+			s.debugger.Pause()
+		}
+	}
+
+	sourcePath, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runtime != nil {
+		return errors.New("program is already launched")
+	}
+	s.runtime = runtime
+	s.fixtures = fixtures
+	s.sourcePath = sourcePath
+	return nil
+}
+
+// startIfReady starts the program when it's launched and configured
+func (s *Server) startIfReady() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.runtime == nil || !s.configured {
+		return
+	}
+	s.started = true
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(ctx, s.runtime, s.fixtures)
+}
+
+func (s *Server) run(ctx context.Context, runtime *fdalang.Runtime, fixtures *fdalang.Fixtures) {
+	defer close(s.done)
+	_, err := runtime.RunContext(ctx)
+	if err == nil && fixtures != nil && len(fixtures.Events) > 0 {
+		var events []fdalang.Event
+		if events, err = fixtures.EventList(runtime.Env()); err == nil {
+			_, err = runtime.DispatchContext(ctx, events)
+		}
+	}
+
+	exitCode := 0
+	if err != nil && !errors.Is(err, fdalang.ErrDebugTerminated) && !errors.Is(err, context.Canceled) {
+		exitCode = 1
+		s.sendEvent("output", outputEvent{Category: "stderr", Output: "Runtime error: " + err.Error() + "\n"})
+	}
+	s.sendEvent("exited", exitedEvent{ExitCode: exitCode})
+	s.sendEvent("terminated", nil)
+}
+
+// terminate stops the running program and waits for it
+func (s *Server) terminate() {
+	s.mu.Lock()
+	started, cancel := s.started, s.cancel
+	s.mu.Unlock()
+	if !started {
+		return
+	}
+	// running program stops on the next statement, stopped one is resumed with terminate action
+	cancel()
+	select {
+	case s.commands <- func(*fdalang.DebugState) (fdalang.DebugAction, bool) {
+		s.setStop(nil)
+		return fdalang.DebugTerminate, true
+	}:
+	case <-s.done:
+	}
+	<-s.done
+}
+
+func (s *Server) setBreakpoints(args setBreakpointsArguments) []breakpoint {
+	s.debugger.ClearBreakpoints()
+	result := make([]breakpoint, len(args.Breakpoints))
+	for i, sb := range args.Breakpoints {
+		result[i] = breakpoint{Verified: true, Line: sb.Line}
+		if _, err := s.debugger.SetBreakpoint(sb.Line, sb.Condition); err != nil {
+			result[i].Verified = false
+			result[i].Message = err.Error()
+		}
+	}
+	return result
+}
+
+// onStop is the debugger hook, it executes commands until one of them resumes the program
+func (s *Server) onStop(state *fdalang.DebugState) fdalang.DebugAction {
+	stop := make(chan struct{})
+	s.setStop(stop)
+	defer func() {
+		s.setStop(nil)
+		close(stop)
+	}()
+	s.refs = nil
+
+	stopped := stoppedEvent{Reason: string(state.Reason), ThreadID: threadID, AllThreadsStopped: true}
+	if state.ConditionError != nil {
+		stopped.Description = state.ConditionError.Error()
+	}
+	s.sendEvent("stopped", stopped)
+
+	for cmd := range s.commands {
+		if action, resume := cmd(state); resume {
+			return action
+		}
+	}
+	return fdalang.DebugTerminate
+}
+
+func (s *Server) setStop(stop chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop = stop
+}
+
+// execute runs the command in the program goroutine if the program is stopped. The program could be
+// resumed by the previous command after the check, then it doesn't receive commands and the stop is closed
+func (s *Server) execute(cmd stopCommand) error {
+	s.mu.Lock()
+	stop := s.stop
+	s.mu.Unlock()
+	if stop == nil {
+		return errNotStopped
+	}
+	select {
+	case s.commands <- cmd:
+		return nil
+	case <-stop:
+		return errNotStopped
+	}
+}
+
+func (s *Server) resume(req *request, action fdalang.DebugAction, body interface{}) error {
+	return s.execute(func(*fdalang.DebugState) (fdalang.DebugAction, bool) {
+		// reset before the response, client may send next requests right after it
+		s.setStop(nil)
+		s.respond(req, body)
+		return action, true
+	})
+}
+
+func (s *Server) inspect(fn func(state *fdalang.DebugState) (interface{}, error), req *request) error {
+	return s.execute(func(state *fdalang.DebugState) (fdalang.DebugAction, bool) {
+		body, err := fn(state)
+		if err != nil {
+			s.respondError(req, err)
+		} else {
+			s.respond(req, body)
+		}
+		return fdalang.DebugContinue, false
+	})
+}
+
+func (s *Server) stackTrace(state *fdalang.DebugState) interface{} {
+	frames := make([]stackFrame, len(state.Stack))
+	for i, f := range state.Stack {
+		frames[i] = stackFrame{
+			ID:     i + 1,
+			Name:   f.Function,
+			Source: &source{Name: filepath.Base(s.sourcePath), Path: s.sourcePath},
+			Line:   f.Line,
+			Column: f.Col,
+		}
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (s *Server) frame(state *fdalang.DebugState, frameID int) (*fdalang.DebugFrame, error) {
+	if frameID < 1 || frameID > len(state.Stack) {
+		return nil, fmt.Errorf("unknown frame %d", frameID)
+	}
+	return state.Stack[frameID-1], nil
+}
+
+func (s *Server) scopes(state *fdalang.DebugState, frameID int) (interface{}, error) {
+	frame, err := s.frame(state, frameID)
+	if err != nil {
+		return nil, err
+	}
+	debugScopes := frame.Scopes()
+	scopes := make([]scope, 0, len(debugScopes)+1)
+	for i, ds := range debugScopes {
+		name := fmt.Sprintf("Closure #%d", i)
+		switch {
+		case i == 0:
+			name = "Locals"
+		case i == len(debugScopes)-1:
+			name = "Globals"
+		}
+		scopes = append(scopes, scope{Name: name, VariablesReference: s.ref(scopeRef{vars: ds.Vars})})
+	}
+	if mem := frame.Env.Memory(); mem != nil {
+		vars := make(map[string]fdalang.Object)
+		for _, name := range mem.Keys() {
+			vars[name], _ = mem.Get(name)
+		}
+		scopes = append(scopes, scope{Name: "Memory", VariablesReference: s.ref(scopeRef{vars: vars})})
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *Server) variables(ref int) (interface{}, error) {
+	if ref < 1 || ref > len(s.refs) {
+		return nil, fmt.Errorf("unknown variables reference %d", ref)
+	}
+	var vars []variable
+	switch r := s.refs[ref-1].(type) {
+	case scopeRef:
+		names := make([]string, 0, len(r.vars))
+		for name := range r.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			vars = append(vars, s.variable(name, r.vars[name]))
+		}
+	case *fdalang.ObjStruct:
+		names := make([]string, 0, len(r.Fields))
+		for name := range r.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			vars = append(vars, s.variable(name, r.Fields[name]))
+		}
+	case *fdalang.ObjArray:
+		for i, el := range r.Elements {
+			vars = append(vars, s.variable(fmt.Sprintf("[%d]", i), el))
+		}
+	}
+	if vars == nil {
+		vars = []variable{}
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (s *Server) variable(name string, obj fdalang.Object) variable {
+	return variable{
+		Name:               name,
+		Value:              obj.Inspect(),
+		Type:               string(obj.Type()),
+		VariablesReference: s.objectRef(obj),
+	}
+}
+
+func (s *Server) evaluate(state *fdalang.DebugState, args evaluateArguments) (interface{}, error) {
+	frameID := args.FrameID
+	if frameID == 0 {
+		frameID = 1
+	}
+	if _, err := s.frame(state, frameID); err != nil {
+		return nil, err
+	}
+	obj, err := state.Evaluate(frameID-1, strings.TrimSpace(args.Expression))
+	if err != nil {
+		return nil, err
+	}
+	return evaluateResponse{
+		Result:             obj.Inspect(),
+		Type:               string(obj.Type()),
+		VariablesReference: s.objectRef(obj),
+	}, nil
+}
+
+// objectRef returns reference for structs and arrays which could be expanded, 0 for others
+func (s *Server) objectRef(obj fdalang.Object) int {
+	switch o := obj.(type) {
+	case *fdalang.ObjStruct:
+		if len(o.Fields) > 0 {
+			return s.ref(o)
+		}
+	case *fdalang.ObjArray:
+		if len(o.Elements) > 0 {
+			return s.ref(o)
+		}
+	}
+	return 0
+}
+
+func (s *Server) ref(v interface{}) int {
+	s.refs = append(s.refs, v)
+	return len(s.refs)
+}
+
+func (s *Server) send(msg interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	// client is gone if writing fails, nothing could be reported
+	_ = baseprotocol.WriteMessage(s.out, msg)
+}
+
+func (s *Server) respond(req *request, body interface{}) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) respondError(req *request, err error) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+func (s *Server) sendEvent(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// outputWriter sends program output as output events
+type outputWriter struct {
+	server   *Server
+	category string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.server.sendEvent("output", outputEvent{Category: w.category, Output: string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,278 @@
+package dap
+
+import (
+	"github.com/justclimber/fda-lang/internal/baseprotocol"
+	"github.com/stretchr/testify/require"
+
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProgram = `add = fn(int a, int b) int {
+   c = a + b
+   return c
+}
+x = add(mech.hp, 1)
+print(x)
+y = x
+on damaged(int amount) {
+   mech.hp = mech.hp - amount
+}
+`
+
+const testFixtures = `{
+  "structs": {"mech": {"hp": "int", "targets": "[]vec2"}},
+  "vars": {"mech": {"type": "mech", "value": {"hp": 10, "targets": [{"x": 1, "y": 2}]}}},
+  "events": [{"name": "damaged", "args": [{"type": "int", "value": 3}]}]
+}`
+
+type testClient struct {
+	t        *testing.T
+	in       io.WriteCloser
+	messages chan map[string]interface{}
+	seq      int
+	// pending are messages read while waiting for other ones
+	pending []map[string]interface{}
+}
+
+func newTestClient(t *testing.T) (*testClient, chan error) {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &testClient{t: t, in: clientOut, messages: make(chan map[string]interface{}, 100)}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- NewServer(serverIn, serverOut).Serve()
+		_ = serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			content, err := baseprotocol.ReadMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg map[string]interface{}
+			if err = json.Unmarshal(content, &msg); err != nil {
+				panic(err)
+			}
+			c.messages <- msg
+		}
+	}()
+	return c, served
+}
+
+func (c *testClient) request(command string, arguments interface{}) map[string]interface{} {
+	c.send(command, arguments)
+	return c.response(command)
+}
+
+// send writes the request without waiting for the response
+func (c *testClient) send(command string, arguments interface{}) {
+	c.seq++
+	require.Nil(c.t, baseprotocol.WriteMessage(c.in, map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": arguments,
+	}))
+}
+
+func (c *testClient) response(command string) map[string]interface{} {
+	return c.wait(func(msg map[string]interface{}) bool {
+		return msg["type"] == "response" && msg["command"] == command
+	})
+}
+
+func (c *testClient) body(command string, arguments interface{}) map[string]interface{} {
+	resp := c.request(command, arguments)
+	require.Equal(c.t, true, resp["success"], resp["message"])
+	body, _ := resp["body"].(map[string]interface{})
+	return body
+}
+
+func (c *testClient) event(name string) map[string]interface{} {
+	msg := c.wait(func(msg map[string]interface{}) bool {
+		return msg["type"] == "event" && msg["event"] == name
+	})
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+func (c *testClient) wait(match func(msg map[string]interface{}) bool) map[string]interface{} {
+	for i, msg := range c.pending {
+		if match(msg) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return msg
+		}
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			require.True(c.t, ok, "server closed the connection")
+			if match(msg) {
+				return msg
+			}
+			c.pending = append(c.pending, msg)
+		case <-timeout:
+			c.t.Fatalf("timeout, pending messages: %v", c.pending)
+		}
+	}
+}
+
+func (c *testClient) variables(ref interface{}) map[string]string {
+	body := c.body("variables", map[string]interface{}{"variablesReference": ref})
+	vars := make(map[string]string)
+	for _, v := range body["variables"].([]interface{}) {
+		variable := v.(map[string]interface{})
+		vars[variable["name"].(string)] = variable["value"].(string)
+	}
+	return vars
+}
+
+func writeTestFiles(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "bot.fda")
+	fixtures := filepath.Join(dir, "fixtures.json")
+	require.Nil(t, ioutil.WriteFile(program, []byte(testProgram), 0644))
+	require.Nil(t, ioutil.WriteFile(fixtures, []byte(testFixtures), 0644))
+	return program, fixtures
+}
+
+func TestDebugSession(t *testing.T) {
+	program, fixtures := writeTestFiles(t)
+	c, served := newTestClient(t)
+
+	caps := c.body("initialize", map[string]interface{}{"adapterID": "fda"})
+	require.Equal(t, true, caps["supportsConditionalBreakpoints"])
+	c.event("initialized")
+	c.body("launch", map[string]interface{}{"program": program, "fixtures": fixtures})
+	breakpoints := c.body("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 2, "condition": "a > 5"}},
+	})
+	require.Equal(t, true, breakpoints["breakpoints"].([]interface{})[0].(map[string]interface{})["verified"])
+	c.body("configurationDone", nil)
+
+	stopped := c.event("stopped")
+	require.Equal(t, "breakpoint", stopped["reason"])
+
+	stack := c.body("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+	require.Len(t, stack, 2)
+	top := stack[0].(map[string]interface{})
+	require.Equal(t, "add", top["name"])
+	require.Equal(t, float64(2), top["line"])
+	require.Equal(t, program, top["source"].(map[string]interface{})["path"])
+
+	scopes := c.body("scopes", map[string]interface{}{"frameId": 1})["scopes"].([]interface{})
+	require.Len(t, scopes, 2)
+	locals := scopes[0].(map[string]interface{})
+	require.Equal(t, "Locals", locals["name"])
+	require.Equal(t, map[string]string{"a": "10", "b": "1"}, c.variables(locals["variablesReference"]))
+
+	globals := c.body("variables", map[string]interface{}{
+		"variablesReference": scopes[1].(map[string]interface{})["variablesReference"],
+	})["variables"].([]interface{})
+	var mechRef interface{}
+	for _, v := range globals {
+		if v.(map[string]interface{})["name"] == "mech" {
+			mechRef = v.(map[string]interface{})["variablesReference"]
+		}
+	}
+	require.NotEqual(t, float64(0), mechRef, "struct should be expandable")
+	mech := c.variables(mechRef)
+	require.Equal(t, "10", mech["hp"])
+	require.Equal(t, "[]vec2{vec2{x: 1.00, y: 2.00}}", mech["targets"])
+
+	evaluated := c.body("evaluate", map[string]interface{}{"expression": "a * 2", "frameId": 1})
+	require.Equal(t, "20", evaluated["result"])
+
+	c.body("next", map[string]interface{}{"threadId": 1})
+	require.Equal(t, "step", c.event("stopped")["reason"])
+	stack = c.body("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+	require.Equal(t, float64(3), stack[0].(map[string]interface{})["line"])
+
+	c.body("continue", map[string]interface{}{"threadId": 1})
+	require.Equal(t, "11\n", c.event("output")["output"])
+	require.Equal(t, float64(0), c.event("exited")["exitCode"])
+	c.event("terminated")
+
+	c.body("disconnect", nil)
+	require.Nil(t, <-served)
+}
+
+func TestDebugSessionTerminate(t *testing.T) {
+	program, fixtures := writeTestFiles(t)
+	c, served := newTestClient(t)
+
+	c.body("initialize", nil)
+	c.body("launch", map[string]interface{}{"program": program, "fixtures": fixtures, "stopOnEntry": true})
+	c.body("configurationDone", nil)
+	require.Equal(t, "pause", c.event("stopped")["reason"])
+
+	resp := c.request("continue", nil)
+	require.Equal(t, true, resp["success"])
+	resp = c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	if resp["success"] == true {
+		// program could stop again only at the end, it has no breakpoints
+		t.Fatalf("program should not be stopped: %v", resp)
+	}
+
+	c.body("disconnect", nil)
+	require.Nil(t, <-served)
+}
+
+func TestDebugSessionPipelinedRequests(t *testing.T) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "bot.fda")
+	require.Nil(t, ioutil.WriteFile(program, []byte(strings.Repeat("a = 1\n", 50)), 0644))
+	c, served := newTestClient(t)
+
+	c.body("initialize", nil)
+	c.body("launch", map[string]interface{}{"program": program, "stopOnEntry": true})
+	c.body("configurationDone", nil)
+	require.Equal(t, "pause", c.event("stopped")["reason"])
+
+	// requests sent right after resume are answered whether the program is already stopped again or not
+	for i := 0; i < 20; i++ {
+		c.send("next", map[string]interface{}{"threadId": 1})
+		c.send("stackTrace", map[string]interface{}{"threadId": 1})
+		require.Equal(t, true, c.response("next")["success"])
+		c.response("stackTrace")
+		require.Equal(t, "step", c.event("stopped")["reason"])
+	}
+	c.send("continue", nil)
+	c.send("scopes", map[string]interface{}{"frameId": 1})
+	require.Equal(t, true, c.response("continue")["success"])
+	c.response("scopes")
+	c.event("terminated")
+
+	c.body("disconnect", nil)
+	require.Nil(t, <-served)
+}
+
+func TestDebugSessionLaunchErrors(t *testing.T) {
+	c, served := newTestClient(t)
+	c.body("initialize", nil)
+
+	resp := c.request("launch", map[string]interface{}{"program": "/nonexistent/bot.fda"})
+	require.Equal(t, false, resp["success"])
+
+	dir := t.TempDir()
+	program := filepath.Join(dir, "bot.fda")
+	require.Nil(t, ioutil.WriteFile(program, []byte("a = \n"), 0644))
+	resp = c.request("launch", map[string]interface{}{"program": program})
+	require.Equal(t, false, resp["success"])
+	require.Contains(t, resp["message"], "parsing error")
+
+	resp = c.request("stackTrace", nil)
+	require.Equal(t, false, resp["success"], "program is not running")
+
+	c.body("disconnect", nil)
+	require.Nil(t, <-served)
+}
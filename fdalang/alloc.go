@@ -0,0 +1,52 @@
+package fdalang
+
+// AllocStats is allocation accounting of the executor. Allocations are counted in objects:
+// array is 1 plus its elements, struct is 1 plus its fields, including arrays and structs
+// returned by builtins. Scalars and vec2 are values and are not counted.
+type AllocStats struct {
+	// Allocated is the number of objects allocated by the current or the last execution
+	Allocated int
+	// Peak is the maximum of Allocated over all executions of the executor
+	Peak int
+	// Limit is the maximum number of objects one execution may allocate, 0 means no limit
+	Limit int
+}
+
+// SetAllocLimit limits the number of objects allocated by one execution, i.e. one call
+// of ExecAst, Dispatch or Call. Execution fails with runtime error when the limit is exceeded.
+// 0 means no limit
+func (e *ExecAstVisitor) SetAllocLimit(limit int) {
+	e.allocStats.Limit = limit
+}
+
+func (e *ExecAstVisitor) AllocStats() AllocStats {
+	return e.allocStats
+}
+
+// resetAllocated starts accounting of a new execution, see beginExecution
+func (e *ExecAstVisitor) resetAllocated() {
+	e.allocStats.Allocated = 0
+}
+
+func (e *ExecAstVisitor) alloc(node AstNode, size int) error {
+	stats := &e.allocStats
+	if stats.Limit > 0 && stats.Allocated+size > stats.Limit {
+		return runtimeError(node, "allocation limit exceeded: %d of %d objects allocated, %d more requested",
+			stats.Allocated, stats.Limit, size)
+	}
+	stats.Allocated += size
+	if stats.Allocated > stats.Peak {
+		stats.Peak = stats.Allocated
+	}
+	return nil
+}
+
+// allocResult accounts composite objects created outside of the interpreter, e.g. returned by builtins
+func (e *ExecAstVisitor) allocResult(node AstNode, obj Object) error {
+	switch obj.(type) {
+	case *ObjArray, *ObjStruct:
+		return e.alloc(node, ObjectSize(obj))
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestAllocStats(t *testing.T) {
+	input := `struct point {
+   float x
+   float y
+}
+p = point{x = 1., y = 2.}
+a = []int{1, 2, 3}
+b = ?[]int
+v = vec2{x = 1., y = 2.} + vec2{x = 1., y = 2.}
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	_, err = r.Run()
+	require.Nil(t, err)
+	require.Equal(t, AllocStats{Allocated: 8, Peak: 8}, r.Executor().AllocStats())
+
+	r = NewRuntime(program)
+	r.Executor().SetAllocLimit(8)
+	_, err = r.Run()
+	require.Nil(t, err)
+	require.Equal(t, AllocStats{Allocated: 8, Peak: 8, Limit: 8}, r.Executor().AllocStats())
+}
+
+func TestAllocLimitNegative(t *testing.T) {
+	input := `a = []int{1, 2}
+b = []int{1, 2, 3, 4}
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	r.Executor().SetAllocLimit(5)
+	_, err = r.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "allocation limit exceeded")
+	require.Contains(t, err.Error(), "line:2")
+	require.Equal(t, 3, r.Executor().AllocStats().Peak)
+}
+
+func TestAllocLimitIsPerExecution(t *testing.T) {
+	input := `struct bag {
+   []int items
+}
+make = fn() bag {
+   return bag{items = []int{1, 2, 3}}
+}
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	r.Executor().SetAllocLimit(6)
+	_, err = r.Run()
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = r.Call("make")
+		require.Nil(t, err)
+	}
+	require.Equal(t, 6, r.Executor().AllocStats().Peak)
+}
+
+func TestAllocLimitReentrantCall(t *testing.T) {
+	input := `struct bag {
+   []int items
+}
+make = fn() bag {
+   return bag{items = []int{1, 2, 3}}
+}
+a = []int{1, 2, 3}
+b = callMake()
+c = []int{1, 2, 3}
+`
+	var r *Runtime
+	program, err := NewProgramWithOptions(input, ProgramOptions{Builtins: map[string]*ObjBuiltin{
+		"callMake": {
+			Name:       "callMake",
+			ArgTypes:   ArgTypes{},
+			ReturnType: "bag",
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return r.Call("make")
+			},
+		},
+	}})
+	require.Nil(t, err)
+
+	r = NewRuntime(program)
+	_, err = r.Run()
+	require.Nil(t, err)
+	require.Equal(t, 19, r.Executor().AllocStats().Allocated, "the nested call doesn't reset the outer allocations")
+
+	r = NewRuntime(program)
+	r.Executor().SetAllocLimit(16)
+	_, err = r.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "allocation limit exceeded: 15 of 16 objects allocated")
+	require.Contains(t, err.Error(), "line:9")
+}
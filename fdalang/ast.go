@@ -2,6 +2,8 @@ package fdalang
 
 type AstNode interface {
 	GetToken() Token
+	// String returns the source of the node in the canonical form, see Format
+	String() string
 }
 
 type AstExpression interface {
@@ -16,6 +18,8 @@ type AstStatement interface {
 
 type AstStatementsBlock struct {
 	Statements []AstStatement
+	// End is the token the block is terminated with, e.g. '}' or the next 'case'
+	End Token
 }
 
 type AstAssignment struct {
@@ -204,6 +208,31 @@ type AstSwitch struct {
 
 func (node *AstSwitch) Statement() {}
 
+type AstEventHandler struct {
+	Token           Token
+	Event           string
+	Arguments       []*AstVarAndType
+	StatementsBlock *AstStatementsBlock
+}
+
+func (node *AstEventHandler) Statement() {}
+
+// AstTest is `test "name" {...}`, it's executed only by the test runner, see RunTests
+type AstTest struct {
+	Token           Token
+	Name            string
+	StatementsBlock *AstStatementsBlock
+}
+
+func (node *AstTest) Statement() {}
+
+type AstPersist struct {
+	Token      Token
+	Assignment *AstAssignment
+}
+
+func (node *AstPersist) Statement() {}
+
 func (node *AstAssignment) GetToken() Token                    { return node.Token }
 func (node *AstStructFieldAssignment) GetToken() Token         { return node.Token }
 func (node *AstUnary) GetToken() Token                         { return node.Token }
@@ -228,6 +257,9 @@ func (node *AstEnumElementCall) GetToken() Token               { return node.Tok
 func (node *AstSwitch) GetToken() Token                        { return node.Token }
 func (node *AstCase) GetToken() Token                          { return node.Token }
 func (node *AstEmptier) GetToken() Token                       { return node.Token }
+func (node *AstEventHandler) GetToken() Token                  { return node.Token }
+func (node *AstPersist) GetToken() Token                       { return node.Token }
+func (node *AstTest) GetToken() Token                          { return node.Token }
 func (node *AstStatementsBlock) GetToken() Token {
 	if len(node.Statements) > 0 {
 		return node.Statements[0].GetToken()
@@ -235,3 +267,32 @@ func (node *AstStatementsBlock) GetToken() Token {
 	tok := Token{}
 	return tok
 }
+
+func (node *AstStatementsBlock) String() string               { return nodeString(node) }
+func (node *AstAssignment) String() string                    { return nodeString(node) }
+func (node *AstStructFieldAssignment) String() string         { return nodeString(node) }
+func (node *AstUnary) String() string                         { return nodeString(node) }
+func (node *AstBinOperation) String() string                  { return nodeString(node) }
+func (node *AstIdentifier) String() string                    { return nodeString(node) }
+func (node *AstNumInt) String() string                        { return nodeString(node) }
+func (node *AstNumFloat) String() string                      { return nodeString(node) }
+func (node *AstArray) String() string                         { return nodeString(node) }
+func (node *AstArrayIndexCall) String() string                { return nodeString(node) }
+func (node *AstBoolean) String() string                       { return nodeString(node) }
+func (node *AstReturn) String() string                        { return nodeString(node) }
+func (node *AstStatementWithVoidedExpression) String() string { return nodeString(node) }
+func (node *AstFunction) String() string                      { return nodeString(node) }
+func (node *AstVarAndType) String() string                    { return nodeString(node) }
+func (node *AstFunctionCall) String() string                  { return nodeString(node) }
+func (node *AstIf) String() string                            { return nodeString(node) }
+func (node *AstStructDefinition) String() string              { return nodeString(node) }
+func (node *AstStruct) String() string                        { return nodeString(node) }
+func (node *AstStructFieldCall) String() string               { return nodeString(node) }
+func (node *AstEnumDefinition) String() string                { return nodeString(node) }
+func (node *AstEnumElementCall) String() string               { return nodeString(node) }
+func (node *AstSwitch) String() string                        { return nodeString(node) }
+func (node *AstCase) String() string                          { return nodeString(node) }
+func (node *AstEmptier) String() string                       { return nodeString(node) }
+func (node *AstEventHandler) String() string                  { return nodeString(node) }
+func (node *AstPersist) String() string                       { return nodeString(node) }
+func (node *AstTest) String() string                          { return nodeString(node) }
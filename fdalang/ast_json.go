@@ -0,0 +1,303 @@
+package fdalang
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// astKinds are node types by their kinds in JSON, the kind is the type name without "Ast"
+var astKinds = make(map[string]reflect.Type)
+
+func init() {
+	nodes := []AstNode{
+		&AstStatementsBlock{}, &AstAssignment{}, &AstStatementWithVoidedExpression{}, &AstStructFieldAssignment{},
+		&AstUnary{}, &AstEmptier{}, &AstBinOperation{}, &AstIdentifier{}, &AstNumInt{}, &AstNumFloat{},
+		&AstBoolean{}, &AstArray{}, &AstArrayIndexCall{}, &AstReturn{}, &AstFunction{}, &AstVarAndType{},
+		&AstFunctionCall{}, &AstIf{}, &AstEnumDefinition{}, &AstEnumElementCall{}, &AstStructDefinition{},
+		&AstStruct{}, &AstStructFieldCall{}, &AstCase{}, &AstSwitch{}, &AstEventHandler{}, &AstTest{},
+		&AstPersist{},
+	}
+	for _, node := range nodes {
+		t := reflect.TypeOf(node).Elem()
+		astKinds[astKind(t)] = t
+	}
+}
+
+// astOptionalChildren are "Kind.field" of child nodes which could be absent,
+// all other child nodes are required as the interpreter and String() expect them
+var astOptionalChildren = map[string]bool{
+	"If.elseBranch":           true,
+	"Switch.switchExpression": true,
+	"Switch.defaultBranch":    true,
+}
+
+var (
+	astNodeType      = reflect.TypeOf((*AstNode)(nil)).Elem()
+	structFieldsType = reflect.TypeOf(map[string]*AstVarAndType{})
+	jsonNull         = []byte("null")
+)
+
+func astKind(t reflect.Type) string {
+	return strings.TrimPrefix(t.Name(), "Ast")
+}
+
+// jsonFieldName is the name of the Go field starting with a lower case letter, e.g. "statementsBlock"
+func jsonFieldName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+// jsonToken is Token in JSON, tokens keep positions of nodes for error messages
+type jsonToken struct {
+	ID    TokenID `json:"id"`
+	Value string  `json:"value"`
+	Line  int     `json:"line"`
+	Col   int     `json:"col"`
+	Pos   int     `json:"pos"`
+}
+
+// MarshalAst encodes the tree as JSON. Every node is an object with "kind" (the type name without "Ast")
+// followed by its fields in declaration order named like in Go starting with a lower case letter, e.g.
+//
+//	{"kind":"NumInt","token":{"id":"int","value":"5","line":1,"col":5,"pos":4},"value":5}
+//
+// Absent nodes are null, fields of struct definitions are an array in the source order, so the same tree
+// is always encoded to the same bytes. The expression of `switch` shared by conditions of its cases
+// is written for the switch and for every case.
+func MarshalAst(node AstNode) ([]byte, error) {
+	e := &astEncoder{}
+	if err := e.value(reflect.ValueOf(&node).Elem()); err != nil {
+		return nil, err
+	}
+	return e.out.Bytes(), nil
+}
+
+type astEncoder struct {
+	out bytes.Buffer
+}
+
+func (e *astEncoder) value(v reflect.Value) error {
+	t := v.Type()
+	switch {
+	case t == tokenType:
+		return e.json(jsonToken(v.Interface().(Token)))
+	case t.Implements(astNodeType):
+		if v.IsNil() {
+			e.out.Write(jsonNull)
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		return e.node(v)
+	case t == structFieldsType:
+		fields := make([]*AstVarAndType, 0, v.Len())
+		for _, field := range v.Interface().(map[string]*AstVarAndType) {
+			fields = append(fields, field)
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Token.Pos < fields[j].Token.Pos })
+		return e.value(reflect.ValueOf(fields))
+	case t.Kind() == reflect.Slice && t.Elem().Implements(astNodeType):
+		if v.IsNil() {
+			e.out.Write(jsonNull)
+			return nil
+		}
+		e.out.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				e.out.WriteString(",")
+			}
+			if err := e.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		e.out.WriteString("]")
+		return nil
+	default:
+		return e.json(v.Interface())
+	}
+}
+
+// node encodes the pointer to the node struct
+func (e *astEncoder) node(v reflect.Value) error {
+	t := v.Type().Elem()
+	if astKinds[astKind(t)] != t {
+		return fmt.Errorf("unsupported node %s", v.Type())
+	}
+	e.out.WriteString(`{"kind":`)
+	if err := e.json(astKind(t)); err != nil {
+		return err
+	}
+	for i := 0; i < t.NumField(); i++ {
+		e.out.WriteString(`,"` + jsonFieldName(t.Field(i).Name) + `":`)
+		if err := e.value(v.Elem().Field(i)); err != nil {
+			return err
+		}
+	}
+	e.out.WriteString("}")
+	return nil
+}
+
+func (e *astEncoder) json(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.out.Write(data)
+	return nil
+}
+
+// UnmarshalAst decodes the tree encoded by MarshalAst. Missing fields are left zero, but child nodes
+// (except else branch, switch expression and default branch) and elements of node lists are required.
+// The expression of `switch` is shared by conditions of its cases again like in the tree built by the parser.
+func UnmarshalAst(data []byte) (AstNode, error) {
+	var node AstNode
+	if err := decodeAstValue(data, reflect.ValueOf(&node).Elem(), "ast"); err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("ast: node is null")
+	}
+	shareSwitchExpressions(node)
+	return node, nil
+}
+
+// UnmarshalProgram decodes the program encoded by MarshalAst
+func UnmarshalProgram(data []byte) (*AstStatementsBlock, error) {
+	node, err := UnmarshalAst(data)
+	if err != nil {
+		return nil, err
+	}
+	program, ok := node.(*AstStatementsBlock)
+	if !ok {
+		return nil, fmt.Errorf("ast: program is expected, got %s", astKind(reflect.TypeOf(node).Elem()))
+	}
+	return program, nil
+}
+
+func decodeAstValue(data json.RawMessage, v reflect.Value, path string) error {
+	t := v.Type()
+	switch {
+	case t == tokenType:
+		var token jsonToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		v.Set(reflect.ValueOf(Token(token)))
+	case t.Implements(astNodeType):
+		if bytes.Equal(bytes.TrimSpace(data), jsonNull) {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		node, err := decodeAstNode(data, path)
+		if err != nil {
+			return err
+		}
+		if !node.Type().AssignableTo(t) {
+			return fmt.Errorf("%s: %s can't be used here", path, astKind(node.Type().Elem()))
+		}
+		v.Set(node)
+	case t == structFieldsType:
+		var fields []*AstVarAndType
+		if err := decodeAstValue(data, reflect.ValueOf(&fields).Elem(), path); err != nil {
+			return err
+		}
+		m := make(map[string]*AstVarAndType, len(fields))
+		for i, field := range fields {
+			if field == nil || field.Var == nil {
+				return fmt.Errorf("%s[%d]: field name is required", path, i)
+			}
+			m[field.Var.Value] = field
+		}
+		v.Set(reflect.ValueOf(m))
+	case t.Kind() == reflect.Slice && t.Elem().Implements(astNodeType):
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		if elements == nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		slice := reflect.MakeSlice(t, len(elements), len(elements))
+		for i, element := range elements {
+			elementPath := fmt.Sprintf("%s[%d]", path, i)
+			if err := decodeAstValue(element, slice.Index(i), elementPath); err != nil {
+				return err
+			}
+			if slice.Index(i).IsNil() {
+				return fmt.Errorf("%s: node is required", elementPath)
+			}
+		}
+		v.Set(slice)
+	default:
+		if err := json.Unmarshal(data, v.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+	return nil
+}
+
+// decodeAstNode returns the pointer to the decoded node struct
+func decodeAstNode(data json.RawMessage, path string) (reflect.Value, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return reflect.Value{}, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	var kind string
+	if raw, ok := fields["kind"]; !ok {
+		return reflect.Value{}, fmt.Errorf("%s: node kind is required", path)
+	} else if err := json.Unmarshal(raw, &kind); err != nil {
+		return reflect.Value{}, fmt.Errorf("%s.kind: %s", path, err.Error())
+	}
+	t, ok := astKinds[kind]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%s: unknown node kind '%s'", path, kind)
+	}
+
+	node := reflect.New(t)
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonFieldName(t.Field(i).Name)
+		field := node.Elem().Field(i)
+		if raw, ok := fields[name]; ok {
+			if err := decodeAstValue(raw, field, path+"."+name); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if field.Type().Implements(astNodeType) && field.IsNil() && !astOptionalChildren[kind+"."+name] {
+			return reflect.Value{}, fmt.Errorf("%s.%s: node is required", path, name)
+		}
+	}
+	return node, nil
+}
+
+// shareSwitchExpressions makes case conditions use the expression of their switch, they were decoded as copies
+func shareSwitchExpressions(root AstNode) {
+	Inspect(root, func(node AstNode) bool {
+		switchStmt, ok := node.(*AstSwitch)
+		if !ok || switchStmt.SwitchExpression == nil {
+			return true
+		}
+		expr := switchStmt.SwitchExpression
+		for _, c := range switchStmt.Cases {
+			if c.Condition == nil {
+				continue
+			}
+			Inspect(c.Condition, func(node AstNode) bool {
+				op, ok := node.(*AstBinOperation)
+				if ok && op.Left != nil && reflect.TypeOf(op.Left) == reflect.TypeOf(expr) &&
+					op.Left.GetToken() == expr.GetToken() {
+					op.Left = expr
+				}
+				return true
+			})
+		}
+		return true
+	})
+}
@@ -0,0 +1,168 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// everyNodeSource has every kind of node
+const everyNodeSource = `struct point {
+   float x
+   float y
+}
+enum kind {rock, metal}
+persist shots = 0
+p = point{x = 1., y = -2.5}
+p.x = p.y * 2.
+list = []int{1, 2, 3}
+target = ?point
+step = fn(int n, kind k) int {
+   if !(list[n] > 1) {
+      return n
+   } else {
+      shots = shots + 1
+   }
+   switch k {
+   case == kind:rock
+      return 1
+   default
+      return 2
+   }
+   return 0
+}
+switch {
+case true
+   step(0, kind:metal)
+}
+on tick(float dt) {
+   p.y = dt
+}
+test "step" {
+   assertEq(step(1, kind:rock), 1)
+}
+`
+
+func TestAstJSON(t *testing.T) {
+	program, err := NewParser(NewLexer(everyNodeSource)).Parse()
+	require.Nil(t, err)
+
+	kinds := make(map[string]bool)
+	Inspect(program, func(node AstNode) bool {
+		if node != nil {
+			kinds[astKind(reflect.TypeOf(node).Elem())] = true
+		}
+		return true
+	})
+	require.Len(t, kinds, len(astKinds), "the source has every kind of node")
+
+	data, err := MarshalAst(program)
+	require.Nil(t, err)
+	require.True(t, json.Valid(data))
+
+	decoded, err := UnmarshalProgram(data)
+	require.Nil(t, err)
+	assert.Equal(t, program, decoded)
+	assert.Equal(t, FormatAst(program, nil), FormatAst(decoded, nil))
+
+	again, err := MarshalAst(decoded)
+	require.Nil(t, err)
+	assert.Equal(t, string(data), string(again), "encoding is stable")
+
+	step := decoded.Statements[7].(*AstStatementWithVoidedExpression).Expr.(*AstAssignment).Value.(*AstFunction)
+	switchStmt := step.StatementsBlock.Statements[1].(*AstSwitch)
+	assert.Same(t, switchStmt.SwitchExpression, switchStmt.Cases[0].Condition.(*AstBinOperation).Left,
+		"switch expression is shared by cases")
+}
+
+func TestAstJSONFormat(t *testing.T) {
+	program, err := NewParser(NewLexer("a = 5\n")).Parse()
+	require.Nil(t, err)
+	data, err := MarshalAst(program.Statements[0].(*AstStatementWithVoidedExpression).Expr.(*AstAssignment).Value)
+	require.Nil(t, err)
+	assert.Equal(t, `{"kind":"NumInt","token":{"id":"int","value":"5","line":1,"col":5,"pos":4},"value":5}`, string(data))
+
+	node, err := UnmarshalAst([]byte(`{"kind":"Identifier","value":"a"}`))
+	require.Nil(t, err)
+	assert.Equal(t, &AstIdentifier{Value: "a"}, node, "missing fields are zero")
+}
+
+func TestAstJSONErrors(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		err   string
+	}{
+		"null":         {`null`, "ast: node is null"},
+		"no kind":      {`{"value":"a"}`, "ast: node kind is required"},
+		"unknown kind": {`{"kind":"Loop"}`, "ast: unknown node kind 'Loop'"},
+		"wrong place": {
+			`{"kind":"Return","returnValue":{"kind":"Return","returnValue":{"kind":"NumInt"}}}`,
+			"ast.returnValue: Return can't be used here",
+		},
+		"null condition": {
+			`{"kind":"StatementsBlock","statements":[{"kind":"If","condition":null,"positiveBranch":null}]}`,
+			"ast.statements[0].condition: node is required",
+		},
+		"missing branch": {
+			`{"kind":"If","condition":{"kind":"Boolean","value":true}}`,
+			"ast.positiveBranch: node is required",
+		},
+		"missing operand": {
+			`{"kind":"BinOperation","operator":"+","left":{"kind":"NumInt","value":1}}`,
+			"ast.right: node is required",
+		},
+		"missing identifier": {
+			`{"kind":"Assignment","value":{"kind":"NumInt","value":1}}`,
+			"ast.left: node is required",
+		},
+		"null element": {
+			`{"kind":"Array","elementsType":"int","elements":[{"kind":"NumInt","value":1},null]}`,
+			"ast.elements[1]: node is required",
+		},
+		"expression as statement": {
+			`{"kind":"StatementsBlock","statements":[{"kind":"Identifier","value":"a"}]}`,
+			"ast.statements[0]: Identifier can't be used here",
+		},
+		"wrong value": {
+			`{"kind":"NumInt","value":"5"}`,
+			"ast.value: json: cannot unmarshal string into Go value of type int64",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := UnmarshalAst([]byte(test.input))
+			require.NotNil(t, err)
+			assert.Equal(t, test.err, err.Error())
+		})
+	}
+
+	_, err := UnmarshalProgram([]byte(`{"kind":"Identifier","value":"a"}`))
+	require.NotNil(t, err)
+	assert.Equal(t, "ast: program is expected, got Identifier", err.Error())
+}
+
+func TestAstString(t *testing.T) {
+	program, err := NewParser(NewLexer(everyNodeSource)).Parse()
+	require.Nil(t, err)
+
+	assert.Equal(t, "p.x = p.y * 2.", program.Statements[4].String())
+	assignment := program.Statements[3].(*AstStatementWithVoidedExpression).Expr.(*AstAssignment)
+	assert.Equal(t, "point{x = 1., y = -2.5}", assignment.Value.String())
+	assert.Equal(t, "p", assignment.Left.String())
+
+	step := program.Statements[7].(*AstStatementWithVoidedExpression).Expr.(*AstAssignment).Value.(*AstFunction)
+	assert.Equal(t, "int n", step.Arguments[0].String())
+	assert.Equal(t, `switch k {
+case == kind:rock
+   return 1
+default
+   return 2
+}`, step.StatementsBlock.Statements[1].String())
+	assert.Equal(t, "case k == kind:rock\n   return 1", step.StatementsBlock.Statements[1].(*AstSwitch).Cases[0].String(),
+		"case alone is printed with the switch expression")
+	assert.Equal(t, "struct point {\n   float x\n   float y\n}", program.Statements[0].String())
+	assert.Equal(t, FormatAst(program, nil), program.String()+"\n")
+}
@@ -0,0 +1,423 @@
+package fdalang
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// GoEnum is implemented by Go integer types which should be visible in scripts as enums.
+// Value of the Go constant is the index of the element in EnumElements.
+//
+//	type ObjectType int
+//	func (ObjectType) EnumElements() []string { return []string{"xelon", "spore"} }
+type GoEnum interface {
+	EnumElements() []string
+}
+
+const bindTagName = "fda"
+
+var (
+	goEnumType = reflect.TypeOf((*GoEnum)(nil)).Elem()
+	vec2GoType = reflect.TypeOf(Vec2{})
+)
+
+// goRegistration is the Go type registered in the environment, created is false if the type uses
+// the definition which already existed, e.g. restored from snapshot
+type goRegistration struct {
+	t       reflect.Type
+	created bool
+}
+
+// goPointer is the pointer or the slice being converted, pointers to a struct and to its first field
+// are the same address, so the type is the part of the key
+type goPointer struct {
+	addr uintptr
+	t    reflect.Type
+}
+
+// Bind converts Go value (struct, pointer to struct, slice, scalar or enum) into the script object
+// and sets it as a variable. Struct and enum definitions are derived from Go types and registered
+// in the environment on first use. Value is copied, script changes are not visible in Go value.
+// Cyclic values can't be bound, shared pointers are copied for every reference.
+func (e *Environment) Bind(name string, v interface{}) error {
+	obj, err := e.goValueToObject(reflect.ValueOf(v), name)
+	if err != nil {
+		return err
+	}
+	e.Set(name, obj)
+	return nil
+}
+
+// RegisterGoStruct registers struct definition derived from Go struct type.
+// Field names are taken from `fda:"name"` tags, or Go field names when tag is absent.
+// Fields tagged with `fda:"-"` and unexported fields are skipped.
+func (e *Environment) RegisterGoStruct(t reflect.Type) (*AstStructDefinition, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("type '%s' is not a struct", t)
+	}
+	if name, ok := e.goTypeName(t); ok {
+		def, _ := e.StructDefinition(name)
+		return def, nil
+	}
+	if t.Name() == "" {
+		return nil, fmt.Errorf("anonymous struct '%s' can't be registered", t)
+	}
+	// types of fields are registered too, all of them are removed if any field is not supported
+	mark := len(e.goRegistrations)
+	if existing, ok := e.structDefinitions[t.Name()]; ok {
+		// e.g. restored from snapshot, could be used if it has the same fields
+		def, err := e.adoptGoStruct(t, existing)
+		if err != nil {
+			e.rollbackGoTypes(mark)
+		}
+		return def, err
+	}
+
+	def := &AstStructDefinition{
+		Name:   t.Name(),
+		Fields: make(map[string]*AstVarAndType),
+	}
+	if err := e.RegisterStructDefinition(def); err != nil {
+		return nil, err
+	}
+	// register before fields resolving to support self referenced types
+	e.registerGoType(t, def.Name, true)
+
+	fields, err := e.goStructFields(t)
+	if err != nil {
+		e.rollbackGoTypes(mark)
+		return nil, err
+	}
+	def.Fields = fields
+
+	return def, nil
+}
+
+func (e *Environment) adoptGoStruct(t reflect.Type, def *AstStructDefinition) (*AstStructDefinition, error) {
+	e.registerGoType(t, def.Name, false)
+	fields, err := e.goStructFields(t)
+	if err != nil {
+		return nil, err
+	}
+	sameFields := len(fields) == len(def.Fields)
+	for name, f := range fields {
+		if existing, ok := def.Fields[name]; !ok || existing.VarType != f.VarType {
+			sameFields = false
+		}
+	}
+	if !sameFields {
+		return nil, fmt.Errorf("struct '%s' already defined in this scope with different fields", def.Name)
+	}
+	return def, nil
+}
+
+func (e *Environment) goStructFields(t reflect.Type) (map[string]*AstVarAndType, error) {
+	fields := make(map[string]*AstVarAndType)
+	for i := 0; i < t.NumField(); i++ {
+		fieldName, ok := goFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		if _, exists := fields[fieldName]; exists {
+			return nil, fmt.Errorf("struct '%s' has duplicated field '%s'", t.Name(), fieldName)
+		}
+		varType, err := goLangType(t.Field(i).Type, e)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", t.Name(), fieldName, err.Error())
+		}
+		fields[fieldName] = &AstVarAndType{
+			VarType: varType,
+			Var:     &AstIdentifier{Value: fieldName},
+		}
+	}
+	return fields, nil
+}
+
+// RegisterGoEnum registers enum definition derived from Go integer type implementing GoEnum
+func (e *Environment) RegisterGoEnum(t reflect.Type) (*AstEnumDefinition, error) {
+	if !isGoEnum(t) {
+		return nil, fmt.Errorf("type '%s' should be integer type implementing GoEnum", t)
+	}
+	if name, ok := e.goTypeName(t); ok {
+		def, _ := e.EnumDefinition(name)
+		return def, nil
+	}
+	if t.Name() == "" {
+		return nil, fmt.Errorf("anonymous enum '%s' can't be registered", t)
+	}
+
+	elements := reflect.Zero(t).Interface().(GoEnum).EnumElements()
+	if len(elements) == 0 || len(elements) > math.MaxInt8+1 {
+		return nil, fmt.Errorf("enum '%s' should have from 1 to %d elements", t.Name(), math.MaxInt8+1)
+	}
+	if existing, ok := e.enumDefinitions[t.Name()]; ok {
+		if strings.Join(existing.Elements, ",") != strings.Join(elements, ",") {
+			return nil, fmt.Errorf("enum '%s' already defined in this scope with different elements", t.Name())
+		}
+		e.registerGoType(t, existing.Name, false)
+		return existing, nil
+	}
+	def := &AstEnumDefinition{
+		Name:     t.Name(),
+		Elements: elements,
+	}
+	if err := e.RegisterEnumDefinition(def); err != nil {
+		return nil, err
+	}
+	e.registerGoType(t, def.Name, true)
+
+	return def, nil
+}
+
+func (e *Environment) goTypeName(t reflect.Type) (string, bool) {
+	name, ok := e.goTypes[t]
+
+	if !ok && e.outer != nil {
+		name, ok = e.outer.goTypeName(t)
+	}
+
+	return name, ok
+}
+
+func (e *Environment) registerGoType(t reflect.Type, name string, created bool) {
+	e.goTypes[t] = name
+	e.goRegistrations = append(e.goRegistrations, goRegistration{t: t, created: created})
+}
+
+// rollbackGoTypes removes Go types registered after the mark (the number of registrations before)
+// together with definitions created for them
+func (e *Environment) rollbackGoTypes(mark int) {
+	for i := len(e.goRegistrations) - 1; i >= mark; i-- {
+		r := e.goRegistrations[i]
+		name := e.goTypes[r.t]
+		delete(e.goTypes, r.t)
+		if !r.created {
+			continue
+		}
+		if isGoEnum(r.t) {
+			delete(e.enumDefinitions, name)
+		} else {
+			delete(e.structDefinitions, name)
+		}
+	}
+	e.goRegistrations = e.goRegistrations[:mark]
+}
+
+func goFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get(bindTagName)
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return f.Name, true
+}
+
+func isGoEnum(t reflect.Type) bool {
+	return t.Implements(goEnumType) && (isGoInt(t.Kind()) || isGoUint(t.Kind()))
+}
+
+func isGoInt(k reflect.Kind) bool {
+	return k == reflect.Int || k == reflect.Int8 || k == reflect.Int16 || k == reflect.Int32 || k == reflect.Int64
+}
+
+func isGoUint(k reflect.Kind) bool {
+	return k == reflect.Uint || k == reflect.Uint8 || k == reflect.Uint16 || k == reflect.Uint32 ||
+		k == reflect.Uint64
+}
+
+// goLangType returns script type for Go type. If env is given struct and enum definitions
+// are registered in it, otherwise only names are derived
+func goLangType(t reflect.Type, env *Environment) (string, error) {
+	switch {
+	case t == vec2GoType:
+		return TypeVec2, nil
+	case t.Kind() == reflect.Ptr:
+		return goLangType(t.Elem(), env)
+	case isGoEnum(t):
+		if env == nil {
+			return t.Name(), nil
+		}
+		def, err := env.RegisterGoEnum(t)
+		if err != nil {
+			return "", err
+		}
+		return def.Name, nil
+	case isGoInt(t.Kind()) || isGoUint(t.Kind()):
+		return TypeInt, nil
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return TypeFloat, nil
+	case t.Kind() == reflect.Bool:
+		return TypeBool, nil
+	case t.Kind() == reflect.Struct:
+		if env == nil {
+			if t.Name() == "" {
+				return "", fmt.Errorf("anonymous struct '%s' is not supported", t)
+			}
+			return t.Name(), nil
+		}
+		def, err := env.RegisterGoStruct(t)
+		if err != nil {
+			return "", err
+		}
+		return def.Name, nil
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		elementsType, err := goLangType(t.Elem(), env)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elementsType, nil
+	default:
+		return "", fmt.Errorf("unsupported Go type '%s'", t)
+	}
+}
+
+// goValueToObject converts Go value, definitions registered for its types are removed if conversion fails
+func (e *Environment) goValueToObject(v reflect.Value, path string) (Object, error) {
+	mark := len(e.goRegistrations)
+	obj, err := e.goValueToObjectVisiting(v, path, make(map[goPointer]bool))
+	if err != nil {
+		e.rollbackGoTypes(mark)
+	}
+	return obj, err
+}
+
+// goValueToObjectVisiting converts the value, visiting are pointers and slices being converted on the path
+// to the value, the value referencing one of them is cyclic
+func (e *Environment) goValueToObjectVisiting(
+	v reflect.Value,
+	path string,
+	visiting map[goPointer]bool,
+) (Object, error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("%s: can't bind nil value", path)
+	}
+	t := v.Type()
+	switch {
+	case t == vec2GoType:
+		return &ObjVec2{Value: v.Interface().(Vec2)}, nil
+	case t.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			return e.goEmptyObject(t.Elem(), path)
+		}
+		ptr := goPointer{addr: v.Pointer(), t: t}
+		if visiting[ptr] {
+			return nil, fmt.Errorf("%s: cyclic reference of '%s' can't be converted", path, t)
+		}
+		visiting[ptr] = true
+		defer delete(visiting, ptr)
+		return e.goValueToObjectVisiting(v.Elem(), path, visiting)
+	case isGoEnum(t):
+		def, err := e.RegisterGoEnum(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		var index int64
+		if isGoInt(t.Kind()) {
+			index = v.Int()
+		} else {
+			index = int64(v.Uint())
+		}
+		if index < 0 || index >= int64(len(def.Elements)) {
+			return nil, fmt.Errorf("%s: value %d is out of enum '%s' range", path, index, def.Name)
+		}
+		return &ObjEnum{Definition: def, Value: int8(index)}, nil
+	case isGoInt(t.Kind()):
+		return &ObjInteger{Value: v.Int()}, nil
+	case isGoUint(t.Kind()):
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%s: value %d overflows int", path, v.Uint())
+		}
+		return &ObjInteger{Value: int64(v.Uint())}, nil
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &ObjFloat{Value: v.Float()}, nil
+	case t.Kind() == reflect.Bool:
+		return nativeBooleanToBoolean(v.Bool()), nil
+	case t.Kind() == reflect.Struct:
+		return e.goStructToObject(v, path, visiting)
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			return e.goEmptyObject(t, path)
+		}
+		if t.Kind() == reflect.Slice && v.Len() > 0 {
+			ptr := goPointer{addr: v.Pointer(), t: t}
+			if visiting[ptr] {
+				return nil, fmt.Errorf("%s: cyclic reference of '%s' can't be converted", path, t)
+			}
+			visiting[ptr] = true
+			defer delete(visiting, ptr)
+		}
+		elementsType, err := goLangType(t.Elem(), e)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		elements := make([]Object, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elements[i], err = e.goValueToObjectVisiting(v.Index(i), fmt.Sprintf("%s[%d]", path, i), visiting)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &ObjArray{ElementsType: elementsType, Elements: elements}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported Go type '%s'", path, t)
+	}
+}
+
+func (e *Environment) goStructToObject(v reflect.Value, path string, visiting map[goPointer]bool) (Object, error) {
+	t := v.Type()
+	def, err := e.RegisterGoStruct(t)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	fields := make(map[string]Object, len(def.Fields))
+	for i := 0; i < t.NumField(); i++ {
+		fieldName, ok := goFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		fields[fieldName], err = e.goValueToObjectVisiting(v.Field(i), path+"."+fieldName, visiting)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ObjStruct{Definition: def, Fields: fields}, nil
+}
+
+// goEmptyObject creates empty (as ?type in scripts) object for nil pointers and slices
+func (e *Environment) goEmptyObject(t reflect.Type, path string) (Object, error) {
+	langType, err := goLangType(t, e)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return e.emptyObject(langType, path)
+}
+
+// emptyObject creates empty object of the script type
+func (e *Environment) emptyObject(langType string, path string) (Object, error) {
+	switch {
+	case langType == TypeInt:
+		return &ObjInteger{Emptier: Emptier{Empty: true}}, nil
+	case langType == TypeFloat:
+		return &ObjFloat{Emptier: Emptier{Empty: true}}, nil
+	case langType == TypeVec2:
+		return &ObjVec2{Emptier: Emptier{Empty: true}}, nil
+	case strings.HasPrefix(langType, "[]"):
+		return &ObjArray{Emptier: Emptier{Empty: true}, ElementsType: strings.TrimPrefix(langType, "[]")}, nil
+	}
+	if def, ok := e.StructDefinition(langType); ok {
+		return NewEmptyStruct(def), nil
+	}
+	return nil, fmt.Errorf("%s: type '%s' doesn't support emptiness", path, langType)
+}
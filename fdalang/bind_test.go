@@ -0,0 +1,288 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"reflect"
+	"testing"
+)
+
+type testObjectType int
+
+func (testObjectType) EnumElements() []string { return []string{"xelon", "spore"} }
+
+type testCannon struct {
+	Angle float64 `fda:"angle"`
+	Ready bool    `fda:"ready"`
+}
+
+type testMech struct {
+	Pos     Vec2           `fda:"pos"`
+	Hp      int32          `fda:"hp"`
+	Kind    testObjectType `fda:"kind"`
+	Cannon  testCannon     `fda:"cannon"`
+	Target  *testCannon    `fda:"target"`
+	Ammo    []int          `fda:"ammo"`
+	private int
+	Ignored string `fda:"-"`
+}
+
+func TestBind(t *testing.T) {
+	input := `dist = mech.pos.distance(vec2{x = 0., y = 0.})
+hp = mech.hp
+isXelon = mech.kind == testObjectType:xelon
+angle = mech.cannon.angle
+noTarget = empty(mech.target)
+ammo = mech.ammo[1]
+if mech.cannon.ready {
+   ready = 1
+}
+`
+	env := NewEnvironment()
+	err := env.Bind("mech", &testMech{
+		Pos:    Vec2{X: 3, Y: 4},
+		Hp:     100,
+		Kind:   0,
+		Cannon: testCannon{Angle: 0.5, Ready: true},
+		Ammo:   []int{1, 2},
+	})
+	require.Nil(t, err)
+
+	def, ok := env.StructDefinition("testMech")
+	require.True(t, ok)
+	require.Len(t, def.Fields, 6)
+	require.Equal(t, TypeVec2, def.Fields["pos"].VarType)
+	require.Equal(t, "testCannon", def.Fields["target"].VarType)
+	require.Equal(t, "[]int", def.Fields["ammo"].VarType)
+
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+
+	for name, expected := range map[string]string{
+		"dist":     "5.00",
+		"hp":       "100",
+		"isXelon":  "true",
+		"angle":    "0.50",
+		"noTarget": "true",
+		"ammo":     "2",
+		"ready":    "1",
+	} {
+		v, ok := env.Get(name)
+		require.True(t, ok, name)
+		require.Equal(t, expected, v.Inspect(), name)
+	}
+}
+
+func TestBindTwiceReusesDefinitions(t *testing.T) {
+	env := NewEnvironment()
+	require.Nil(t, env.Bind("a", testCannon{}))
+	require.Nil(t, env.Bind("b", testCannon{}))
+
+	def, err := env.RegisterGoStruct(reflect.TypeOf(&testCannon{}))
+	require.Nil(t, err)
+	a, _ := env.Get("a")
+	require.Same(t, def, a.(*ObjStruct).Definition)
+}
+
+func TestBindUnsupportedTypeNegative(t *testing.T) {
+	env := NewEnvironment()
+	err := env.Bind("a", struct {
+		C complex128
+	}{})
+	require.NotNil(t, err)
+
+	type withMap struct {
+		M map[string]int `fda:"m"`
+	}
+	err = env.Bind("a", withMap{})
+	require.NotNil(t, err)
+	_, ok := env.StructDefinition("withMap")
+	require.False(t, ok)
+}
+
+type testNode struct {
+	Value int       `fda:"value"`
+	Next  *testNode `fda:"next"`
+}
+
+type testTree struct {
+	Children []testTree `fda:"children"`
+}
+
+func TestBindCyclicNegative(t *testing.T) {
+	env := NewEnvironment()
+	node := &testNode{Value: 1}
+	node.Next = &testNode{Value: 2, Next: node}
+	err := env.Bind("node", node)
+	require.NotNil(t, err)
+	require.Equal(t, "node.next.next: cyclic reference of '*fdalang.testNode' can't be converted", err.Error())
+	_, ok := env.StructDefinition("testNode")
+	require.False(t, ok)
+
+	trees := make([]testTree, 1)
+	trees[0].Children = trees
+	err = env.Bind("trees", trees)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "trees[0].children: cyclic reference")
+
+	shared := &testNode{Value: 3}
+	require.Nil(t, env.Bind("shared", []*testNode{shared, shared}), "shared pointer is not a cycle")
+}
+
+type testTurretSlot struct {
+	Turret testTurret `fda:"turret"`
+	Kind   testObjectType
+}
+
+type testSlots struct {
+	Slots []testTurretSlot `fda:"slots"`
+}
+
+type testWithMap struct {
+	Slot testTurretSlot `fda:"slot"`
+	M    map[string]int `fda:"m"`
+}
+
+func TestBindRollsBackDefinitionsNegative(t *testing.T) {
+	assertNotDefined := func(env *Environment) {
+		for _, name := range []string{"testTurret", "testTurretSlot", "testSlots", "testWithMap"} {
+			_, ok := env.StructDefinition(name)
+			require.False(t, ok, name)
+		}
+		_, ok := env.EnumDefinition("testObjectType")
+		require.False(t, ok)
+	}
+
+	env := NewEnvironment()
+	err := env.Bind("a", testWithMap{})
+	require.NotNil(t, err, "unsupported type of the field")
+	assertNotDefined(env)
+
+	err = env.Bind("a", testSlots{Slots: []testTurretSlot{{Kind: 0}, {Kind: 5}}})
+	require.NotNil(t, err, "unsupported value of the nested field")
+	require.Contains(t, err.Error(), "a.slots[1].Kind: value 5 is out of enum 'testObjectType' range")
+	assertNotDefined(env)
+
+	require.Nil(t, env.Bind("a", testSlots{Slots: []testTurretSlot{{Kind: 1}}}))
+	_, ok := env.StructDefinition("testTurret")
+	require.True(t, ok)
+}
+
+type testCommands struct {
+	Move   float64    `fda:"move"`
+	Cannon testCannon `fda:"cannon"`
+	Target *Vec2      `fda:"target"`
+	Path   []Vec2     `fda:"path"`
+}
+
+type testTurret struct {
+	Angle float64 `fda:"angle"`
+}
+
+func TestWrapFuncRegistersResultGlobally(t *testing.T) {
+	input := `aimed = fn() float {
+   t = aim()
+   return t.angle
+}
+a = aimed()
+none = ?testTurret
+`
+	aim, err := WrapFunc("aim", func() testTurret {
+		return testTurret{Angle: 1}
+	})
+	require.Nil(t, err)
+
+	env := NewEnvironment()
+	e := NewExecAstVisitor()
+	e.AddBuiltinFunctions(map[string]*ObjBuiltin{"aim": aim})
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	require.Nil(t, e.ExecAst(astProgram, env))
+
+	_, ok := env.StructDefinition("testTurret")
+	require.True(t, ok, "struct of the result is defined on the top level")
+	a, _ := env.Get("a")
+	require.Equal(t, "1.00", a.Inspect())
+}
+
+func TestDecode(t *testing.T) {
+	input := `commands.move = 1.
+commands.cannon.angle = -0.5
+commands.cannon.ready = true
+commands.path = []vec2{vec2{x = 1., y = 2.}}
+`
+	env := NewEnvironment()
+	require.Nil(t, env.Bind("commands", testCommands{Target: &Vec2{X: 1}}))
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+
+	var commands testCommands
+	require.Nil(t, env.Decode("commands", &commands))
+	require.Equal(t, testCommands{
+		Move:   1,
+		Cannon: testCannon{Angle: -0.5, Ready: true},
+		Target: &Vec2{X: 1},
+		Path:   []Vec2{{X: 1, Y: 2}},
+	}, commands)
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	env := NewEnvironment()
+	require.Nil(t, env.Bind("commands", testCommands{}))
+	commandsObj, _ := env.Get("commands")
+
+	var wrongType struct {
+		Move int `fda:"move"`
+	}
+	err := Unmarshal(commandsObj, &wrongType)
+	require.IsType(t, &UnmarshalError{}, err)
+	require.Equal(t, "testCommands.move", err.(*UnmarshalError).Path)
+
+	var notEmptiable float64
+	err = Unmarshal(&ObjFloat{Emptier: Emptier{Empty: true}}, &notEmptiable)
+	require.IsType(t, &UnmarshalError{}, err)
+
+	var emptiable *float64
+	require.Nil(t, Unmarshal(&ObjFloat{Emptier: Emptier{Empty: true}}, &emptiable))
+	require.Nil(t, emptiable)
+}
+
+func TestWrapFunc(t *testing.T) {
+	input := `p = toPolar(vec2{x = 0., y = 2.})
+r = p.angle
+ok = isReady(p)
+`
+	toPolar, err := WrapFunc("toPolar", func(v Vec2) testCannon {
+		return testCannon{Angle: v.Len(), Ready: true}
+	})
+	require.Nil(t, err)
+	require.Equal(t, ArgTypes{TypeVec2}, toPolar.ArgTypes)
+	require.Equal(t, "testCannon", toPolar.ReturnType)
+
+	isReady, err := WrapFunc("isReady", func(c testCannon) (bool, error) {
+		return c.Ready, nil
+	})
+	require.Nil(t, err)
+
+	env := NewEnvironment()
+	_, err = env.RegisterGoStruct(reflect.TypeOf(testCannon{}))
+	require.Nil(t, err)
+
+	e := NewExecAstVisitor()
+	e.AddBuiltinFunctions(map[string]*ObjBuiltin{"toPolar": toPolar, "isReady": isReady})
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	require.Nil(t, e.ExecAst(astProgram, env))
+
+	r, _ := env.Get("r")
+	require.Equal(t, "2.00", r.Inspect())
+	ok, _ := env.Get("ok")
+	require.Equal(t, "true", ok.Inspect())
+
+	_, err = WrapFunc("bad", func(m map[string]int) {})
+	require.NotNil(t, err)
+	_, err = WrapFunc("bad", func() (int, int) { return 0, 0 })
+	require.NotNil(t, err)
+}
@@ -2,6 +2,7 @@ package fdalang
 
 import (
 	"fmt"
+	"io"
 	"math"
 )
 
@@ -24,7 +25,10 @@ func (e *ExecAstVisitor) setupBasicBuiltinFunctions() {
 		ArgTypes:   ArgTypes{"any"},
 		ReturnType: TypeVoid,
 		Fn: func(env *Environment, args []Object) (Object, error) {
-			fmt.Println(args[0].Inspect())
+			_, err := fmt.Fprintln(e.output, args[0].Inspect())
+			if err != nil {
+				return nil, err
+			}
 			return &ObjVoid{}, nil
 		},
 	}
@@ -42,6 +46,8 @@ func (e *ExecAstVisitor) setupBasicBuiltinFunctions() {
 				return nativeBooleanToBoolean(arg.Empty), nil
 			case *ObjArray:
 				return nativeBooleanToBoolean(arg.Empty), nil
+			case *ObjVec2:
+				return nativeBooleanToBoolean(arg.Empty), nil
 			default:
 				return nil, BuiltinFuncError("ID '%T' doesn't support emptiness", arg)
 			}
@@ -77,6 +83,11 @@ func (e *ExecAstVisitor) setupBasicBuiltinFunctions() {
 	}
 }
 
+// SetOutput sets the writer print builtin writes to, os.Stdout by default
+func (e *ExecAstVisitor) SetOutput(w io.Writer) {
+	e.output = w
+}
+
 func (e *ExecAstVisitor) AddBuiltinFunctions(builtins map[string]*ObjBuiltin) {
 	for k, v := range builtins {
 		e.builtins[k] = v
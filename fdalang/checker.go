@@ -0,0 +1,1075 @@
+package fdalang
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// CheckError is the type error found by Check
+type CheckError struct {
+	Msg  string
+	Line int
+	Col  int
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%s\nline:%d, pos %d", e.Msg, e.Line, e.Col)
+}
+
+type SymbolKind int
+
+const (
+	_ SymbolKind = iota
+	SymbolVar
+	SymbolStruct
+	SymbolField
+	SymbolEnum
+	SymbolEnumElement
+	SymbolBuiltin
+)
+
+// Symbol is the named declaration: variable (functions and their arguments are variables too),
+// struct or enum with its fields and elements, builtin function or vec2 method
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	// Type is the type of the value, struct and enum symbols have their names as types.
+	// Functions have types like 'fn(int, []vec2) float', builtins without argument checks 'fn(...) void'
+	Type string
+	// Token is the position of the declaration, zero for builtins and host values
+	Token Token
+	// Members are fields of the struct (methods too for vec2) or elements of the enum
+	Members map[string]*Symbol
+}
+
+// TypeInfo is the result of Check
+type TypeInfo struct {
+	Errors []*CheckError
+	// Types are inferred types of the expressions, expressions of unknown type are missed
+	Types map[AstExpression]string
+	// Uses maps identifiers to the symbols they refer to, declarations map to their own symbols
+	Uses map[*AstIdentifier]*Symbol
+
+	checker *checker
+	scopes  []*checkScope
+}
+
+// Check finds type errors in the program without executing it. Builtins of the executor and values
+// and definitions of env are used as host declarations, env is not changed and could be nil.
+// Statements are checked in the order they are executed: variable is known after its first assignment.
+// Bodies of functions and event handlers are checked after the block they are declared in,
+// as they are called later and see everything declared in the block.
+func (e *ExecAstVisitor) Check(program *AstStatementsBlock, env *Environment) *TypeInfo {
+	if env == nil {
+		env = NewEnvironment()
+	}
+	info := &TypeInfo{
+		Types: make(map[AstExpression]string),
+		Uses:  make(map[*AstIdentifier]*Symbol),
+	}
+	c := &checker{
+		info:         info,
+		builtins:     e.builtins,
+		testBuiltins: e.testBuiltins,
+		env:          env,
+		host:         make(map[string]*Symbol),
+		vec2:         vec2Symbol(e.vec2Methods),
+	}
+	info.checker = c
+	top := c.newScope(nil, 0, math.MaxInt32)
+	c.checkScopeBody(program.Statements, top)
+	sort.SliceStable(info.Errors, func(i, j int) bool {
+		a, b := info.Errors[i], info.Errors[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return info
+}
+
+// IdentAt returns the identifier at the position and the symbol it refers to
+func (t *TypeInfo) IdentAt(line, col int) (*AstIdentifier, *Symbol) {
+	for ident, sym := range t.Uses {
+		tok := ident.Token
+		if tok.Line == line && col >= tok.Col && col < tok.Col+len([]rune(ident.Value)) {
+			return ident, sym
+		}
+	}
+	return nil, nil
+}
+
+// ExprType infers the type of the expression as if it was written at the line,
+// e.g. type of the receiver of the field being completed in the editor
+func (t *TypeInfo) ExprType(expr AstExpression, line int) string {
+	quiet := *t.checker
+	quiet.quiet = true
+	return quiet.expr(expr, t.scopeAt(line))
+}
+
+// Visible returns variables, structs, enums and builtins visible at the line sorted by name
+func (t *TypeInfo) Visible(line int) []*Symbol {
+	c := t.checker
+	seen := make(map[string]bool)
+	var symbols []*Symbol
+	add := func(sym *Symbol) {
+		if sym != nil && !seen[sym.Kind.namespace()+sym.Name] {
+			seen[sym.Kind.namespace()+sym.Name] = true
+			symbols = append(symbols, sym)
+		}
+	}
+	for s := t.scopeAt(line); s != nil; s = s.outer {
+		for _, name := range sortedKeys(s.vars) {
+			add(s.vars[name])
+		}
+		for _, name := range sortedKeys(s.structs) {
+			add(s.structs[name])
+		}
+		for _, name := range sortedKeys(s.enums) {
+			add(s.enums[name])
+		}
+	}
+	for env := c.env; env != nil; env = env.outer {
+		for _, name := range sortedKeys(env.store) {
+			add(c.hostVar(name))
+		}
+		for _, name := range sortedKeys(env.structDefinitions) {
+			add(c.hostStruct(name))
+		}
+		for _, name := range sortedKeys(env.enumDefinitions) {
+			add(c.hostEnum(name))
+		}
+	}
+	for _, name := range sortedKeys(c.builtins) {
+		add(c.builtinSymbol(name))
+	}
+	sort.SliceStable(symbols, func(i, j int) bool { return symbols[i].Name < symbols[j].Name })
+	return symbols
+}
+
+// Members returns fields of the struct or elements of the enum type visible at the line sorted by name.
+// vec2 has fields x and y and its methods
+func (t *TypeInfo) Members(typ string, line int) []*Symbol {
+	s := t.scopeAt(line)
+	sym := t.checker.lookupStruct(s, typ)
+	if sym == nil {
+		sym = t.checker.lookupEnum(s, typ)
+	}
+	if sym == nil {
+		return nil
+	}
+	members := make([]*Symbol, 0, len(sym.Members))
+	for _, name := range sortedKeys(sym.Members) {
+		members = append(members, sym.Members[name])
+	}
+	return members
+}
+
+// TypeSymbol returns struct or enum symbol by the name visible at the line
+func (t *TypeInfo) TypeSymbol(name string, line int) *Symbol {
+	s := t.scopeAt(line)
+	if sym := t.checker.lookupStruct(s, name); sym != nil {
+		return sym
+	}
+	return t.checker.lookupEnum(s, name)
+}
+
+// scopeAt returns the innermost scope of the line
+func (t *TypeInfo) scopeAt(line int) *checkScope {
+	found := t.scopes[0]
+	for _, s := range t.scopes[1:] {
+		if line >= s.start && line <= s.end && s.start >= found.start {
+			found = s
+		}
+	}
+	return found
+}
+
+// namespace separates kinds of symbols which could have the same name
+func (k SymbolKind) namespace() string {
+	switch k {
+	case SymbolStruct, SymbolEnum:
+		return "type:"
+	default:
+		return "value:"
+	}
+}
+
+type checker struct {
+	info         *TypeInfo
+	builtins     map[string]*ObjBuiltin
+	testBuiltins map[string]*ObjBuiltin
+	env          *Environment
+	// host are symbols of builtins and host values, they are created on the first use
+	host map[string]*Symbol
+	vec2 *Symbol
+	// quiet checker only infers types without reporting errors and declaring anything
+	quiet bool
+	// stmtEnd is the last line of the statement being checked, scopes of functions end there
+	stmtEnd int
+}
+
+type checkScope struct {
+	outer    *checkScope
+	vars     map[string]*Symbol
+	structs  map[string]*Symbol
+	enums    map[string]*Symbol
+	handlers map[string]bool
+	tests    map[string]bool
+	// function scope checks returned values, returns from top level and event handlers are not checked
+	function   bool
+	returnType string
+	// start and end are lines of the scope
+	start int
+	end   int
+	// deferred are checks done after all statements of the scope, e.g. bodies of functions
+	deferred []func()
+	// assigned are variables of the scope assigned on every path to the statement being checked
+	assigned assignedVars
+}
+
+type assignedVars map[*Symbol]bool
+
+func (a assignedVars) copy() assignedVars {
+	c := make(assignedVars, len(a))
+	for sym := range a {
+		c[sym] = true
+	}
+	return c
+}
+
+func (c *checker) newScope(outer *checkScope, start, end int) *checkScope {
+	s := &checkScope{
+		outer:    outer,
+		vars:     make(map[string]*Symbol),
+		structs:  make(map[string]*Symbol),
+		enums:    make(map[string]*Symbol),
+		handlers: make(map[string]bool),
+		tests:    make(map[string]bool),
+		assigned: make(assignedVars),
+		start:    start,
+		end:      end,
+	}
+	c.info.scopes = append(c.info.scopes, s)
+	return s
+}
+
+// testScope declares builtins available only in bodies of tests, as the runtime does in RunTest
+func (c *checker) testScope(outer *checkScope, start, end int) *checkScope {
+	s := c.newScope(outer, start, end)
+	for name, b := range c.testBuiltins {
+		b := b
+		s.vars[name] = c.hostSymbol("testBuiltin:"+name, func() *Symbol {
+			return &Symbol{Name: b.Name, Kind: SymbolBuiltin, Type: builtinType(b, 0)}
+		})
+	}
+	return s
+}
+
+func (c *checker) checkScopeBody(statements []AstStatement, s *checkScope) {
+	c.checkBlock(statements, s, s.end)
+	// all declarations of the scope are known now
+	for _, check := range s.deferred {
+		check()
+	}
+}
+
+func (c *checker) checkBlock(statements []AstStatement, s *checkScope, end int) {
+	outerEnd := c.stmtEnd
+	for i, stmt := range statements {
+		line := stmt.GetToken().Line
+		c.stmtEnd = end
+		if i+1 < len(statements) {
+			c.stmtEnd = statements[i+1].GetToken().Line - 1
+			if c.stmtEnd < line {
+				c.stmtEnd = line
+			}
+		}
+		c.checkStatement(stmt, s)
+	}
+	c.stmtEnd = outerEnd
+}
+
+func (c *checker) checkStatement(node AstStatement, s *checkScope) {
+	switch n := node.(type) {
+	case *AstStatementWithVoidedExpression:
+		c.expr(n.Expr, s)
+	case *AstReturn:
+		t := c.expr(n.ReturnValue, s)
+		if s.function && t != "" && !sameType(t, s.returnType) {
+			c.errorf(n, "Return type mismatch: function declared as '%s' but in fact return '%s'", s.returnType, t)
+		}
+	case *AstIf:
+		c.condition(n.Condition, s, "Condition should be boolean type but %s in fact")
+		paths := c.newPaths(s)
+		c.checkBranch(n.PositiveBranch, s, paths)
+		c.checkBranch(n.ElseBranch, s, paths)
+		paths.join(s)
+	case *AstSwitch:
+		paths := c.newPaths(s)
+		for _, cs := range n.Cases {
+			c.condition(cs.Condition, s, "Result of case condition should be 'boolean' but '%s' given")
+			c.checkBranch(cs.PositiveBranch, s, paths)
+		}
+		c.checkBranch(n.DefaultBranch, s, paths)
+		paths.join(s)
+	case *AstStructDefinition:
+		c.declareStruct(n, s)
+	case *AstEnumDefinition:
+		c.declareEnum(n, s)
+	case *AstEventHandler:
+		if s.handlers[n.Event] {
+			c.errorf(n, "handler for event '%s' already defined in this scope", n.Event)
+		}
+		s.handlers[n.Event] = true
+		start, end := n.Token.Line, c.stmtEnd
+		s.deferred = append(s.deferred, func() {
+			c.checkBody(n, n.Arguments, n.StatementsBlock, false, "", s, start, end)
+		})
+	case *AstTest:
+		if s.outer != nil {
+			c.errorf(n, "test '%s' should be declared at the top level", n.Name)
+		}
+		if s.tests[n.Name] {
+			c.errorf(n, "test '%s' already defined", n.Name)
+		}
+		s.tests[n.Name] = true
+		start, end := n.Token.Line, c.stmtEnd
+		s.deferred = append(s.deferred, func() {
+			c.checkBody(n, nil, n.StatementsBlock, false, "", c.testScope(s, start, end), start, end)
+		})
+	case *AstPersist:
+		if n.Assignment != nil {
+			c.assignment(n.Assignment, s)
+		}
+	}
+}
+
+// paths are assigned variables at the ends of branches of if or switch
+type paths struct {
+	before assignedVars
+	// ends are assigned variables at the ends of branches the execution continues after
+	ends []assignedVars
+}
+
+func (c *checker) newPaths(s *checkScope) *paths {
+	return &paths{before: s.assigned}
+}
+
+// checkBranch checks the branch starting with variables assigned before if or switch, missing branch
+// (else or default) is the path where nothing is assigned
+func (c *checker) checkBranch(block *AstStatementsBlock, s *checkScope, p *paths) {
+	s.assigned = p.before.copy()
+	if block != nil {
+		c.checkBlock(block.Statements, s, c.stmtEnd)
+		if blockReturns(block) {
+			return
+		}
+	}
+	p.ends = append(p.ends, s.assigned)
+}
+
+// join leaves variables assigned on every path continuing after the statement
+func (p *paths) join(s *checkScope) {
+	if len(p.ends) == 0 {
+		// every branch returns, the code after the statement is unreachable
+		s.assigned = p.before
+		return
+	}
+	s.assigned = p.ends[0]
+	for _, end := range p.ends[1:] {
+		for sym := range s.assigned {
+			if !end[sym] {
+				delete(s.assigned, sym)
+			}
+		}
+	}
+}
+
+func (c *checker) condition(node AstExpression, s *checkScope, format string) {
+	if t := c.expr(node, s); t != "" && t != TypeBool {
+		c.errorf(node, format, t)
+	}
+}
+
+// checkBody checks the body of function or event handler in its own scope
+func (c *checker) checkBody(
+	node AstNode,
+	args []*AstVarAndType,
+	body *AstStatementsBlock,
+	function bool,
+	returnType string,
+	outer *checkScope,
+	start, end int,
+) {
+	s := c.newScope(outer, start, end)
+	s.function = function
+	s.returnType = returnType
+	if function {
+		c.checkTypeName(node, returnType, s)
+	}
+	for _, arg := range args {
+		c.checkTypeName(arg, arg.VarType, s)
+		sym := &Symbol{Name: arg.Var.Value, Kind: SymbolVar, Type: arg.VarType, Token: arg.Var.Token}
+		s.vars[sym.Name] = sym
+		s.assigned[sym] = true
+		c.use(arg.Var, sym)
+	}
+	if body != nil {
+		c.checkScopeBody(body.Statements, s)
+	}
+	if function && returnType != TypeVoid && !blockReturns(body) {
+		c.errorf(node, "function declared as '%s' doesn't return a value on every path", returnType)
+	}
+}
+
+func (c *checker) declareStruct(node *AstStructDefinition, s *checkScope) {
+	if node.Name == TypeVec2 {
+		c.errorf(node, "struct '%s' is a builtin type and can't be redefined", node.Name)
+		return
+	}
+	if _, exists := s.structs[node.Name]; exists {
+		c.errorf(node, "struct '%s' already defined in this scope", node.Name)
+		return
+	}
+	s.structs[node.Name] = structSymbol(node)
+	s.deferred = append(s.deferred, func() {
+		for _, name := range sortedKeys(node.Fields) {
+			c.checkTypeName(node.Fields[name], node.Fields[name].VarType, s)
+		}
+	})
+}
+
+func (c *checker) declareEnum(node *AstEnumDefinition, s *checkScope) {
+	if _, exists := s.enums[node.Name]; exists {
+		c.errorf(node, "enum '%s' already defined in this scope", node.Name)
+		return
+	}
+	s.enums[node.Name] = enumSymbol(node)
+}
+
+func (c *checker) checkTypeName(node AstNode, t string, s *checkScope) {
+	if !c.knownType(t, s) {
+		c.errorf(node, "unknown type '%s'", t)
+	}
+}
+
+func (c *checker) knownType(t string, s *checkScope) bool {
+	if strings.HasPrefix(t, "[]") {
+		return t != "[]"+TypeVoid && c.knownType(strings.TrimPrefix(t, "[]"), s)
+	}
+	switch t {
+	case TypeInt, TypeFloat, TypeBool, TypeVec2, TypeVoid:
+		return true
+	}
+	return c.lookupStruct(s, t) != nil || c.lookupEnum(s, t) != nil
+}
+
+// expr returns the type of the expression or empty string if the type is unknown because of errors
+func (c *checker) expr(node AstExpression, s *checkScope) string {
+	if node == nil {
+		return ""
+	}
+	t := c.inferExpr(node, s)
+	if t != "" && !c.quiet {
+		c.info.Types[node] = t
+	}
+	return t
+}
+
+func (c *checker) inferExpr(node AstExpression, s *checkScope) string {
+	switch n := node.(type) {
+	case *AstAssignment:
+		return c.assignment(n, s)
+	case *AstStructFieldAssignment:
+		return c.structFieldAssignment(n, s)
+	case *AstUnary:
+		return c.unary(n, s)
+	case *AstEmptier:
+		return c.emptier(n, s)
+	case *AstBinOperation:
+		return c.binOperation(n, s)
+	case *AstStruct:
+		return c.structLiteral(n, s)
+	case *AstStructFieldCall:
+		return c.structFieldCall(n, s)
+	case *AstEnumElementCall:
+		return c.enumElementCall(n, s)
+	case *AstNumInt:
+		return TypeInt
+	case *AstNumFloat:
+		return TypeFloat
+	case *AstBoolean:
+		return TypeBool
+	case *AstArray:
+		return c.arrayLiteral(n, s)
+	case *AstArrayIndexCall:
+		return c.arrayIndexCall(n, s)
+	case *AstIdentifier:
+		return c.identifier(n, s)
+	case *AstFunction:
+		return c.function(n, s)
+	case *AstFunctionCall:
+		return c.functionCall(n, s)
+	default:
+		return ""
+	}
+}
+
+func (c *checker) assignment(node *AstAssignment, s *checkScope) string {
+	name := node.Left.Value
+	if _, isBuiltin := c.builtins[name]; isBuiltin {
+		c.errorf(node.Left, "Builtins are immutable")
+	}
+	t := c.expr(node.Value, s)
+	existing := c.lookupVar(s, name)
+	if existing != nil && existing.Type != "" && t != "" && !sameType(existing.Type, t) {
+		c.errorf(node.Value, "type mismatch on assignment: var type is %s and value type is %s", existing.Type, t)
+	}
+	if c.quiet {
+		return t
+	}
+
+	// as in runtime, assignment in function or handler declares local variable even if outer one exists
+	sym, ok := s.vars[name]
+	if !ok {
+		sym = &Symbol{Name: name, Kind: SymbolVar, Type: t, Token: node.Left.Token}
+		if existing != nil && sym.Type == "" {
+			sym.Type = existing.Type
+		}
+		s.vars[name] = sym
+	} else if sym.Type == "" {
+		sym.Type = t
+	}
+	s.assigned[sym] = true
+	c.use(node.Left, sym)
+	return t
+}
+
+func (c *checker) structFieldAssignment(node *AstStructFieldAssignment, s *checkScope) string {
+	t := c.expr(node.Value, s)
+	if node.Left == nil {
+		return t
+	}
+	left := c.expr(node.Left.StructExpr, s)
+	if left == TypeVec2 {
+		c.errorf(node, "vec2 is immutable, create a new one with vec2{x = ..., y = ...}")
+		return t
+	}
+	fieldType := c.field(node.Left, left, s)
+	if fieldType != "" && !c.quiet {
+		c.info.Types[node.Left] = fieldType
+	}
+	if fieldType != "" && t != "" && fieldType != t {
+		c.errorf(node, "Field '%s' defined as '%s' but '%s' given", node.Left.Field.Value, fieldType, t)
+	}
+	return t
+}
+
+func (c *checker) unary(node *AstUnary, s *checkScope) string {
+	t := c.expr(node.Right, s)
+	if t == "" {
+		return ""
+	}
+	switch node.Operator {
+	case TokenNot:
+		if t != TypeBool {
+			c.errorf(node, "Operator '!' could be applied only on bool, '%s' given", t)
+			return ""
+		}
+		return TypeBool
+	case TokenMinus:
+		if t != TypeInt && t != TypeFloat && t != TypeVec2 {
+			c.errorf(node, "unknown operator: -%s", t)
+			return ""
+		}
+		return t
+	default:
+		c.errorf(node, "unknown operator: %s%s", node.Operator, t)
+		return ""
+	}
+}
+
+func (c *checker) emptier(node *AstEmptier, s *checkScope) string {
+	supported := node.Type == TypeInt || node.Type == TypeFloat || node.Type == TypeVec2 ||
+		c.lookupStruct(s, node.Type) != nil
+	if node.IsArray {
+		if !supported {
+			c.errorf(node, "? is not supported on type: '%s[]'", node.Type)
+			return ""
+		}
+		return "[]" + node.Type
+	}
+	if !supported {
+		c.errorf(node, "? is not supported on type: '%s'", node.Type)
+		return ""
+	}
+	return node.Type
+}
+
+func (c *checker) binOperation(node *AstBinOperation, s *checkScope) string {
+	left := c.expr(node.Left, s)
+	right := c.expr(node.Right, s)
+	if left == "" || right == "" {
+		return ""
+	}
+	if isVec2ScalarType(left, right, node.Operator) {
+		return TypeVec2
+	}
+	if left != right {
+		c.errorf(node, "forbidden operation on different types: %s and %s", left, right)
+		return ""
+	}
+
+	var arithmetic, comparison, logical bool
+	switch node.Operator {
+	case TokenPlus, TokenMinus, TokenAsterisk, TokenSlash:
+		arithmetic = true
+	case TokenLt, TokenGt:
+		comparison = true
+	case TokenAnd, TokenOr:
+		logical = true
+	}
+	equality := node.Operator == TokenEq || node.Operator == TokenNotEq
+
+	switch {
+	case left == TypeInt || left == TypeFloat:
+		if arithmetic {
+			return left
+		}
+		if comparison || equality {
+			return TypeBool
+		}
+	case left == TypeBool:
+		if logical || equality {
+			return TypeBool
+		}
+	case left == TypeVec2:
+		if node.Operator == TokenPlus || node.Operator == TokenMinus {
+			return TypeVec2
+		}
+		if equality {
+			return TypeBool
+		}
+	case c.lookupEnum(s, left) != nil:
+		if node.Operator == TokenEq {
+			return TypeBool
+		}
+		c.errorf(node, "unsupported operator '%s' for type: '%s'", node.Operator, left)
+		return ""
+	default:
+		c.errorf(node, "unsupported operator '%s' for type: '%s'", node.Operator, left)
+		return ""
+	}
+	c.errorf(node, "unsupported operator for types: %s %s %s", left, node.Operator, right)
+	return ""
+}
+
+func isVec2ScalarType(left, right string, operator TokenID) bool {
+	if operator != TokenAsterisk && operator != TokenSlash {
+		return false
+	}
+	if left == TypeVec2 && right == TypeFloat {
+		return true
+	}
+	return operator == TokenAsterisk && left == TypeFloat && right == TypeVec2
+}
+
+func (c *checker) structLiteral(node *AstStruct, s *checkScope) string {
+	def := c.lookupStruct(s, node.Ident.Value)
+	if def == nil {
+		c.errorf(node, "Struct '%s' is not defined", node.Ident.Value)
+		for _, f := range node.Fields {
+			c.expr(f.Value, s)
+		}
+		return ""
+	}
+	c.use(node.Ident, def)
+
+	filled := make(map[string]bool)
+	for _, f := range node.Fields {
+		t := c.expr(f.Value, s)
+		field := def.Members[f.Left.Value]
+		if field == nil || field.Kind != SymbolField {
+			if node.Ident.Value == TypeVec2 {
+				c.errorf(f, "vec2 doesn't have the field '%s'", f.Left.Value)
+			} else {
+				c.errorf(f, "Struct '%s' doesn't have the field '%s' in the definition", def.Name, f.Left.Value)
+			}
+			continue
+		}
+		c.use(f.Left, field)
+		if t != "" && t != field.Type {
+			c.errorf(f, "Field '%s' defined as '%s' but '%s' given", f.Left.Value, field.Type, t)
+		}
+		filled[f.Left.Value] = true
+	}
+
+	fieldsCount := 0
+	for _, m := range def.Members {
+		if m.Kind == SymbolField {
+			fieldsCount++
+		}
+	}
+	if len(filled) != fieldsCount && def.Name == TypeVec2 {
+		c.errorf(node, "Var of vec2 should have 2 fields filled but in fact only %d", len(filled))
+	} else if len(filled) != fieldsCount {
+		c.errorf(node, "Var of struct '%s' should have %d fields filled but in fact only %d",
+			def.Name, fieldsCount, len(filled))
+	}
+	return def.Name
+}
+
+func (c *checker) structFieldCall(node *AstStructFieldCall, s *checkScope) string {
+	return c.field(node, c.expr(node.StructExpr, s), s)
+}
+
+// field returns the type of the field (or method for vec2) of the left value type
+func (c *checker) field(node *AstStructFieldCall, left string, s *checkScope) string {
+	if left == "" || node.Field == nil {
+		return ""
+	}
+	def := c.lookupStruct(s, left)
+	if def == nil {
+		c.errorf(node, "Field access can be only on struct but '%s' given", left)
+		return ""
+	}
+	field, ok := def.Members[node.Field.Value]
+	if !ok {
+		if left == TypeVec2 {
+			c.errorf(node, "vec2 doesn't have field or method '%s'", node.Field.Value)
+		} else {
+			c.errorf(node, "Struct '%s' doesn't have field '%s'", def.Name, node.Field.Value)
+		}
+		return ""
+	}
+	c.use(node.Field, field)
+	return field.Type
+}
+
+func (c *checker) enumElementCall(node *AstEnumElementCall, s *checkScope) string {
+	t := c.expr(node.EnumExpr, s)
+	if t == "" {
+		return ""
+	}
+	enum := c.lookupEnum(s, t)
+	if enum == nil {
+		c.errorf(node, "Expected enum, got '%s'", t)
+		return ""
+	}
+	element, ok := enum.Members[node.Element.Value]
+	if !ok {
+		c.errorf(node, "Enum '%s' doesn't have element '%s'", enum.Name, node.Element.Value)
+		return ""
+	}
+	c.use(node.Element, element)
+	return t
+}
+
+func (c *checker) arrayLiteral(node *AstArray, s *checkScope) string {
+	c.checkTypeName(node, node.ElementsType, s)
+	for i, el := range node.Elements {
+		if t := c.expr(el, s); t != "" && t != node.ElementsType {
+			c.errorf(node, "Array element #%d should be type '%s' but '%s' given", i+1, node.ElementsType, t)
+		}
+	}
+	return "[]" + node.ElementsType
+}
+
+func (c *checker) arrayIndexCall(node *AstArrayIndexCall, s *checkScope) string {
+	left := c.expr(node.Left, s)
+	index := c.expr(node.Index, s)
+	if index != "" && index != TypeInt {
+		c.errorf(node, "Array access can be only by 'int' type but '%s' given", index)
+	}
+	if left == "" {
+		return ""
+	}
+	if !strings.HasPrefix(left, "[]") {
+		c.errorf(node, "Array access can be only on arrays but '%s' given", left)
+		return ""
+	}
+	return strings.TrimPrefix(left, "[]")
+}
+
+func (c *checker) identifier(node *AstIdentifier, s *checkScope) string {
+	var sym *Symbol
+	// the same order as in runtime: builtins, enums and then variables
+	if _, ok := c.builtins[node.Value]; ok {
+		sym = c.builtinSymbol(node.Value)
+	} else if enum := c.lookupEnum(s, node.Value); enum != nil {
+		sym = enum
+	} else {
+		sym = c.lookupVar(s, node.Value)
+	}
+	if sym == nil {
+		c.errorf(node, "identifier not found: %s", node.Value)
+		return ""
+	}
+	// variables of outer scopes are used by functions and handlers called later, when they are assigned
+	if sym.Kind == SymbolVar && s.vars[node.Value] == sym && !s.assigned[sym] {
+		c.errorf(node, "variable '%s' is not assigned on every path before use", node.Value)
+	}
+	c.use(node, sym)
+	return sym.Type
+}
+
+func (c *checker) function(node *AstFunction, s *checkScope) string {
+	args := make([]string, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		args[i] = arg.VarType
+	}
+	if !c.quiet {
+		start, end := node.Token.Line, c.stmtEnd
+		s.deferred = append(s.deferred, func() {
+			c.checkBody(node, node.Arguments, node.StatementsBlock, true, node.ReturnType, s, start, end)
+		})
+	}
+	return fnType(args, node.ReturnType)
+}
+
+func (c *checker) functionCall(node *AstFunctionCall, s *checkScope) string {
+	fn := c.expr(node.Function, s)
+	argTypes := make([]string, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		argTypes[i] = c.expr(arg, s)
+	}
+	if fn == "" {
+		return ""
+	}
+	declared, returnType, ok := parseFnType(fn)
+	if !ok {
+		c.errorf(node, "not a function: %s", fn)
+		return ""
+	}
+	if declared == nil {
+		return returnType
+	}
+
+	builtin := c.builtinName(node.Function)
+	if len(declared) != len(argTypes) {
+		if builtin != "" {
+			c.errorf(node, "wrong number of arguments for '%s'. need %d, got %d", builtin, len(declared), len(argTypes))
+		} else {
+			c.errorf(node, "Function call arguments count mismatch: declared %d, but called %d",
+				len(declared), len(argTypes))
+		}
+		return returnType
+	}
+	for i, t := range argTypes {
+		if t == "" || argTypeMatches(declared[i], t) {
+			continue
+		}
+		if builtin != "" {
+			c.errorf(node.Arguments[i], "wrong type of argument #%d for '%s'. need %s, got %s",
+				i+1, builtin, declared[i], t)
+		} else {
+			c.errorf(node.Arguments[i], "argument #%d type mismatch: expected '%s' by func declaration but called '%s'",
+				i+1, declared[i], t)
+		}
+	}
+	return returnType
+}
+
+// builtinName returns the name of builtin function or vec2 method called, builtins have own error messages
+func (c *checker) builtinName(node AstExpression) string {
+	var ident *AstIdentifier
+	switch n := node.(type) {
+	case *AstIdentifier:
+		ident = n
+	case *AstStructFieldCall:
+		ident = n.Field
+	default:
+		return ""
+	}
+	if sym := c.info.Uses[ident]; sym != nil && sym.Kind == SymbolBuiltin {
+		return sym.Name
+	}
+	return ""
+}
+
+func argTypeMatches(declared, actual string) bool {
+	switch declared {
+	case "any":
+		return true
+	case "array":
+		return strings.HasPrefix(actual, "[]")
+	default:
+		return declared == actual
+	}
+}
+
+// sameType reports whether value of one type could be assigned to variable of another.
+// Functions are not distinguished by signature in runtime
+func sameType(a, b string) bool {
+	return a == b || isFnType(a) && isFnType(b)
+}
+
+func isFnType(t string) bool {
+	return strings.HasPrefix(t, "fn(")
+}
+
+// fnType returns the type of function, nil args means arguments are not checked
+func fnType(args []string, returnType string) string {
+	if args == nil {
+		return fmt.Sprintf("fn(...) %s", returnType)
+	}
+	return fmt.Sprintf("fn(%s) %s", strings.Join(args, ", "), returnType)
+}
+
+func parseFnType(t string) ([]string, string, bool) {
+	end := strings.LastIndex(t, ") ")
+	if !isFnType(t) || end < 0 {
+		return nil, "", false
+	}
+	returnType := t[end+2:]
+	switch args := t[len("fn("):end]; args {
+	case "...":
+		return nil, returnType, true
+	case "":
+		return []string{}, returnType, true
+	default:
+		return strings.Split(args, ", "), returnType, true
+	}
+}
+
+func (c *checker) lookupVar(s *checkScope, name string) *Symbol {
+	for ; s != nil; s = s.outer {
+		if sym, ok := s.vars[name]; ok {
+			return sym
+		}
+	}
+	return c.hostVar(name)
+}
+
+func (c *checker) lookupStruct(s *checkScope, name string) *Symbol {
+	if name == TypeVec2 {
+		return c.vec2
+	}
+	for ; s != nil; s = s.outer {
+		if sym, ok := s.structs[name]; ok {
+			return sym
+		}
+	}
+	return c.hostStruct(name)
+}
+
+func (c *checker) lookupEnum(s *checkScope, name string) *Symbol {
+	for ; s != nil; s = s.outer {
+		if sym, ok := s.enums[name]; ok {
+			return sym
+		}
+	}
+	return c.hostEnum(name)
+}
+
+func (c *checker) hostVar(name string) *Symbol {
+	return c.hostSymbol("var:"+name, func() *Symbol {
+		obj, ok := c.env.Get(name)
+		if !ok {
+			return nil
+		}
+		return &Symbol{Name: name, Kind: SymbolVar, Type: objectTypeName(obj)}
+	})
+}
+
+func (c *checker) hostStruct(name string) *Symbol {
+	return c.hostSymbol("struct:"+name, func() *Symbol {
+		if def, ok := c.env.StructDefinition(name); ok {
+			return structSymbol(def)
+		}
+		return nil
+	})
+}
+
+func (c *checker) hostEnum(name string) *Symbol {
+	return c.hostSymbol("enum:"+name, func() *Symbol {
+		if def, ok := c.env.EnumDefinition(name); ok {
+			return enumSymbol(def)
+		}
+		return nil
+	})
+}
+
+func (c *checker) builtinSymbol(name string) *Symbol {
+	return c.hostSymbol("builtin:"+name, func() *Symbol {
+		if b, ok := c.builtins[name]; ok {
+			return &Symbol{Name: name, Kind: SymbolBuiltin, Type: builtinType(b, 0)}
+		}
+		return nil
+	})
+}
+
+// hostSymbol returns cached symbol, so all uses of the host declaration refer to the same symbol
+func (c *checker) hostSymbol(key string, create func() *Symbol) *Symbol {
+	if sym, ok := c.host[key]; ok {
+		return sym
+	}
+	sym := create()
+	c.host[key] = sym
+	return sym
+}
+
+func (c *checker) use(ident *AstIdentifier, sym *Symbol) {
+	if !c.quiet {
+		c.info.Uses[ident] = sym
+	}
+}
+
+func (c *checker) errorf(node AstNode, format string, args ...interface{}) {
+	if c.quiet {
+		return
+	}
+	t := node.GetToken()
+	c.info.Errors = append(c.info.Errors, &CheckError{Msg: fmt.Sprintf(format, args...), Line: t.Line, Col: t.Col})
+}
+
+func structSymbol(def *AstStructDefinition) *Symbol {
+	sym := &Symbol{Name: def.Name, Kind: SymbolStruct, Type: def.Name, Token: def.Token, Members: make(map[string]*Symbol)}
+	for name, field := range def.Fields {
+		sym.Members[name] = &Symbol{Name: name, Kind: SymbolField, Type: field.VarType, Token: field.Var.Token}
+	}
+	return sym
+}
+
+func enumSymbol(def *AstEnumDefinition) *Symbol {
+	sym := &Symbol{Name: def.Name, Kind: SymbolEnum, Type: def.Name, Token: def.Token, Members: make(map[string]*Symbol)}
+	for _, el := range def.Elements {
+		sym.Members[el] = &Symbol{Name: el, Kind: SymbolEnumElement, Type: def.Name, Token: def.Token}
+	}
+	return sym
+}
+
+// vec2Symbol describes builtin vec2 type as a struct with methods, receiver is not in methods arguments
+func vec2Symbol(methods map[string]*ObjBuiltin) *Symbol {
+	sym := &Symbol{Name: TypeVec2, Kind: SymbolStruct, Type: TypeVec2, Members: make(map[string]*Symbol)}
+	for _, coord := range []string{"x", "y"} {
+		sym.Members[coord] = &Symbol{Name: coord, Kind: SymbolField, Type: TypeFloat}
+	}
+	for name, method := range methods {
+		sym.Members[name] = &Symbol{Name: name, Kind: SymbolBuiltin, Type: builtinType(method, 1)}
+	}
+	return sym
+}
+
+// builtinType returns the type of builtin without first skip arguments
+func builtinType(b *ObjBuiltin, skip int) string {
+	if b.ArgTypes == nil {
+		return fnType(nil, b.ReturnType)
+	}
+	return fnType(append([]string{}, b.ArgTypes[skip:]...), b.ReturnType)
+}
+
+func objectTypeName(obj Object) string {
+	switch o := obj.(type) {
+	case *ObjFunction:
+		args := make([]string, len(o.Arguments))
+		for i, arg := range o.Arguments {
+			args[i] = arg.VarType
+		}
+		return fnType(args, o.ReturnType)
+	case *ObjBuiltin:
+		return builtinType(o, 0)
+	default:
+		return string(obj.Type())
+	}
+}
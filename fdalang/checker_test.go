@@ -0,0 +1,364 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"fmt"
+	"testing"
+)
+
+func checkSource(t *testing.T, e *ExecAstVisitor, env *Environment, input string) *TypeInfo {
+	program, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	return e.Check(program, env)
+}
+
+func checkErrors(info *TypeInfo) []string {
+	var errors []string
+	for _, e := range info.Errors {
+		errors = append(errors, fmt.Sprintf("%d: %s", e.Line, e.Msg))
+	}
+	return errors
+}
+
+func TestCheck(t *testing.T) {
+	input := `struct target {
+   vec2 pos
+   Kind kind
+   []float hits
+}
+enum Kind {xelon, spore}
+nearest = fn([]target targets, vec2 from) target {
+   best = ?target
+   if length(targets) > 0 {
+      best = targets[0]
+   }
+   switch best.kind {
+   case == Kind:spore
+      return best
+   }
+   d = from.distance(best.pos) * limit
+   return best
+}
+limit = 2.
+t = nearest([]target{target{pos = vec2{x = 1., y = 2.}, kind = Kind:xelon, hits = []float{}}}, vec2{x = 0., y = 0.})
+t.kind = Kind:spore
+persist shots = 0
+on hit(int damage) {
+   shots = shots + damage
+   dir = -t.pos.norm() * 2.
+}
+`
+	info := checkSource(t, NewExecAstVisitor(), nil, input)
+	require.Empty(t, checkErrors(info))
+
+	ident, sym := info.IdentAt(16, 18)
+	require.Equal(t, "distance", ident.Value)
+	require.Equal(t, SymbolBuiltin, sym.Kind)
+	require.Equal(t, "fn(vec2) float", sym.Type)
+
+	// limit is assigned after the function declaration but before it's called
+	_, sym = info.IdentAt(16, 35)
+	require.Equal(t, "limit", sym.Name)
+	require.Equal(t, 19, sym.Token.Line)
+
+	_, sym = info.IdentAt(21, 1)
+	require.Equal(t, "target", sym.Type)
+	_, sym = info.IdentAt(21, 3)
+	require.Equal(t, SymbolField, sym.Kind)
+	require.Equal(t, 3, sym.Token.Line)
+
+	_, sym = info.IdentAt(25, 4)
+	require.Equal(t, "dir", sym.Name)
+	require.Equal(t, TypeVec2, sym.Type)
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected []string
+	}{
+		"types": {
+			input: `a = 1
+a = 2.
+b = a + 1.
+c = !a
+d = -true
+e = ?bool
+`,
+			expected: []string{
+				"2: type mismatch on assignment: var type is int and value type is float",
+				"3: forbidden operation on different types: int and float",
+				"4: Operator '!' could be applied only on bool, 'int' given",
+				"5: unknown operator: -bool",
+				"6: ? is not supported on type: 'bool'",
+			},
+		},
+		"identifiers": {
+			input: `a = b
+print = 1
+`,
+			expected: []string{
+				"1: identifier not found: b",
+				"2: Builtins are immutable",
+			},
+		},
+		"functions": {
+			input: `f = fn(int a, point p) int {
+   if a {
+      return 1.
+   }
+   return a
+}
+b = f(1)
+c = f(1., 2)
+d = absInt(1.)
+e = absInt(1, 2)
+g = b()
+`,
+			expected: []string{
+				"1: unknown type 'point'",
+				"2: Condition should be boolean type but int in fact",
+				"3: Return type mismatch: function declared as 'int' but in fact return 'float'",
+				"7: Function call arguments count mismatch: declared 2, but called 1",
+				"8: argument #1 type mismatch: expected 'int' by func declaration but called 'float'",
+				"8: argument #2 type mismatch: expected 'point' by func declaration but called 'int'",
+				"9: wrong type of argument #1 for 'absInt'. need int, got float",
+				"10: wrong number of arguments for 'absInt'. need 1, got 2",
+				"11: not a function: int",
+			},
+		},
+		"structs": {
+			input: `struct point {
+   float x
+}
+struct point {
+   float y
+}
+p = point{x = 1}
+p = point{y = 1.}
+p.y = 1.
+p.x = 1
+v = vec2{x = 1., y = 1.}
+v.x = 2.
+w = v.size()
+n = p[0]
+`,
+			expected: []string{
+				"4: struct 'point' already defined in this scope",
+				"7: Field 'x' defined as 'float' but 'int' given",
+				"8: Var of struct 'point' should have 1 fields filled but in fact only 0",
+				"8: Struct 'point' doesn't have the field 'y' in the definition",
+				"9: Struct 'point' doesn't have field 'y'",
+				"10: Field 'x' defined as 'float' but 'int' given",
+				"12: vec2 is immutable, create a new one with vec2{x = ..., y = ...}",
+				"13: vec2 doesn't have field or method 'size'",
+				"14: Array access can be only on arrays but 'point' given",
+			},
+		},
+		"enums and arrays": {
+			input: `enum state {idle, moving}
+s = state:flying
+b = state:idle + state:moving
+a = []int{1, 2.}
+c = a[1.]
+`,
+			expected: []string{
+				"2: Enum 'state' doesn't have element 'flying'",
+				"3: unsupported operator '+' for type: 'state'",
+				"4: Array element #2 should be type 'int' but 'float' given",
+				"5: Array access can be only by 'int' type but 'float' given",
+			},
+		},
+		"handlers": {
+			input: `on tick {
+   a = 1 + true
+}
+on tick {
+   b = 1
+}
+`,
+			expected: []string{
+				"2: forbidden operation on different types: int and bool",
+				"4: handler for event 'tick' already defined in this scope",
+			},
+		},
+		"tests": {
+			input: `f = fn() int {
+   return 1
+}
+test "f" {
+   assertEq(f(), 1)
+   assert(f())
+}
+test "f" {
+   if true {
+      test "nested" {
+         a = 1
+      }
+   }
+}
+`,
+			expected: []string{
+				"6: wrong type of argument #1 for 'assert'. need bool, got int",
+				"8: test 'f' already defined",
+				"10: test 'nested' should be declared at the top level",
+			},
+		},
+		"missing return": {
+			input: `enum kind {rock, metal}
+sign = fn(int x) int {
+   if x > 0 {
+      return 1
+   } else {
+      if x < 0 {
+         return -1
+      }
+   }
+}
+name = fn(kind k) int {
+   switch k {
+   case == kind:rock
+      return 1
+   case == kind:metal
+      return 2
+   }
+}
+abs = fn(int x) int {
+   switch {
+   case x < 0
+      return -x
+   default
+      return x
+   }
+}
+log = fn(int x) void {
+   print(x)
+}
+none = fn() float {
+}
+`,
+			expected: []string{
+				"2: function declared as 'int' doesn't return a value on every path",
+				"11: function declared as 'int' doesn't return a value on every path",
+				"30: function declared as 'float' doesn't return a value on every path",
+			},
+		},
+		"definite assignment": {
+			input: `x = 1
+if x > 0 {
+   a = 1
+   b = 1
+} else {
+   b = 2
+}
+c = a + b
+switch x {
+case == 1
+   d = 1
+case == 2
+   return 0
+default
+   d = 2
+}
+e = d
+if x > 2 {
+   return 0
+}
+f = fn(int n) int {
+   if n > 0 {
+      m = n
+   }
+   return m + a
+}
+on tick() {
+   g = a
+}
+`,
+			expected: []string{
+				"8: variable 'a' is not assigned on every path before use",
+				"25: variable 'm' is not assigned on every path before use",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		info := checkSource(t, NewExecAstVisitor(), nil, test.input)
+		require.Equal(t, test.expected, checkErrors(info), name)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	type Mech struct {
+		Pos Vec2  `fda:"pos"`
+		HP  int64 `fda:"hp"`
+	}
+	env := NewEnvironment()
+	require.Nil(t, env.Bind("mech", &Mech{HP: 100}))
+
+	e := NewExecAstVisitor()
+	e.AddBuiltinFunctions(map[string]*ObjBuiltin{
+		"shoot": {Name: "shoot", ArgTypes: ArgTypes{"Mech", TypeFloat}, ReturnType: TypeBool},
+		"log":   {Name: "log", ReturnType: TypeVoid},
+	})
+
+	input := `ok = shoot(mech, 1.)
+shoot(mech.pos, 1.)
+log(1, mech)
+hp = mech.hp + 1.
+`
+	info := checkSource(t, e, env, input)
+	require.Equal(t, []string{
+		"2: wrong type of argument #1 for 'shoot'. need Mech, got vec2",
+		"4: forbidden operation on different types: int and float",
+	}, checkErrors(info))
+	_, sym := info.IdentAt(1, 1)
+	require.Equal(t, TypeBool, sym.Type)
+
+	names := make([]string, 0)
+	for _, sym := range info.Members("Mech", 1) {
+		names = append(names, sym.Name+" "+sym.Type)
+	}
+	require.Equal(t, []string{"hp int", "pos vec2"}, names)
+}
+
+func TestCheckScopes(t *testing.T) {
+	input := `struct point {
+   float x
+}
+enum state {idle, moving}
+f = fn(point p) float {
+   k = p.x
+
+   return k
+}
+s = state:idle
+`
+	info := checkSource(t, NewExecAstVisitor(), nil, input)
+	require.Empty(t, info.Errors)
+
+	expr, err := NewParser(NewLexer("p")).ParseExpression()
+	require.Nil(t, err)
+	// blank line in the function body is in its scope
+	require.Equal(t, "point", info.ExprType(expr, 7))
+	require.Equal(t, "", info.ExprType(expr, 10))
+
+	visible := func(line int) []string {
+		var names []string
+		for _, sym := range info.Visible(line) {
+			if sym.Kind != SymbolBuiltin {
+				names = append(names, sym.Name)
+			}
+		}
+		return names
+	}
+	require.Equal(t, []string{"f", "k", "p", "point", "s", "state"}, visible(7))
+	require.Equal(t, []string{"f", "point", "s", "state"}, visible(10))
+
+	var elements []string
+	for _, sym := range info.Members("state", 10) {
+		elements = append(elements, sym.Name)
+	}
+	require.Equal(t, []string{"idle", "moving"}, elements)
+	require.Equal(t, 4, info.TypeSymbol("state", 10).Token.Line)
+}
@@ -0,0 +1,70 @@
+package fdalang
+
+import (
+	"context"
+	"fmt"
+)
+
+// ExecInterruptedError is returned when the execution is stopped because the context is done.
+// It wraps the context error, so errors.Is(err, context.DeadlineExceeded) could be used.
+type ExecInterruptedError struct {
+	Err  error
+	Line int
+	Col  int
+}
+
+func (e *ExecInterruptedError) Error() string {
+	return fmt.Sprintf("execution interrupted: %s\nline:%d, pos %d", e.Err.Error(), e.Line, e.Col)
+}
+
+func (e *ExecInterruptedError) Unwrap() error {
+	return e.Err
+}
+
+func interruptedError(node AstNode, err error) error {
+	t := node.GetToken()
+	return &ExecInterruptedError{Err: err, Line: t.Line, Col: t.Col}
+}
+
+// ExecAstContext is ExecAst which stops when the context is done. The context is checked before
+// every statement and function call and is passed to builtins with CtxFn, so a blocked builtin
+// could be interrupted too.
+func (e *ExecAstVisitor) ExecAstContext(ctx context.Context, ast *AstStatementsBlock, env *Environment) error {
+	defer e.setContext(ctx)()
+	return e.ExecAst(ast, env)
+}
+
+// DispatchContext is Dispatch which stops when the context is done, see ExecAstContext
+func (e *ExecAstVisitor) DispatchContext(ctx context.Context, env *Environment, events []Event) error {
+	defer e.setContext(ctx)()
+	return e.Dispatch(env, events)
+}
+
+// CallContext is Call which stops when the context is done, see ExecAstContext
+func (e *ExecAstVisitor) CallContext(
+	ctx context.Context,
+	env *Environment,
+	name string,
+	args ...Object,
+) (Object, error) {
+	defer e.setContext(ctx)()
+	return e.Call(env, name, args...)
+}
+
+// setContext sets context for the execution and returns function restoring the previous one
+func (e *ExecAstVisitor) setContext(ctx context.Context) func() {
+	prev := e.ctx
+	e.ctx = ctx
+	return func() {
+		e.ctx = prev
+	}
+}
+
+func (e *ExecAstVisitor) checkContext(node AstNode) error {
+	select {
+	case <-e.ctx.Done():
+		return interruptedError(node, e.ctx.Err())
+	default:
+		return nil
+	}
+}
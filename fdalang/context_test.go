@@ -0,0 +1,96 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExecAstContextCanceledBetweenStatements(t *testing.T) {
+	input := `a = 1
+cancel()
+b = 2
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := NewExecAstVisitor()
+	e.AddBuiltinFunctions(map[string]*ObjBuiltin{
+		"cancel": {
+			Name:       "cancel",
+			ArgTypes:   ArgTypes{},
+			ReturnType: TypeVoid,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				cancel()
+				return &ObjVoid{}, nil
+			},
+		},
+	})
+	env := NewEnvironment()
+	err = e.ExecAstContext(ctx, astProgram, env)
+	require.True(t, errors.Is(err, context.Canceled), err)
+
+	var interrupted *ExecInterruptedError
+	require.True(t, errors.As(err, &interrupted))
+	require.Equal(t, 3, interrupted.Line)
+
+	_, ok := env.Get("b")
+	require.False(t, ok, "statement after cancellation should not be executed")
+
+	require.Nil(t, e.ExecAst(astProgram, NewEnvironment()), "context should be reset after execution")
+}
+
+func TestExecAstContextDeadlineInBlockingBuiltin(t *testing.T) {
+	input := `wait = fn() int {
+   return waitServer()
+}
+a = wait()
+`
+	waitServer, err := WrapFunc("waitServer", func(ctx context.Context) (int64, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	require.Nil(t, err)
+	require.Nil(t, waitServer.Fn)
+
+	program, err := NewProgramWithOptions(input, ProgramOptions{
+		Builtins: map[string]*ObjBuiltin{"waitServer": waitServer},
+	})
+	require.Nil(t, err)
+	r := NewRuntime(program)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.RunContext(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	var interrupted *ExecInterruptedError
+	require.True(t, errors.As(err, &interrupted))
+	require.Equal(t, 2, interrupted.Line)
+}
+
+func TestExecAstContextDoneBeforeCall(t *testing.T) {
+	input := `f = fn(int x) int {
+   return x
+}
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	_, err = r.Run()
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.CallContext(ctx, "f", &ObjInteger{Value: 1})
+	require.True(t, errors.Is(err, context.Canceled), err)
+
+	result, err := r.Call("f", &ObjInteger{Value: 1})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), result.(*ObjInteger).Value)
+}
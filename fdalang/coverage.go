@@ -0,0 +1,382 @@
+package fdalang
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+type CoverKind string
+
+const (
+	CoverStatement CoverKind = "statement"
+	CoverBranch    CoverKind = "branch"
+)
+
+// Branches of if and switch statements
+const (
+	BranchThen    = "then"
+	BranchElse    = "else"
+	BranchCase    = "case"
+	BranchDefault = "default"
+)
+
+// CoverPoint is a statement or a branch of the program with the number of its executions.
+// Branches exist even if they are not written: `if` without `else` has the else branch taken
+// when the condition is false and `switch` without `default` has the default branch taken
+// when no case matched.
+type CoverPoint struct {
+	Kind CoverKind
+	// Branch is one of Branch* constants for branches, empty for statements
+	Branch string
+	// Token is the token of the statement, `if`, `case` or `switch` (for the default branch)
+	Token Token
+	// EndLine and EndCol are the position right after the statement or the branch
+	EndLine int
+	EndCol  int
+	Count   int64
+}
+
+type coverKey struct {
+	pos    int
+	branch string
+}
+
+// Coverage counts executions of statements and branches of one program. Points are registered
+// from the AST before execution, so never executed ones are known too. Bodies of `test` blocks
+// are not covered, they are not the code being tested.
+// Coverage accumulates data over all executions it's set to (with SetCoverage), it's not safe for concurrent use:
+// runtimes executing in parallel should have their own Coverage merged at the end with Merge.
+type Coverage struct {
+	FileName string
+	lines    []string
+	points   []*CoverPoint
+	index    map[coverKey]*CoverPoint
+}
+
+func NewCoverage(fileName, source string, program *AstStatementsBlock) *Coverage {
+	c := &Coverage{
+		FileName: fileName,
+		lines:    strings.Split(strings.TrimRight(source, "\n"), "\n"),
+		index:    make(map[coverKey]*CoverPoint),
+	}
+	c.registerBlock(program)
+	sort.SliceStable(c.points, func(i, j int) bool {
+		return c.points[i].Token.Pos < c.points[j].Token.Pos
+	})
+	return c
+}
+
+// SetCoverage enables coverage collection, nil disables it
+func (e *ExecAstVisitor) SetCoverage(c *Coverage) {
+	e.coverage = c
+}
+
+// Points returns statements and branches of the program ordered by position
+func (c *Coverage) Points() []*CoverPoint {
+	return c.points
+}
+
+func (c *Coverage) add(kind CoverKind, branch string, token Token, endLine, endCol int) {
+	p := &CoverPoint{Kind: kind, Branch: branch, Token: token, EndLine: endLine, EndCol: endCol}
+	c.points = append(c.points, p)
+	c.index[coverKey{pos: token.Pos, branch: branch}] = p
+}
+
+func (c *Coverage) registerBlock(block *AstStatementsBlock) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		c.registerStatement(stmt)
+	}
+}
+
+func (c *Coverage) registerStatement(stmt AstStatement) {
+	if _, ok := stmt.(*AstTest); ok {
+		return
+	}
+	endLine, endCol := c.statementEnd(stmt)
+	c.add(CoverStatement, "", stmt.GetToken(), endLine, endCol)
+
+	switch n := stmt.(type) {
+	case *AstIf:
+		c.add(CoverBranch, BranchThen, n.Token, n.PositiveBranch.End.Line, n.PositiveBranch.End.Col+1)
+		c.add(CoverBranch, BranchElse, n.Token, endLine, endCol)
+	case *AstSwitch:
+		for _, cs := range n.Cases {
+			c.add(CoverBranch, BranchCase, cs.Token, cs.PositiveBranch.End.Line, cs.PositiveBranch.End.Col)
+		}
+		c.add(CoverBranch, BranchDefault, n.Token, endLine, endCol)
+	}
+	// blocks of the statement and of functions declared in its expressions
+	for _, child := range Children(stmt) {
+		Inspect(child, func(node AstNode) bool {
+			if block, ok := node.(*AstStatementsBlock); ok {
+				c.registerBlock(block)
+				return false
+			}
+			return true
+		})
+	}
+}
+
+// statementEnd returns the position after the last '}' of blocks of the statement, or the end of its line
+func (c *Coverage) statementEnd(stmt AstStatement) (int, int) {
+	line := stmt.GetToken().Line
+	endLine, endCol := line, 0
+	if line-1 < len(c.lines) {
+		endCol = len([]rune(strings.TrimRight(c.lines[line-1], " "))) + 1
+	}
+	Inspect(stmt, func(node AstNode) bool {
+		block, ok := node.(*AstStatementsBlock)
+		if ok && (block.End.Line > endLine || block.End.Line == endLine && block.End.Col+1 > endCol) {
+			endLine, endCol = block.End.Line, block.End.Col+1
+		}
+		return true
+	})
+	return endLine, endCol
+}
+
+// hit counts the execution of the point, code which was not registered (e.g. typed in REPL) is skipped
+func (c *Coverage) hit(token Token, branch string) {
+	if p, ok := c.index[coverKey{pos: token.Pos, branch: branch}]; ok {
+		p.Count++
+	}
+}
+
+// Merge adds counts of other coverage of the same program, e.g. collected by another Runtime
+func (c *Coverage) Merge(other *Coverage) error {
+	if other.FileName != c.FileName || len(other.points) != len(c.points) {
+		return fmt.Errorf("coverage of '%s' can't be merged with coverage of '%s'", other.FileName, c.FileName)
+	}
+	for i, p := range other.points {
+		mine := c.points[i]
+		if mine.Token.Pos != p.Token.Pos || mine.Branch != p.Branch {
+			return fmt.Errorf("coverage of '%s' is collected for a different program", other.FileName)
+		}
+	}
+	for i, p := range other.points {
+		c.points[i].Count += p.Count
+	}
+	return nil
+}
+
+// CoverSummary is the number of points covered (executed at least once) of the total
+type CoverSummary struct {
+	Statements        int
+	CoveredStatements int
+	Branches          int
+	CoveredBranches   int
+}
+
+func (s CoverSummary) StatementsPercent() float64 {
+	return percent(s.CoveredStatements, s.Statements)
+}
+
+func (s CoverSummary) BranchesPercent() float64 {
+	return percent(s.CoveredBranches, s.Branches)
+}
+
+func (s CoverSummary) String() string {
+	return fmt.Sprintf("%.1f%% of statements, %.1f%% of branches", s.StatementsPercent(), s.BranchesPercent())
+}
+
+// percent of empty set is 100, there is nothing left uncovered
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(covered) * 100 / float64(total)
+}
+
+func (c *Coverage) Summary() CoverSummary {
+	var s CoverSummary
+	for _, p := range c.points {
+		if p.Kind == CoverStatement {
+			s.Statements++
+			if p.Count > 0 {
+				s.CoveredStatements++
+			}
+		} else {
+			s.Branches++
+			if p.Count > 0 {
+				s.CoveredBranches++
+			}
+		}
+	}
+	return s
+}
+
+// coverLine is the annotation of the source line
+type coverLine struct {
+	// count is the smallest count of statements starting at the line, -1 if there are no statements
+	count    int64
+	branches []string
+}
+
+func (c *Coverage) annotatedLines() []coverLine {
+	lines := make([]coverLine, len(c.lines))
+	for i := range lines {
+		lines[i].count = -1
+	}
+	for _, p := range c.points {
+		i := p.Token.Line - 1
+		if i < 0 || i >= len(lines) {
+			continue
+		}
+		if p.Kind == CoverBranch {
+			lines[i].branches = append(lines[i].branches, fmt.Sprintf("%s %d", p.Branch, p.Count))
+		} else if lines[i].count < 0 || p.Count < lines[i].count {
+			lines[i].count = p.Count
+		}
+	}
+	return lines
+}
+
+// WriteReport writes the source annotated with execution counts of statements and branches,
+// '.' marks lines without statements
+func (c *Coverage) WriteReport(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	s := c.Summary()
+	fmt.Fprintf(bw, "%s: %d of %d statements (%.1f%%), %d of %d branches (%.1f%%)\n\n",
+		c.FileName, s.CoveredStatements, s.Statements, s.StatementsPercent(),
+		s.CoveredBranches, s.Branches, s.BranchesPercent())
+	fmt.Fprintf(bw, "%8s %5s\n", "count", "line")
+	for i, l := range c.annotatedLines() {
+		if l.count < 0 {
+			fmt.Fprintf(bw, "%8s", ".")
+		} else {
+			fmt.Fprintf(bw, "%8d", l.count)
+		}
+		fmt.Fprintf(bw, " %5d  %s", i+1, c.lines[i])
+		if len(l.branches) > 0 {
+			fmt.Fprintf(bw, "  [%s]", strings.Join(l.branches, ", "))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+const coverHTMLHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>FDALang coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; line-height: 1.3; }
+.line { color: #999; }
+.covered { background: #c8f0c8; }
+.uncovered { background: #f8c8c8; }
+.branches { color: #666; font-style: italic; }
+</style>
+</head>
+<body>
+`
+
+// WriteCoverHTML writes the HTML page with sources of the programs, covered lines are green and uncovered ones are red.
+// Execution counts are shown in tooltips, counts of branches follow the lines.
+func WriteCoverHTML(w io.Writer, coverages ...*Coverage) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(coverHTMLHead)
+	for _, c := range coverages {
+		fmt.Fprintf(bw, "<h2>%s</h2>\n<p>%s</p>\n<pre>\n",
+			html.EscapeString(c.FileName), html.EscapeString(c.Summary().String()))
+		for i, l := range c.annotatedLines() {
+			fmt.Fprintf(bw, `<span class="line">%5d</span>  `, i+1)
+			text := html.EscapeString(c.lines[i])
+			switch {
+			case l.count < 0:
+				bw.WriteString(text)
+			case l.count == 0:
+				fmt.Fprintf(bw, `<span class="uncovered" title="0">%s</span>`, text)
+			default:
+				fmt.Fprintf(bw, `<span class="covered" title="%d">%s</span>`, l.count, text)
+			}
+			if len(l.branches) > 0 {
+				fmt.Fprintf(bw, `  <span class="branches">%s</span>`, html.EscapeString(strings.Join(l.branches, ", ")))
+			}
+			bw.WriteString("\n")
+		}
+		bw.WriteString("</pre>\n")
+	}
+	bw.WriteString("</body>\n</html>\n")
+	return bw.Flush()
+}
+
+// WriteCoverProfile writes coverage of statements in the format of `go test -coverprofile` with count mode:
+// `file:startLine.startCol,endLine.endCol numberOfStatements count`. Blocks don't overlap: the range of
+// a statement with nested statements (e.g. `if` or a function declaration) is split around them, the first
+// part has the statement and the rest have none, so tools summing statements count it once.
+// Branches are not written: the profile has no place for them, as implicit branches have no source of their own.
+// They are in WriteReport and WriteCoverHTML.
+func WriteCoverProfile(w io.Writer, coverages ...*Coverage) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("mode: count\n")
+	for _, c := range coverages {
+		var statements []*CoverPoint
+		for _, p := range c.points {
+			if p.Kind == CoverStatement {
+				statements = append(statements, p)
+			}
+		}
+		var blocks []coverBlock
+		for i, p := range statements {
+			blocks = append(blocks, profileBlocks(p, statements[i+1:])...)
+		}
+		sort.SliceStable(blocks, func(i, j int) bool {
+			return blocks[i].start.before(blocks[j].start)
+		})
+		for _, b := range blocks {
+			fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n",
+				c.FileName, b.start.line, b.start.col, b.end.line, b.end.col, b.statements, b.count)
+		}
+	}
+	return bw.Flush()
+}
+
+type coverPos struct {
+	line int
+	col  int
+}
+
+func (p coverPos) before(other coverPos) bool {
+	return p.line < other.line || p.line == other.line && p.col < other.col
+}
+
+type coverBlock struct {
+	start      coverPos
+	end        coverPos
+	statements int
+	count      int64
+}
+
+// profileBlocks returns parts of the range of the statement not covered by the nested statements,
+// next are the statements following it ordered by position, nested ones are the first of them
+func profileBlocks(stmt *CoverPoint, next []*CoverPoint) []coverBlock {
+	end := coverPos{stmt.EndLine, stmt.EndCol}
+	cursor := coverPos{stmt.Token.Line, stmt.Token.Col}
+	var blocks []coverBlock
+	for _, p := range next {
+		start := coverPos{p.Token.Line, p.Token.Col}
+		if !start.before(end) {
+			break
+		}
+		if cursor.before(start) {
+			blocks = append(blocks, coverBlock{start: cursor, end: start, count: stmt.Count})
+		}
+		if nestedEnd := (coverPos{p.EndLine, p.EndCol}); cursor.before(nestedEnd) {
+			cursor = nestedEnd
+		}
+	}
+	if cursor.before(end) {
+		blocks = append(blocks, coverBlock{start: cursor, end: end, count: stmt.Count})
+	}
+	// the statement is counted once, by its first part
+	blocks[0].statements = 1
+	return blocks
+}
@@ -0,0 +1,129 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const coveredSource = `enum kind {rock, metal}
+classify = fn(kind k) int {
+   switch k {
+   case == kind:rock
+      return 1
+   case == kind:metal
+      return 2
+   }
+   return 0
+}
+a = classify(kind:rock)
+if a > 5 {
+   a = 5
+}
+on tick() {
+   b = classify(kind:metal)
+}
+test "classify" {
+   assertEq(classify(kind:rock), 1)
+}
+`
+
+func coverSource(t *testing.T) *Coverage {
+	program, err := NewProgram(coveredSource)
+	require.Nil(t, err)
+	coverage := NewCoverage("bot.fda", coveredSource, program.Ast())
+	r := NewRuntime(program)
+	r.Executor().SetCoverage(coverage)
+	_, err = r.Run()
+	require.Nil(t, err)
+	return coverage
+}
+
+func TestCoverage(t *testing.T) {
+	coverage := coverSource(t)
+
+	counts := make(map[string]int64)
+	for _, p := range coverage.Points() {
+		key := strings.TrimSpace(coverage.lines[p.Token.Line-1])
+		if p.Kind == CoverBranch {
+			key = p.Branch + ": " + key
+		}
+		counts[key] = p.Count
+	}
+	assert.Equal(t, map[string]int64{
+		"enum kind {rock, metal}":     1,
+		"classify = fn(kind k) int {": 1,
+		"switch k {":                  1,
+		"case: case == kind:rock":     1,
+		"case: case == kind:metal":    0,
+		"default: switch k {":         0,
+		"return 1":                    1,
+		"return 2":                    0,
+		"return 0":                    0,
+		"a = classify(kind:rock)":     1,
+		"if a > 5 {":                  1,
+		"then: if a > 5 {":            0,
+		"else: if a > 5 {":            1,
+		"a = 5":                       0,
+		"on tick() {":                 1,
+		"b = classify(kind:metal)":    0,
+	}, counts)
+	// the test block and its body are not covered
+	assert.Len(t, coverage.Points(), 16)
+
+	summary := coverage.Summary()
+	assert.Equal(t, CoverSummary{Statements: 11, CoveredStatements: 7, Branches: 5, CoveredBranches: 2}, summary)
+	assert.Equal(t, "63.6% of statements, 40.0% of branches", summary.String())
+}
+
+func TestCoverageMerge(t *testing.T) {
+	coverage := coverSource(t)
+	other := coverSource(t)
+	require.Nil(t, coverage.Merge(other))
+	assert.Equal(t, int64(2), coverage.Points()[0].Count)
+
+	program, err := NewProgram("a = 1\n")
+	require.Nil(t, err)
+	require.NotNil(t, coverage.Merge(NewCoverage("bot.fda", "a = 1\n", program.Ast())))
+}
+
+func TestCoverageReports(t *testing.T) {
+	coverage := coverSource(t)
+
+	var report bytes.Buffer
+	require.Nil(t, coverage.WriteReport(&report))
+	assert.Contains(t, report.String(), "bot.fda: 7 of 11 statements (63.6%), 2 of 5 branches (40.0%)")
+	assert.Contains(t, report.String(), "       1    12  if a > 5 {  [then 0, else 1]\n       0    13     a = 5\n")
+	assert.Contains(t, report.String(), "       .     8     }\n")
+
+	var profile bytes.Buffer
+	require.Nil(t, WriteCoverProfile(&profile, coverage))
+	// nested statements are cut out of the ranges of if, switch, function and event handler statements
+	assert.Equal(t, `mode: count
+bot.fda:1.1,1.24 1 1
+bot.fda:2.1,3.4 1 1
+bot.fda:3.4,5.7 1 1
+bot.fda:5.7,5.15 1 1
+bot.fda:5.15,7.7 0 1
+bot.fda:7.7,7.15 1 0
+bot.fda:7.15,8.5 0 1
+bot.fda:8.5,9.4 0 1
+bot.fda:9.4,9.12 1 0
+bot.fda:9.12,10.2 0 1
+bot.fda:11.1,11.24 1 1
+bot.fda:12.1,13.4 1 1
+bot.fda:13.4,13.9 1 0
+bot.fda:13.9,14.2 0 1
+bot.fda:15.1,16.4 1 1
+bot.fda:16.4,16.28 1 0
+bot.fda:16.28,17.2 0 1
+`, profile.String())
+
+	var page bytes.Buffer
+	require.Nil(t, WriteCoverHTML(&page, coverage))
+	assert.Contains(t, page.String(), `<span class="uncovered" title="0">   a = 5</span>`)
+	assert.Contains(t, page.String(), `<span class="covered" title="1">a = classify(kind:rock)</span>`)
+}
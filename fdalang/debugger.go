@@ -0,0 +1,304 @@
+package fdalang
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrDebugTerminated is returned from the execution terminated by DebugTerminate action
+var ErrDebugTerminated = errors.New("execution terminated by debugger")
+
+// DebugAction tells the debugger how to continue after the stop
+type DebugAction int
+
+const (
+	// DebugContinue runs until the next breakpoint or pause
+	DebugContinue DebugAction = iota
+	// DebugStepIn stops before the next statement, including statements of called functions
+	DebugStepIn
+	// DebugStepOver stops before the next statement of the current or outer function
+	DebugStepOver
+	// DebugStepOut stops before the next statement of the outer function
+	DebugStepOut
+	// DebugTerminate stops the execution with ErrDebugTerminated
+	DebugTerminate
+)
+
+type StopReason string
+
+const (
+	StopReasonBreakpoint StopReason = "breakpoint"
+	StopReasonStep       StopReason = "step"
+	StopReasonPause      StopReason = "pause"
+)
+
+// DebugHook is called when the execution stops before a statement. Execution is blocked
+// until the hook returns, so the hook could wait for user commands.
+type DebugHook func(state *DebugState) DebugAction
+
+// Breakpoint stops the execution before statements on the line. Condition is optional
+// FDALang expression evaluated in the scope of the statement, it should return bool.
+type Breakpoint struct {
+	Line      int
+	Condition string
+	Hits      int
+	condition AstExpression
+}
+
+// Debugger stops the execution before statements on breakpoints, pause requests and steps.
+// Breakpoints could be changed and Pause could be called from any goroutine during the execution.
+// It is attached to the executor with SetDebugger:
+//
+//	debugger := fdalang.NewDebugger(func(state *fdalang.DebugState) fdalang.DebugAction {
+//		fmt.Println(state.Line, state.Stack[0].Scopes()[0])
+//		return fdalang.DebugStepOver
+//	})
+//	_, err := debugger.SetBreakpoint(10, "target.hp < 10")
+//	executor.SetDebugger(debugger)
+type Debugger struct {
+	hook DebugHook
+	// mu protects breakpoints
+	mu          sync.Mutex
+	breakpoints map[int]*Breakpoint
+	frames      []*DebugFrame
+	action      DebugAction
+	// actionDepth is the stack depth the last step action was given at
+	actionDepth int
+	// pauseRequested is set from other goroutines by Pause
+	pauseRequested int32
+}
+
+// DebugFrame is the function call in the call stack. Main is the top level code of the program,
+// event handlers are named as "on <event>"
+type DebugFrame struct {
+	Function string
+	// Line and Col are the position of the statement executed in the frame
+	Line int
+	Col  int
+	Env  *Environment
+}
+
+// DebugScope is the variables of one environment in the scopes chain
+type DebugScope struct {
+	Vars map[string]Object
+}
+
+// Names returns sorted names of the variables
+func (s DebugScope) Names() []string {
+	names := make([]string, 0, len(s.Vars))
+	for name := range s.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scopes returns all scopes visible from the frame, from the innermost to the top level one
+func (f *DebugFrame) Scopes() []DebugScope {
+	var scopes []DebugScope
+	for env := f.Env; env != nil; env = env.outer {
+		vars := make(map[string]Object, len(env.store))
+		for name, obj := range env.store {
+			vars[name] = obj
+		}
+		scopes = append(scopes, DebugScope{Vars: vars})
+	}
+	return scopes
+}
+
+// DebugState describes the stop, it is valid only until the hook returns
+type DebugState struct {
+	Reason StopReason
+	Line   int
+	Col    int
+	// Stack is the call stack from the innermost frame to main
+	Stack []*DebugFrame
+	// Breakpoint is set when stopped on the breakpoint
+	Breakpoint *Breakpoint
+	// ConditionError is set when the breakpoint condition failed, such breakpoint always stops
+	ConditionError error
+
+	executor *ExecAstVisitor
+}
+
+// Evaluate evaluates FDALang expression in the scope of the frame, 0 is the innermost frame.
+// Expression could call functions and change the state as usual code.
+func (s *DebugState) Evaluate(frame int, expression string) (Object, error) {
+	if frame < 0 || frame >= len(s.Stack) {
+		return nil, fmt.Errorf("frame %d doesn't exist", frame)
+	}
+	expr, err := parseDebugExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	return s.executor.debugEvaluate(expr, s.Stack[frame].Env)
+}
+
+func NewDebugger(hook DebugHook) *Debugger {
+	return &Debugger{
+		hook:        hook,
+		breakpoints: make(map[int]*Breakpoint),
+	}
+}
+
+// SetDebugger attaches the debugger to the executor, nil detaches it
+func (e *ExecAstVisitor) SetDebugger(d *Debugger) {
+	e.debugger = d
+}
+
+// SetBreakpoint sets breakpoint on the line replacing existing one. Empty condition means unconditional breakpoint
+func (d *Debugger) SetBreakpoint(line int, condition string) (*Breakpoint, error) {
+	bp := &Breakpoint{Line: line, Condition: condition}
+	if condition != "" {
+		expr, err := parseDebugExpression(condition)
+		if err != nil {
+			return nil, err
+		}
+		bp.condition = expr
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[line] = bp
+	return bp, nil
+}
+
+func (d *Debugger) ClearBreakpoint(line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.breakpoints, line)
+}
+
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = make(map[int]*Breakpoint)
+}
+
+// Breakpoints returns copies of the breakpoints sorted by line
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	breakpoints := make([]Breakpoint, 0, len(d.breakpoints))
+	for _, bp := range d.breakpoints {
+		breakpoints = append(breakpoints, *bp)
+	}
+	sort.Slice(breakpoints, func(i, j int) bool {
+		return breakpoints[i].Line < breakpoints[j].Line
+	})
+	return breakpoints
+}
+
+// Pause stops the execution before the next statement. Could be called from any goroutine,
+// e.g. before the execution to stop on the first statement
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pauseRequested, 1)
+}
+
+func parseDebugExpression(expression string) (AstExpression, error) {
+	return NewParser(NewLexer(expression + "\n")).ParseExpression()
+}
+
+func (d *Debugger) begin() {
+	d.frames = []*DebugFrame{{Function: MainFunctionName}}
+	if d.action != DebugContinue {
+		// step given at the end of the previous execution stops on the first statement of the next one
+		d.action = DebugStepIn
+	}
+}
+
+func (d *Debugger) push(function string) {
+	d.frames = append(d.frames, &DebugFrame{Function: function})
+}
+
+func (d *Debugger) pop() {
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+// beforeStatement stops the execution if needed and waits for the hook
+func (d *Debugger) beforeStatement(e *ExecAstVisitor, node AstStatement, env *Environment) error {
+	t := node.GetToken()
+	frame := d.frames[len(d.frames)-1]
+	frame.Line, frame.Col, frame.Env = t.Line, t.Col, env
+
+	state := &DebugState{Line: t.Line, Col: t.Col, executor: e}
+	depth := len(d.frames)
+	switch {
+	case atomic.CompareAndSwapInt32(&d.pauseRequested, 1, 0):
+		state.Reason = StopReasonPause
+	case d.action == DebugStepIn,
+		d.action == DebugStepOver && depth <= d.actionDepth,
+		d.action == DebugStepOut && depth < d.actionDepth:
+		state.Reason = StopReasonStep
+	}
+
+	d.mu.Lock()
+	bp, ok := d.breakpoints[t.Line]
+	d.mu.Unlock()
+	if ok && state.Reason == "" {
+		stop := true
+		if bp.condition != nil {
+			stop, state.ConditionError = d.checkCondition(e, bp, env)
+		}
+		if stop {
+			d.mu.Lock()
+			bp.Hits++
+			hit := *bp
+			d.mu.Unlock()
+			state.Reason = StopReasonBreakpoint
+			state.Breakpoint = &hit
+		}
+	}
+	if state.Reason == "" {
+		return nil
+	}
+
+	state.Stack = make([]*DebugFrame, depth)
+	for i := range d.frames {
+		state.Stack[depth-1-i] = d.frames[i]
+	}
+	d.action = d.hook(state)
+	d.actionDepth = depth
+	if d.action == DebugTerminate {
+		d.action = DebugContinue
+		return fmt.Errorf("%w\nline:%d, pos %d", ErrDebugTerminated, t.Line, t.Col)
+	}
+	return nil
+}
+
+func (d *Debugger) checkCondition(e *ExecAstVisitor, bp *Breakpoint, env *Environment) (bool, error) {
+	result, err := e.debugEvaluate(bp.condition, env)
+	if err != nil {
+		return true, err
+	}
+	b, ok := result.(*ObjBoolean)
+	if !ok {
+		return true, fmt.Errorf("condition of the breakpoint on line %d should be bool but '%s' given",
+			bp.Line, result.Type())
+	}
+	return b.Value, nil
+}
+
+// debugEvaluate evaluates expression with detached debugger to not stop inside. Evaluation is not a part
+// of the program execution: stats and allocations are counted separately and then dropped, profiler,
+// coverage and the host callback are detached and the operations limit isn't applied, so the same program
+// reports and behaves the same with and without breakpoints and watches
+func (e *ExecAstVisitor) debugEvaluate(expr AstExpression, env *Environment) (Object, error) {
+	d, profiler, coverage, callback := e.debugger, e.profiler, e.coverage, e.execCallback
+	stats, allocStats, operationsLimit := e.statsCounters, e.allocStats, e.limits.Operations
+	e.debugger, e.profiler, e.coverage = nil, nil, nil
+	e.execCallback = func(operation Operation) {}
+	e.limits.Operations = 0
+	e.statsCounters = execStatsCounters{
+		builtins:      make(map[string]int),
+		functionCalls: make(map[string]int),
+	}
+	e.allocStats = AllocStats{}
+	defer func() {
+		e.debugger, e.profiler, e.coverage, e.execCallback = d, profiler, coverage, callback
+		e.statsCounters, e.allocStats, e.limits.Operations = stats, allocStats, operationsLimit
+	}()
+	return e.execExpression(expr, env)
+}
@@ -0,0 +1,170 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const debuggedSource = `add = fn(int a, int b) int {
+   c = a + b
+   return c
+}
+x = 1
+y = add(x, 2)
+z = add(y, 3)
+w = z
+`
+
+type debugStop struct {
+	reason StopReason
+	line   int
+	stack  string
+}
+
+func runDebugged(t *testing.T, setup func(d *Debugger), actions ...DebugAction) ([]debugStop, error) {
+	program, err := NewProgram(debuggedSource)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+
+	var stops []debugStop
+	d := NewDebugger(func(state *DebugState) DebugAction {
+		stack := ""
+		for _, f := range state.Stack {
+			stack += fmt.Sprintf("%s:%d ", f.Function, f.Line)
+		}
+		stops = append(stops, debugStop{reason: state.Reason, line: state.Line, stack: stack})
+		if len(actions) == 0 {
+			return DebugContinue
+		}
+		action := actions[0]
+		actions = actions[1:]
+		return action
+	})
+	setup(d)
+	r.Executor().SetDebugger(d)
+	_, err = r.Run()
+	return stops, err
+}
+
+func TestDebuggerSteps(t *testing.T) {
+	stops, err := runDebugged(t, func(d *Debugger) {
+		d.Pause()
+	}, DebugStepOver, DebugStepIn, DebugStepIn, DebugStepOut, DebugStepOver, DebugStepIn)
+	require.Nil(t, err)
+	require.Equal(t, []debugStop{
+		{reason: StopReasonPause, line: 1, stack: "main:1 "},
+		{reason: StopReasonStep, line: 5, stack: "main:5 "},
+		{reason: StopReasonStep, line: 6, stack: "main:6 "},
+		{reason: StopReasonStep, line: 2, stack: "add:2 main:6 "},
+		{reason: StopReasonStep, line: 7, stack: "main:7 "},
+		{reason: StopReasonStep, line: 8, stack: "main:8 "},
+	}, stops)
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	stops, err := runDebugged(t, func(d *Debugger) {
+		_, err := d.SetBreakpoint(2, "a > 2")
+		require.Nil(t, err)
+		_, err = d.SetBreakpoint(8, "")
+		require.Nil(t, err)
+	})
+	require.Nil(t, err)
+	require.Equal(t, []debugStop{
+		{reason: StopReasonBreakpoint, line: 2, stack: "add:2 main:7 "},
+		{reason: StopReasonBreakpoint, line: 8, stack: "main:8 "},
+	}, stops)
+}
+
+func TestDebuggerBreakpointConditionErrorStops(t *testing.T) {
+	program, err := NewProgram(debuggedSource)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+
+	var conditionErrors []error
+	d := NewDebugger(func(state *DebugState) DebugAction {
+		conditionErrors = append(conditionErrors, state.ConditionError)
+		return DebugContinue
+	})
+	_, err = d.SetBreakpoint(2, "a + 1")
+	require.Nil(t, err)
+	_, err = d.SetBreakpoint(3, "a >")
+	require.NotNil(t, err, "condition should be valid expression")
+	r.Executor().SetDebugger(d)
+	_, err = r.Run()
+	require.Nil(t, err)
+	require.Len(t, conditionErrors, 2)
+	require.NotNil(t, conditionErrors[0])
+}
+
+func TestDebuggerInspection(t *testing.T) {
+	program, err := NewProgram(debuggedSource)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+
+	inspected := false
+	d := NewDebugger(func(state *DebugState) DebugAction {
+		require.Len(t, state.Stack, 2)
+		scopes := state.Stack[0].Scopes()
+		require.Len(t, scopes, 2)
+		require.Equal(t, []string{"a", "b", "c"}, scopes[0].Names())
+		require.Equal(t, []string{"add", "x"}, scopes[1].Names())
+
+		c, err := state.Evaluate(0, "c * 10")
+		require.Nil(t, err)
+		require.Equal(t, int64(30), c.(*ObjInteger).Value)
+		x, err := state.Evaluate(1, "x")
+		require.Nil(t, err)
+		require.Equal(t, int64(1), x.(*ObjInteger).Value)
+		_, err = state.Evaluate(1, "c")
+		require.NotNil(t, err, "c is not visible from main")
+		_, err = state.Evaluate(2, "x")
+		require.NotNil(t, err)
+		inspected = true
+		return DebugTerminate
+	})
+	_, err = d.SetBreakpoint(3, "")
+	require.Nil(t, err)
+	r.Executor().SetDebugger(d)
+
+	_, err = r.Run()
+	require.True(t, errors.Is(err, ErrDebugTerminated), err)
+	require.True(t, inspected)
+	_, ok := r.Env().Get("y")
+	require.False(t, ok)
+}
+
+func TestDebuggerConditionIsNotCounted(t *testing.T) {
+	program, err := NewProgram(debuggedSource)
+	require.Nil(t, err)
+	run := func(condition string, operationsLimit int) (ExecStats, AllocStats) {
+		r := NewRuntime(program)
+		r.Executor().SetAllocLimit(1)
+		r.Executor().SetLimits(ExecLimits{Operations: operationsLimit})
+		callbacks := 0
+		r.Executor().SetExecCallback(func(operation Operation) {
+			callbacks++
+		})
+		if condition != "" {
+			d := NewDebugger(func(state *DebugState) DebugAction {
+				require.Nil(t, state.ConditionError)
+				return DebugContinue
+			})
+			_, err := d.SetBreakpoint(2, condition)
+			require.Nil(t, err)
+			r.Executor().SetDebugger(d)
+		}
+		stats, err := r.Run()
+		require.Nil(t, err)
+		require.Equal(t, stats.TotalOperations(), callbacks, "the host callback doesn't see the condition")
+		stats.Elapsed = 0
+		return stats, r.Executor().AllocStats()
+	}
+
+	expectedStats, expectedAlloc := run("", 0)
+	stats, alloc := run("add(a, length([]int{a, b, 3})) > 100", expectedStats.TotalOperations())
+	require.Equal(t, expectedStats, stats)
+	require.Equal(t, expectedAlloc, alloc)
+}
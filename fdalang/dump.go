@@ -0,0 +1,85 @@
+package fdalang
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DumpAst writes the tree of the node with all fields, one field per line, e.g. for debugging the parser:
+//
+//	*AstAssignment {
+//	   Token: ident "a" 1:1
+//	   Left: *AstIdentifier {
+//	...
+func DumpAst(w io.Writer, node AstNode) error {
+	d := &astDumper{}
+	d.value(reflect.ValueOf(node), 0)
+	_, err := io.WriteString(w, d.out.String()+"\n")
+	return err
+}
+
+type astDumper struct {
+	out strings.Builder
+}
+
+var tokenType = reflect.TypeOf(Token{})
+
+func (d *astDumper) value(v reflect.Value, depth int) {
+	indent := strings.Repeat("   ", depth)
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			d.out.WriteString("nil")
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			d.out.WriteString("*")
+		}
+		d.value(v.Elem(), depth)
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			t := v.Interface().(Token)
+			fmt.Fprintf(&d.out, "%s %q %d:%d", t.ID, t.Value, t.Line, t.Col)
+			return
+		}
+		d.out.WriteString(v.Type().Name() + " {\n")
+		for i := 0; i < v.NumField(); i++ {
+			d.out.WriteString(indent + "   " + v.Type().Field(i).Name + ": ")
+			d.value(v.Field(i), depth+1)
+			d.out.WriteString("\n")
+		}
+		d.out.WriteString(indent + "}")
+	case reflect.Slice:
+		if v.Len() == 0 {
+			d.out.WriteString("[]")
+			return
+		}
+		d.out.WriteString("[\n")
+		for i := 0; i < v.Len(); i++ {
+			d.out.WriteString(indent + "   ")
+			d.value(v.Index(i), depth+1)
+			d.out.WriteString("\n")
+		}
+		d.out.WriteString(indent + "]")
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		d.out.WriteString("{\n")
+		for _, k := range keys {
+			fmt.Fprintf(&d.out, "%s   %q: ", indent, k)
+			d.value(v.MapIndex(reflect.ValueOf(k)), depth+1)
+			d.out.WriteString("\n")
+		}
+		d.out.WriteString(indent + "}")
+	case reflect.String:
+		fmt.Fprintf(&d.out, "%q", v.String())
+	default:
+		fmt.Fprintf(&d.out, "%v", v.Interface())
+	}
+}
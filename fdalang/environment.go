@@ -2,6 +2,7 @@ package fdalang
 
 import (
 	"fmt"
+	"reflect"
 )
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -15,6 +16,8 @@ func NewEnvironment() *Environment {
 		store:             make(map[string]Object),
 		structDefinitions: make(map[string]*AstStructDefinition),
 		enumDefinitions:   make(map[string]*AstEnumDefinition),
+		goTypes:           make(map[reflect.Type]string),
+		eventHandlers:     make(map[string]*AstEventHandler),
 	}
 }
 
@@ -22,17 +25,42 @@ type Environment struct {
 	store             map[string]Object
 	structDefinitions map[string]*AstStructDefinition
 	enumDefinitions   map[string]*AstEnumDefinition
+	goTypes           map[reflect.Type]string
+	eventHandlers     map[string]*AstEventHandler
+	memory            *Memory
 	outer             *Environment
+	// goRegistrations are Go types in order of registration, see rollbackGoTypes
+	goRegistrations []goRegistration
+}
+
+// root returns the top level environment, definitions registered in it are visible everywhere
+func (e *Environment) root() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e
 }
 
 func (e *Environment) Store() map[string]Object {
 	return e.store
 }
 func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.getLocal(name)
+
+	if !ok {
+		if mem := e.Memory(); mem != nil {
+			obj, ok = mem.Get(name)
+		}
+	}
+
+	return obj, ok
+}
+
+func (e *Environment) getLocal(name string) (Object, bool) {
 	obj, ok := e.store[name]
 
 	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+		obj, ok = e.outer.getLocal(name)
 	}
 
 	return obj, ok
@@ -44,6 +72,9 @@ func (e *Environment) Set(name string, val Object) Object {
 }
 
 func (e *Environment) RegisterStructDefinition(s *AstStructDefinition) error {
+	if s.Name == TypeVec2 {
+		return fmt.Errorf("struct '%s' is a builtin type and can't be redefined", s.Name)
+	}
 	if _, exists := e.structDefinitions[s.Name]; exists {
 		return fmt.Errorf("struct '%s' already defined in this scope", s.Name)
 	}
@@ -61,6 +92,25 @@ func (e *Environment) RegisterEnumDefinition(ed *AstEnumDefinition) error {
 	return nil
 }
 
+func (e *Environment) RegisterEventHandler(h *AstEventHandler) error {
+	if _, exists := e.eventHandlers[h.Event]; exists {
+		return fmt.Errorf("handler for event '%s' already defined in this scope", h.Event)
+	}
+	e.eventHandlers[h.Event] = h
+
+	return nil
+}
+
+func (e *Environment) EventHandler(event string) (*AstEventHandler, bool) {
+	h, ok := e.eventHandlers[event]
+
+	if !ok && e.outer != nil {
+		h, ok = e.outer.EventHandler(event)
+	}
+
+	return h, ok
+}
+
 func (e *Environment) StructDefinition(name string) (*AstStructDefinition, bool) {
 	s, ok := e.structDefinitions[name]
 
@@ -0,0 +1,90 @@
+package fdalang
+
+import (
+	"sort"
+)
+
+// Event is something happened in the game the bot can react on with `on <name>(...) {...}` handler
+type Event struct {
+	Name string
+	Args []Object
+}
+
+// EventHandlers returns top level event handlers of the program in declaration order.
+// Could be used right after parsing to know what events the bot is interested in.
+func EventHandlers(program *AstStatementsBlock) []*AstEventHandler {
+	handlers := make([]*AstEventHandler, 0)
+	for _, stmt := range program.Statements {
+		if h, ok := stmt.(*AstEventHandler); ok {
+			handlers = append(handlers, h)
+		}
+	}
+	return handlers
+}
+
+// HandledEvents returns names of the events handled by the program in declaration order
+func HandledEvents(program *AstStatementsBlock) []string {
+	var events []string
+	for _, h := range EventHandlers(program) {
+		events = append(events, h.Event)
+	}
+	return events
+}
+
+// Dispatch runs handlers of the events. Program should be executed with ExecAst on the same env before,
+// it registers handlers and initializes the state shared between ticks.
+// Events are dispatched in the order their handlers are declared in the program, events with
+// the same name keep the order they are passed in. Events without handler are skipped.
+// Every handler runs in its own scope enclosed by env, so handler locals don't survive between ticks.
+func (e *ExecAstVisitor) Dispatch(env *Environment, events []Event) error {
+	type queued struct {
+		handler *AstEventHandler
+		event   Event
+	}
+	e.beginExecution()
+	defer e.endExecution()
+	queue := make([]queued, 0, len(events))
+	for _, ev := range events {
+		if h, ok := env.EventHandler(ev.Name); ok {
+			queue = append(queue, queued{handler: h, event: ev})
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].handler.Token.Pos < queue[j].handler.Token.Pos
+	})
+
+	for _, q := range queue {
+		if err := e.execEventHandler(q.handler, q.event.Args, env); err != nil {
+			return err
+		}
+	}
+	return checkMemoryLimit(env)
+}
+
+func (e *ExecAstVisitor) execEventHandler(node *AstEventHandler, args []Object, env *Environment) error {
+	e.operation(Operation{Type: OperationEventHandler})
+	callNode := &AstFunctionCall{
+		Token:    node.Token,
+		Function: &AstIdentifier{Token: node.Token, Value: node.Event},
+	}
+	if err := functionCallArgumentsCheck(callNode, node.Arguments, args); err != nil {
+		return err
+	}
+
+	handlerEnv := NewEnclosedEnvironment(env)
+	for i, arg := range node.Arguments {
+		handlerEnv.Set(arg.Var.Value, args[i])
+	}
+
+	if e.profiler != nil {
+		e.profiler.push("on "+node.Event, node.Token.Line)
+		defer e.profiler.pop()
+	}
+	if e.debugger != nil {
+		e.debugger.push("on " + node.Event)
+		defer e.debugger.pop()
+	}
+	// returned value is ignored, return is used only to stop the handler
+	_, err := e.execStatementsBlock(node.StatementsBlock, handlerEnv)
+	return err
+}
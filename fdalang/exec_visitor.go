@@ -1,8 +1,30 @@
 package fdalang
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
 type ExecAstVisitor struct {
+	ctx          context.Context
 	execCallback ExecCallback
 	builtins     map[string]*ObjBuiltin
+	// testBuiltins are available only in bodies of tests
+	testBuiltins map[string]*ObjBuiltin
+	vec2Methods  map[string]*ObjBuiltin
+	rand         *Rand
+	allocStats   AllocStats
+	limits       ExecLimits
+	// statsCounters are reset at the beginning of every execution
+	statsCounters execStatsCounters
+	profiler      *Profiler
+	coverage      *Coverage
+	debugger      *Debugger
+	output        io.Writer
+	// executionDepth is the number of entered executions, more than 1 when a builtin calls the executor back
+	executionDepth int
 }
 
 const (
@@ -27,6 +49,11 @@ const (
 	OperationFunctionCall
 	OperationEnumElementCall
 	OperationBuiltin
+	OperationEventHandler
+	OperationPersist
+	// operationTypesCount is the number of OperationType values, they are used as indexes of counters.
+	// It must be the last one
+	operationTypesCount
 )
 
 type OperationType int
@@ -40,10 +67,22 @@ type ExecCallback func(Operation)
 
 func NewExecAstVisitor() *ExecAstVisitor {
 	e := &ExecAstVisitor{
+		ctx:          context.Background(),
+		output:       os.Stdout,
 		execCallback: func(operation Operation) {},
 		builtins:     make(map[string]*ObjBuiltin),
+		testBuiltins: make(map[string]*ObjBuiltin),
+		vec2Methods:  make(map[string]*ObjBuiltin),
+		rand:         NewRand(0),
+		statsCounters: execStatsCounters{
+			builtins:      make(map[string]int),
+			functionCalls: make(map[string]int),
+		},
 	}
 	e.setupBasicBuiltinFunctions()
+	e.setupVec2BuiltinFunctions()
+	e.setupRandBuiltinFunctions()
+	e.setupAssertBuiltinFunctions()
 	return e
 }
 
@@ -52,11 +91,13 @@ func (e *ExecAstVisitor) SetExecCallback(callback ExecCallback) {
 }
 
 func (e *ExecAstVisitor) ExecAst(ast *AstStatementsBlock, env *Environment) error {
+	e.beginExecution()
+	defer e.endExecution()
 	_, err := e.execStatementsBlock(ast, env)
 	if err != nil {
 		return err
 	}
-	return nil
+	return checkMemoryLimit(env)
 }
 
 func (e *ExecAstVisitor) execStatementsBlock(node *AstStatementsBlock, env *Environment) (*ObjReturnValue, error) {
@@ -71,6 +112,23 @@ func (e *ExecAstVisitor) execStatementsBlock(node *AstStatementsBlock, env *Envi
 }
 
 func (e *ExecAstVisitor) execStatement(node AstStatement, env *Environment) (*ObjReturnValue, error) {
+	if err := e.checkContext(node); err != nil {
+		return nil, err
+	}
+	if err := e.checkOperationsLimit(node); err != nil {
+		return nil, err
+	}
+	if e.profiler != nil {
+		e.profiler.setLine(node.GetToken().Line)
+	}
+	if e.coverage != nil {
+		e.coverage.hit(node.GetToken(), "")
+	}
+	if e.debugger != nil {
+		if err := e.debugger.beforeStatement(e, node, env); err != nil {
+			return nil, err
+		}
+	}
 	switch astNode := node.(type) {
 	case *AstStatementWithVoidedExpression:
 		_, err := e.execExpression(astNode.Expr, env)
@@ -85,6 +143,13 @@ func (e *ExecAstVisitor) execStatement(node AstStatement, env *Environment) (*Ob
 		return nil, env.RegisterStructDefinition(astNode)
 	case *AstEnumDefinition:
 		return nil, env.RegisterEnumDefinition(astNode)
+	case *AstEventHandler:
+		return nil, env.RegisterEventHandler(astNode)
+	case *AstPersist:
+		return nil, e.execPersist(astNode, env)
+	case *AstTest:
+		// tests are executed only by the test runner
+		return nil, nil
 	default:
 		return nil, runtimeError(node, "Unexpected node for statement: %T", node)
 	}
@@ -134,7 +199,7 @@ func (e *ExecAstVisitor) execAssignment(node *AstAssignment, env *Environment) (
 	if _, exists := e.builtins[varName]; exists {
 		return nil, runtimeError(node.Left, "Builtins are immutable")
 	}
-	e.execCallback(Operation{Type: OperationAssignment})
+	e.operation(Operation{Type: OperationAssignment})
 	value, err := e.execExpression(node.Value, env)
 	if err != nil {
 		return nil, err
@@ -145,6 +210,13 @@ func (e *ExecAstVisitor) execAssignment(node *AstAssignment, env *Environment) (
 			oldVar.Type(), value.Type())
 	}
 
+	if env.isPersistent(varName) {
+		if err = env.Memory().Set(varName, value); err != nil {
+			return nil, runtimeError(node, "%s", err.Error())
+		}
+		return value, nil
+	}
+
 	env.Set(varName, value)
 	return value, nil
 }
@@ -153,7 +225,7 @@ func (e *ExecAstVisitor) execStructFieldAssignment(
 	node *AstStructFieldAssignment,
 	env *Environment,
 ) (Object, error) {
-	e.execCallback(Operation{Type: OperationStructFieldAssignment})
+	e.operation(Operation{Type: OperationStructFieldAssignment})
 	value, err := e.execExpression(node.Value, env)
 	if err != nil {
 		return nil, err
@@ -164,21 +236,35 @@ func (e *ExecAstVisitor) execStructFieldAssignment(
 		return nil, err
 	}
 
+	if left.Type() == TypeVec2 {
+		return nil, runtimeError(node, "vec2 is immutable, create a new one with vec2{x = ..., y = ...}")
+	}
+
 	structObj, ok := left.(*ObjStruct)
 	if !ok {
 		return nil, runtimeError(node, "Field access can be only on struct but '%s' given", left.Type())
 	}
 
-	if _, ok = structObj.Fields[node.Left.Field.Value]; !ok {
+	oldValue, ok := structObj.Fields[node.Left.Field.Value]
+	if !ok {
 		return nil, runtimeError(node,
 			"Struct '%s' doesn't have field '%s'", structObj.Definition.Name, node.Left.Field.Value)
 	}
 	structObj.Fields[node.Left.Field.Value] = value
+
+	// the struct is changed in place, so the persistent variable it belongs to is accounted again
+	if root, ok := env.persistentRoot(node.Left.StructExpr); ok {
+		rootObj, _ := env.Memory().Get(root)
+		if err = env.Memory().Set(root, rootObj); err != nil {
+			structObj.Fields[node.Left.Field.Value] = oldValue
+			return nil, runtimeError(node, "%s", err.Error())
+		}
+	}
 	return value, nil
 }
 
 func (e *ExecAstVisitor) execUnaryExpression(node *AstUnary, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationUnary})
+	e.operation(Operation{Type: OperationUnary})
 	right, err := e.execExpression(node.Right, env)
 	if err != nil {
 		return nil, err
@@ -198,6 +284,9 @@ func (e *ExecAstVisitor) execUnaryExpression(node *AstUnary, env *Environment) (
 		case TypeFloat:
 			value := right.(*ObjFloat).Value
 			return &ObjFloat{Value: -value}, nil
+		case TypeVec2:
+			value := right.(*ObjVec2).Value
+			return &ObjVec2{Value: value.Mul(-1)}, nil
 		default:
 			return nil, runtimeError(node, "unknown operator: -%s", right.Type())
 		}
@@ -207,20 +296,26 @@ func (e *ExecAstVisitor) execUnaryExpression(node *AstUnary, env *Environment) (
 }
 
 func (e *ExecAstVisitor) execEmptierExpression(node *AstEmptier, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationQuestion})
+	e.operation(Operation{Type: OperationQuestion})
 	if node.IsArray {
-		if node.Type == TypeInt || node.Type == TypeFloat {
-			return &ObjArray{Emptier: Emptier{Empty: true}, ElementsType: node.Type}, nil
-		} else if _, ok := env.StructDefinition(node.Type); ok {
-			return &ObjArray{Emptier: Emptier{Empty: true}, ElementsType: node.Type}, nil
-		} else {
+		_, isStruct := env.StructDefinition(node.Type)
+		if node.Type != TypeInt && node.Type != TypeFloat && node.Type != TypeVec2 && !isStruct {
 			return nil, runtimeError(node, "? is not supported on type: '%s[]'", node.Type)
 		}
+		if err := e.alloc(node, 1); err != nil {
+			return nil, err
+		}
+		return &ObjArray{Emptier: Emptier{Empty: true}, ElementsType: node.Type}, nil
 	} else if node.Type == TypeInt {
 		return &ObjInteger{Emptier: Emptier{Empty: true}}, nil
 	} else if node.Type == TypeFloat {
 		return &ObjFloat{Emptier: Emptier{Empty: true}}, nil
+	} else if node.Type == TypeVec2 {
+		return &ObjVec2{Emptier: Emptier{Empty: true}}, nil
 	} else if def, ok := env.StructDefinition(node.Type); ok {
+		if err := e.alloc(node, 1); err != nil {
+			return nil, err
+		}
 		return &ObjStruct{
 			Emptier:    Emptier{Empty: true},
 			Definition: def,
@@ -232,7 +327,7 @@ func (e *ExecAstVisitor) execEmptierExpression(node *AstEmptier, env *Environmen
 }
 
 func (e *ExecAstVisitor) execBinExpression(node *AstBinOperation, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationBinExpr})
+	e.operation(Operation{Type: OperationBinExpr})
 	left, err := e.execExpression(node.Left, env)
 	if err != nil {
 		return nil, err
@@ -242,17 +337,20 @@ func (e *ExecAstVisitor) execBinExpression(node *AstBinOperation, env *Environme
 		return nil, err
 	}
 
-	if left.Type() != right.Type() {
+	if left.Type() != right.Type() && !isVec2ScalarOperation(left, right, node.Operator) {
 		return nil, runtimeError(node, "forbidden operation on different types: %s and %s",
 			left.Type(), right.Type())
 	}
+	if r, ok := right.(*ObjInteger); ok && node.Operator == TokenSlash && r.Value == 0 {
+		return nil, runtimeError(node, "integer division by zero")
+	}
 
 	result, err := execScalarBinOperation(left, right, node.Operator)
 	return result, err
 }
 
 func (e *ExecAstVisitor) execIdentifier(node *AstIdentifier, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationIdentifier})
+	e.operation(Operation{Type: OperationIdentifier})
 	if builtin, ok := e.builtins[node.Value]; ok {
 		return builtin, nil
 	}
@@ -269,14 +367,15 @@ func (e *ExecAstVisitor) execIdentifier(node *AstIdentifier, env *Environment) (
 }
 
 func (e *ExecAstVisitor) execReturn(node *AstReturn, env *Environment) (*ObjReturnValue, error) {
-	e.execCallback(Operation{Type: OperationReturn})
+	e.operation(Operation{Type: OperationReturn})
 	value, err := e.execExpression(node.ReturnValue, env)
 	return &ObjReturnValue{Value: value}, err
 }
 
 func (e *ExecAstVisitor) execFunction(node *AstFunction, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationFunction})
+	e.operation(Operation{Type: OperationFunction})
 	return &ObjFunction{
+		Token:      node.Token,
 		Arguments:  node.Arguments,
 		Statements: node.StatementsBlock,
 		ReturnType: node.ReturnType,
@@ -285,7 +384,7 @@ func (e *ExecAstVisitor) execFunction(node *AstFunction, env *Environment) (Obje
 }
 
 func (e *ExecAstVisitor) execFunctionCall(node *AstFunctionCall, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationFunctionCall})
+	e.operation(Operation{Type: OperationFunctionCall})
 	functionObj, err := e.execExpression(node.Function, env)
 	if err != nil {
 		return nil, err
@@ -296,16 +395,75 @@ func (e *ExecAstVisitor) execFunctionCall(node *AstFunctionCall, env *Environmen
 		return nil, err
 	}
 
+	return e.callFunction(node, functionObj, args, env)
+}
+
+// Call runs script function defined in env by name, e.g. event handler `onHit = fn(int damage) void {...}`
+func (e *ExecAstVisitor) Call(env *Environment, name string, args ...Object) (Object, error) {
+	obj, ok := env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("function '%s' is not defined", name)
+	}
+	fn, ok := obj.(*ObjFunction)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a function but '%s'", name, obj.Type())
+	}
+
+	e.beginExecution()
+	defer e.endExecution()
+	e.operation(Operation{Type: OperationFunctionCall})
+	node := &AstFunctionCall{
+		Token:    fn.Token,
+		Function: &AstIdentifier{Token: fn.Token, Value: name},
+	}
+	result, err := e.callFunction(node, fn, args, env)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMemoryLimit(env); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Eval evaluates the expression in env, e.g. the expression typed in REPL
+func (e *ExecAstVisitor) Eval(env *Environment, expr AstExpression) (Object, error) {
+	e.beginExecution()
+	defer e.endExecution()
+	result, err := e.execExpression(expr, env)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMemoryLimit(env); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (e *ExecAstVisitor) callFunction(
+	node *AstFunctionCall,
+	functionObj Object,
+	args []Object,
+	env *Environment,
+) (Object, error) {
+	if err := e.checkContext(node); err != nil {
+		return nil, err
+	}
 	switch fn := functionObj.(type) {
 	case *ObjFunction:
-		err = functionCallArgumentsCheck(node, fn.Arguments, args)
+		err := functionCallArgumentsCheck(node, fn.Arguments, args)
 		if err != nil {
 			return nil, err
 		}
 
+		if err = e.checkCallDepthLimit(node); err != nil {
+			return nil, err
+		}
 		// todo: what is fn.Env?
 		functionEnv := transferArgsToNewEnv(fn, args)
+		e.enterFunction(node, fn)
 		statementsBlockResult, err := e.execStatementsBlock(fn.Statements, functionEnv)
+		e.leaveFunction()
 		if err != nil {
 			return nil, err
 		}
@@ -324,18 +482,35 @@ func (e *ExecAstVisitor) execFunctionCall(node *AstFunctionCall, env *Environmen
 		return result, nil
 
 	case *ObjBuiltin:
-		e.execCallback(Operation{Type: OperationBuiltin, FuncName: fn.Name})
+		e.operation(Operation{Type: OperationBuiltin, FuncName: fn.Name})
 		if err := e.checkArgs(fn, args); err != nil {
 			return nil, err
 		}
-		result, err := fn.Fn(env, args)
+		var result Object
+		var err error
+		if fn.CtxFn != nil {
+			result, err = fn.CtxFn(e.ctx, env, args)
+		} else {
+			result, err = fn.Fn(env, args)
+		}
 		if err != nil {
+			if ctxErr := e.ctx.Err(); ctxErr != nil {
+				return nil, interruptedError(node, ctxErr)
+			}
+			if assertErr, ok := err.(*AssertionError); ok {
+				// position of the called name is more clear than the parenthesis
+				tok := node.Function.GetToken()
+				assertErr.Line, assertErr.Col = tok.Line, tok.Col
+			}
 			return nil, err
 		}
 
 		if err = functionReturnTypeCheck(node, result, fn.ReturnType); err != nil {
 			return nil, err
 		}
+		if err = e.allocResult(node, result); err != nil {
+			return nil, err
+		}
 
 		return result, nil
 
@@ -343,6 +518,7 @@ func (e *ExecAstVisitor) execFunctionCall(node *AstFunctionCall, env *Environmen
 		return nil, runtimeError(node, "not a function: %s", fn.Type())
 	}
 }
+
 func (e *ExecAstVisitor) execExpressionList(expressions []AstExpression, env *Environment) ([]Object, error) {
 	var result []Object
 
@@ -358,7 +534,7 @@ func (e *ExecAstVisitor) execExpressionList(expressions []AstExpression, env *En
 }
 
 func (e *ExecAstVisitor) execIfStatement(node *AstIf, env *Environment) (*ObjReturnValue, error) {
-	e.execCallback(Operation{Type: OperationIfStmt})
+	e.operation(Operation{Type: OperationIfStmt})
 	condition, err := e.execExpression(node.Condition, env)
 	if err != nil {
 		return nil, err
@@ -367,6 +543,13 @@ func (e *ExecAstVisitor) execIfStatement(node *AstIf, env *Environment) (*ObjRet
 		return nil, runtimeError(node, "Condition should be boolean type but %s in fact", condition.Type())
 	}
 
+	if e.coverage != nil {
+		if condition == ReservedObjTrue {
+			e.coverage.hit(node.Token, BranchThen)
+		} else {
+			e.coverage.hit(node.Token, BranchElse)
+		}
+	}
 	if condition == ReservedObjTrue {
 		return e.execStatementsBlock(node.PositiveBranch, env)
 	} else if node.ElseBranch != nil {
@@ -377,7 +560,7 @@ func (e *ExecAstVisitor) execIfStatement(node *AstIf, env *Environment) (*ObjRet
 }
 
 func (e *ExecAstVisitor) execArray(node *AstArray, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationArray})
+	e.operation(Operation{Type: OperationArray})
 	elements, err := e.execExpressionList(node.Elements, env)
 	if err != nil {
 		return nil, err
@@ -385,6 +568,9 @@ func (e *ExecAstVisitor) execArray(node *AstArray, env *Environment) (Object, er
 	if err = arrayElementsTypeCheck(node, node.ElementsType, elements); err != nil {
 		return nil, err
 	}
+	if err = e.alloc(node, len(elements)+1); err != nil {
+		return nil, err
+	}
 
 	return &ObjArray{
 		ElementsType: node.ElementsType,
@@ -393,7 +579,7 @@ func (e *ExecAstVisitor) execArray(node *AstArray, env *Environment) (Object, er
 }
 
 func (e *ExecAstVisitor) execArrayIndexCall(node *AstArrayIndexCall, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationArrayIndex})
+	e.operation(Operation{Type: OperationArrayIndex})
 	left, err := e.execExpression(node.Left, env)
 	if err != nil {
 		return nil, err
@@ -423,7 +609,10 @@ func (e *ExecAstVisitor) execArrayIndexCall(node *AstArrayIndexCall, env *Enviro
 }
 
 func (e *ExecAstVisitor) execStruct(node *AstStruct, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationStruct})
+	e.operation(Operation{Type: OperationStruct})
+	if node.Ident.Value == TypeVec2 {
+		return e.execVec2(node, env)
+	}
 	definition, ok := env.StructDefinition(node.Ident.Value)
 	if !ok {
 		return nil, runtimeError(node, "Struct '%s' is not defined", node.Ident.Value)
@@ -448,6 +637,9 @@ func (e *ExecAstVisitor) execStruct(node *AstStruct, env *Environment) (Object,
 			len(definition.Fields),
 			len(fields))
 	}
+	if err := e.alloc(node, len(fields)+1); err != nil {
+		return nil, err
+	}
 	obj := &ObjStruct{
 		Definition: definition,
 		Fields:     fields,
@@ -457,12 +649,16 @@ func (e *ExecAstVisitor) execStruct(node *AstStruct, env *Environment) (Object,
 }
 
 func (e *ExecAstVisitor) execStructFieldCall(node *AstStructFieldCall, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationStructFieldCall})
+	e.operation(Operation{Type: OperationStructFieldCall})
 	left, err := e.execExpression(node.StructExpr, env)
 	if err != nil {
 		return nil, err
 	}
 
+	if vec, ok := left.(*ObjVec2); ok {
+		return e.execVec2FieldCall(node, vec)
+	}
+
 	structObj, ok := left.(*ObjStruct)
 	if !ok {
 		return nil, runtimeError(node, "Field access can be only on struct but '%s' given", left.Type())
@@ -478,7 +674,7 @@ func (e *ExecAstVisitor) execStructFieldCall(node *AstStructFieldCall, env *Envi
 }
 
 func (e *ExecAstVisitor) execEnumElementCall(node *AstEnumElementCall, env *Environment) (Object, error) {
-	e.execCallback(Operation{Type: OperationEnumElementCall})
+	e.operation(Operation{Type: OperationEnumElementCall})
 	left, err := e.execExpression(node.EnumExpr, env)
 	if err != nil {
 		return nil, err
@@ -506,7 +702,7 @@ func (e *ExecAstVisitor) execEnumElementCall(node *AstEnumElementCall, env *Envi
 }
 
 func (e *ExecAstVisitor) execSwitch(node *AstSwitch, env *Environment) (*ObjReturnValue, error) {
-	e.execCallback(Operation{Type: OperationSwitch})
+	e.operation(Operation{Type: OperationSwitch})
 	for _, c := range node.Cases {
 		condition, err := e.execExpression(c.Condition, env)
 		if err != nil {
@@ -518,9 +714,15 @@ func (e *ExecAstVisitor) execSwitch(node *AstSwitch, env *Environment) (*ObjRetu
 		}
 		conditionResult, _ := condition.(*ObjBoolean)
 		if conditionResult.Value {
+			if e.coverage != nil {
+				e.coverage.hit(c.Token, BranchCase)
+			}
 			return e.execStatementsBlock(c.PositiveBranch, env)
 		}
 	}
+	if e.coverage != nil {
+		e.coverage.hit(node.Token, BranchDefault)
+	}
 	if node.DefaultBranch != nil {
 		return e.execStatementsBlock(node.DefaultBranch, env)
 	}
@@ -528,16 +730,16 @@ func (e *ExecAstVisitor) execSwitch(node *AstSwitch, env *Environment) (*ObjRetu
 }
 
 func (e *ExecAstVisitor) execNumInt(node *AstNumInt) (Object, error) {
-	e.execCallback(Operation{Type: OperationNumInt})
+	e.operation(Operation{Type: OperationNumInt})
 	return &ObjInteger{Value: node.Value}, nil
 }
 
 func (e *ExecAstVisitor) execNumFloat(node *AstNumFloat) (Object, error) {
-	e.execCallback(Operation{Type: OperationNumFloat})
+	e.operation(Operation{Type: OperationNumFloat})
 	return &ObjFloat{Value: node.Value}, nil
 }
 
 func (e *ExecAstVisitor) execBoolean(node *AstBoolean) (Object, error) {
-	e.execCallback(Operation{Type: OperationBoolean})
+	e.operation(Operation{Type: OperationBoolean})
 	return nativeBooleanToBoolean(node.Value), nil
 }
@@ -0,0 +1,207 @@
+package fdalang
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Fixtures are host values described in JSON, they are used to run the program without the game,
+// e.g. in the debugger. Definitions are host struct and enum types, every var and event argument
+// has the type and the value:
+//
+//	{
+//	  "structs": {"mech": {"pos": "vec2", "hp": "int", "state": "MechState"}},
+//	  "enums": {"MechState": ["idle", "moving"]},
+//	  "vars": {
+//	    "mech": {"type": "mech", "value": {"pos": {"x": 1, "y": 2}, "hp": 100, "state": "idle"}},
+//	    "targets": {"type": "[]vec2", "value": [{"x": 5, "y": 5}]},
+//	    "enemy": {"type": "mech", "value": null}
+//	  },
+//	  "events": [{"name": "damaged", "args": [{"type": "int", "value": 10}]}]
+//	}
+//
+// null value means empty value of the type.
+type Fixtures struct {
+	SerializedDefinitions
+	Vars   map[string]FixtureValue `json:"vars,omitempty"`
+	Events []FixtureEvent          `json:"events,omitempty"`
+}
+
+type FixtureValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type FixtureEvent struct {
+	Name string         `json:"name"`
+	Args []FixtureValue `json:"args,omitempty"`
+}
+
+func LoadFixtures(r io.Reader) (*Fixtures, error) {
+	f := &Fixtures{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(f); err != nil {
+		return nil, fmt.Errorf("invalid fixtures: %s", err.Error())
+	}
+	return f, nil
+}
+
+// Apply registers definitions and sets vars in the environment. Program could not declare
+// structs and enums with the same names in the same scope.
+func (f *Fixtures) Apply(env *Environment) error {
+	d := newObjectDeserializer(&f.SerializedDefinitions)
+	for _, name := range sortedKeys(f.Structs) {
+		def, _ := d.structDefinition(name)
+		if err := env.RegisterStructDefinition(def); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(f.Enums) {
+		def, _ := d.enumDefinition(name)
+		if err := env.RegisterEnumDefinition(def); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(f.Vars) {
+		obj, err := f.Vars[name].Object(env, name)
+		if err != nil {
+			return err
+		}
+		env.Set(name, obj)
+	}
+	return nil
+}
+
+// EventList converts events to be dispatched in the environment the fixtures are applied to
+func (f *Fixtures) EventList(env *Environment) ([]Event, error) {
+	events := make([]Event, len(f.Events))
+	for i, fe := range f.Events {
+		events[i].Name = fe.Name
+		for j, arg := range fe.Args {
+			obj, err := arg.Object(env, fmt.Sprintf("events[%d].args[%d]", i, j))
+			if err != nil {
+				return nil, err
+			}
+			events[i].Args = append(events[i].Args, obj)
+		}
+	}
+	return events, nil
+}
+
+// Object converts the value to object, structs and enums are looked up in the environment
+func (v FixtureValue) Object(env *Environment, path string) (Object, error) {
+	return fixtureObject(env, v.Type, v.Value, path)
+}
+
+func fixtureObject(env *Environment, langType string, raw json.RawMessage, path string) (Object, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return env.emptyObject(langType, path)
+	}
+	unmarshal := func(v interface{}) error {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("%s: invalid value of type '%s': %s", path, langType, err.Error())
+		}
+		return nil
+	}
+
+	switch {
+	case langType == TypeInt:
+		var value int64
+		if err := unmarshal(&value); err != nil {
+			return nil, err
+		}
+		return &ObjInteger{Value: value}, nil
+	case langType == TypeFloat:
+		var value float64
+		if err := unmarshal(&value); err != nil {
+			return nil, err
+		}
+		return &ObjFloat{Value: value}, nil
+	case langType == TypeBool:
+		var value bool
+		if err := unmarshal(&value); err != nil {
+			return nil, err
+		}
+		return nativeBooleanToBoolean(value), nil
+	case langType == TypeVec2:
+		var value struct {
+			X float64 `json:"x"`
+			Y float64 `json:"y"`
+		}
+		if err := unmarshal(&value); err != nil {
+			return nil, err
+		}
+		return &ObjVec2{Value: Vec2{X: value.X, Y: value.Y}}, nil
+	case strings.HasPrefix(langType, "[]"):
+		var items []json.RawMessage
+		if err := unmarshal(&items); err != nil {
+			return nil, err
+		}
+		elementsType := strings.TrimPrefix(langType, "[]")
+		elements := make([]Object, len(items))
+		for i, item := range items {
+			el, err := fixtureObject(env, elementsType, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &ObjArray{ElementsType: elementsType, Elements: elements}, nil
+	}
+
+	if def, ok := env.EnumDefinition(langType); ok {
+		var element string
+		if err := unmarshal(&element); err != nil {
+			return nil, err
+		}
+		for i, el := range def.Elements {
+			if el == element {
+				return &ObjEnum{Definition: def, Value: int8(i)}, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: enum '%s' doesn't have element '%s'", path, def.Name, element)
+	}
+
+	if def, ok := env.StructDefinition(langType); ok {
+		var values map[string]json.RawMessage
+		if err := unmarshal(&values); err != nil {
+			return nil, err
+		}
+		for name := range values {
+			if _, ok := def.Fields[name]; !ok {
+				return nil, fmt.Errorf("%s: struct '%s' doesn't have the field '%s'", path, def.Name, name)
+			}
+		}
+		fields := make(map[string]Object, len(def.Fields))
+		for name, field := range def.Fields {
+			value, ok := values[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: field '%s' of struct '%s' is missing", path, name, def.Name)
+			}
+			obj, err := fixtureObject(env, field.VarType, value, path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = obj
+		}
+		return &ObjStruct{Definition: def, Fields: fields}, nil
+	}
+
+	return nil, fmt.Errorf("%s: unknown type '%s'", path, langType)
+}
+
+// sortedKeys returns sorted keys of the map with string keys
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
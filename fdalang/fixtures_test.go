@@ -0,0 +1,56 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"strings"
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures(strings.NewReader(`{
+  "structs": {"mech": {"pos": "vec2", "hp": "int", "state": "MechState", "hits": "[]float"}},
+  "enums": {"MechState": ["idle", "moving"]},
+  "vars": {
+    "mech": {"type": "mech", "value": {"pos": {"x": 1, "y": 2}, "hp": 100, "state": "moving", "hits": [1.5]}},
+    "enemy": {"type": "mech", "value": null},
+    "ready": {"type": "bool", "value": true}
+  },
+  "events": [{"name": "damaged", "args": [{"type": "int", "value": 10}]}]
+}`))
+	require.Nil(t, err)
+
+	env := NewEnvironment()
+	require.Nil(t, fixtures.Apply(env))
+	mech, ok := env.Get("mech")
+	require.True(t, ok)
+	require.Equal(t, "mech{hits: []float{1.50}, hp: 100, pos: vec2{x: 1.00, y: 2.00}, state: moving}", mech.Inspect())
+	enemy, _ := env.Get("enemy")
+	require.True(t, isEmptyObject(enemy))
+	ready, _ := env.Get("ready")
+	require.Equal(t, ReservedObjTrue, ready)
+
+	events, err := fixtures.EventList(env)
+	require.Nil(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "damaged", events[0].Name)
+	require.Equal(t, int64(10), events[0].Args[0].(*ObjInteger).Value)
+}
+
+func TestFixturesNegative(t *testing.T) {
+	tests := map[string]string{
+		"unknown type":    `{"vars": {"a": {"type": "point", "value": {}}}}`,
+		"wrong value":     `{"vars": {"a": {"type": "int", "value": 1.5}}}`,
+		"unknown field":   `{"structs": {"p": {"x": "int"}}, "vars": {"a": {"type": "p", "value": {"x": 1, "y": 2}}}}`,
+		"missing field":   `{"structs": {"p": {"x": "int"}}, "vars": {"a": {"type": "p", "value": {}}}}`,
+		"unknown element": `{"enums": {"e": ["a"]}, "vars": {"a": {"type": "e", "value": "b"}}}`,
+	}
+	for name, input := range tests {
+		fixtures, err := LoadFixtures(strings.NewReader(input))
+		require.Nil(t, err, name)
+		require.NotNil(t, fixtures.Apply(NewEnvironment()), name)
+	}
+
+	_, err := LoadFixtures(strings.NewReader(`{"variables": {}}`))
+	require.NotNil(t, err, "unknown fields are not allowed")
+}
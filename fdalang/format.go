@@ -0,0 +1,377 @@
+package fdalang
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const formatIndent = "   "
+
+// Format returns the source in the canonical form: one indent is 3 spaces, binary operators are surrounded
+// by spaces, struct fields are aligned, blank lines are squashed into one. Comments are kept.
+func Format(input string) (string, error) {
+	if !strings.HasSuffix(input, "\n") {
+		input += "\n"
+	}
+	l := NewLexer(input)
+	program, err := NewParser(l).Parse()
+	if err != nil {
+		return "", err
+	}
+	return FormatAst(program, l.Trivia()), nil
+}
+
+// FormatAst prints the program as the source, trivia could be nil if the program has no source,
+// e.g. it's generated
+func FormatAst(program *AstStatementsBlock, trivia *Trivia) string {
+	p := &printer{blockStart: true, atLineStart: true}
+	if trivia != nil {
+		p.comments = trivia.Comments
+		p.blankLines = trivia.BlankLines
+	}
+	for _, stmt := range program.Statements {
+		p.statement(stmt)
+	}
+	p.commentsBefore(math.MaxInt32)
+	return p.out.String()
+}
+
+// nodeString prints the node as the source without comments, it's String() of every node.
+// The case doesn't know the expression of its switch, so it's printed in the condition, e.g. `x == 1` for `case == 1`.
+func nodeString(node AstNode) string {
+	p := &printer{blockStart: true, atLineStart: true}
+	switch n := node.(type) {
+	case *AstStatementsBlock:
+		for _, stmt := range n.Statements {
+			p.statement(stmt)
+		}
+	case *AstCase:
+		p.write("case ")
+		p.expression(n.Condition, precedenceLowest)
+		p.newline()
+		p.block(n.PositiveBranch)
+	case *AstVarAndType:
+		p.write(formatVarAndTypes([]*AstVarAndType{n}))
+	case AstStatement:
+		p.statement(n)
+	case AstExpression:
+		p.expression(n, precedenceLowest)
+	}
+	return strings.TrimSuffix(p.out.String(), "\n")
+}
+
+type printer struct {
+	out        strings.Builder
+	comments   []*Comment
+	blankLines map[int]bool
+	indent     int
+	// line is the last line of the source printed so far
+	line        int
+	atLineStart bool
+	// blockStart is set until the first statement of the block is printed
+	blockStart bool
+	// omitted is the expression of the switch which is not printed in the case conditions
+	omitted AstExpression
+}
+
+func (p *printer) write(s string) {
+	if p.atLineStart {
+		p.out.WriteString(strings.Repeat(formatIndent, p.indent))
+		p.atLineStart = false
+	}
+	p.out.WriteString(s)
+}
+
+func (p *printer) mark(line int) {
+	if line > p.line {
+		p.line = line
+	}
+}
+
+// newline ends the line with comments of the printed source lines
+func (p *printer) newline() {
+	trailing := true
+	for len(p.comments) > 0 && p.comments[0].Line <= p.line {
+		if trailing {
+			p.out.WriteString(" " + p.comments[0].Text)
+			trailing = false
+		} else {
+			p.out.WriteString("\n")
+			p.atLineStart = true
+			p.write(p.comments[0].Text)
+		}
+		p.comments = p.comments[1:]
+	}
+	p.out.WriteString("\n")
+	p.atLineStart = true
+}
+
+// commentsBefore prints comments preceding the line on their own lines
+func (p *printer) commentsBefore(line int) {
+	for len(p.comments) > 0 && p.comments[0].Line < line {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.blankLineBefore(c.Line)
+		p.write(c.Text)
+		p.mark(c.Line)
+		p.newline()
+	}
+}
+
+func (p *printer) blankLineBefore(line int) {
+	if !p.blockStart && p.blankLines[line-1] {
+		p.out.WriteString("\n")
+	}
+	p.blockStart = false
+}
+
+func (p *printer) block(block *AstStatementsBlock) {
+	p.indent++
+	p.blockStart = true
+	for _, stmt := range block.Statements {
+		p.statement(stmt)
+	}
+	if block.End.Line > 0 {
+		p.commentsBefore(block.End.Line)
+		p.mark(block.End.Line)
+	}
+	p.indent--
+	p.blockStart = false
+}
+
+func (p *printer) statement(stmt AstStatement) {
+	line := stmt.GetToken().Line
+	p.commentsBefore(line)
+	p.blankLineBefore(line)
+	p.mark(line)
+
+	switch node := stmt.(type) {
+	case *AstStatementWithVoidedExpression:
+		p.expression(node.Expr, precedenceLowest)
+	case *AstReturn:
+		p.write("return ")
+		p.expression(node.ReturnValue, precedenceLowest)
+	case *AstPersist:
+		p.write("persist ")
+		p.expression(node.Assignment, precedenceLowest)
+	case *AstIf:
+		p.write("if ")
+		p.expression(node.Condition, precedenceLowest)
+		p.write(" {")
+		p.newline()
+		p.block(node.PositiveBranch)
+		if node.ElseBranch != nil {
+			p.write("} else {")
+			p.newline()
+			p.block(node.ElseBranch)
+		}
+		p.write("}")
+	case *AstSwitch:
+		p.switchStatement(node)
+	case *AstStructDefinition:
+		p.structDefinition(node)
+	case *AstEnumDefinition:
+		p.write(fmt.Sprintf("enum %s {%s}", node.Name, strings.Join(node.Elements, ", ")))
+	case *AstEventHandler:
+		p.write("on " + node.Event)
+		if len(node.Arguments) > 0 {
+			p.write("(" + formatVarAndTypes(node.Arguments) + ")")
+		}
+		p.write(" {")
+		p.newline()
+		p.block(node.StatementsBlock)
+		p.write("}")
+	case *AstTest:
+		p.write(fmt.Sprintf("test \"%s\" {", node.Name))
+		p.newline()
+		p.block(node.StatementsBlock)
+		p.write("}")
+	default:
+		panic(fmt.Sprintf("unsupported statement %T", stmt))
+	}
+	p.newline()
+}
+
+func (p *printer) switchStatement(node *AstSwitch) {
+	p.write("switch ")
+	if node.SwitchExpression != nil {
+		p.expression(node.SwitchExpression, precedenceLowest)
+		p.write(" ")
+	}
+	p.write("{")
+	p.newline()
+	p.blockStart = true
+	omitted := p.omitted
+	p.omitted = node.SwitchExpression
+	for _, c := range node.Cases {
+		p.commentsBefore(c.Token.Line)
+		p.mark(c.Token.Line)
+		p.write("case ")
+		p.expression(c.Condition, precedenceLowest)
+		p.newline()
+		p.block(c.PositiveBranch)
+	}
+	p.omitted = omitted
+	if node.DefaultBranch != nil {
+		p.write("default")
+		p.newline()
+		p.block(node.DefaultBranch)
+	}
+	p.write("}")
+}
+
+func (p *printer) structDefinition(node *AstStructDefinition) {
+	fields := make([]*AstVarAndType, 0, len(node.Fields))
+	width := 0
+	for _, field := range node.Fields {
+		fields = append(fields, field)
+		if len(field.VarType) > width {
+			width = len(field.VarType)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Token.Pos < fields[j].Token.Pos })
+
+	p.write(fmt.Sprintf("struct %s {", node.Name))
+	p.newline()
+	p.indent++
+	for _, field := range fields {
+		p.commentsBefore(field.Token.Line)
+		p.mark(field.Token.Line)
+		p.write(fmt.Sprintf("%-*s %s", width, field.VarType, field.Var.Value))
+		p.newline()
+	}
+	p.indent--
+	p.write("}")
+}
+
+// expression prints the expression, it's wrapped in parens if it binds weaker than the context needs
+func (p *printer) expression(expr AstExpression, precedence int) {
+	p.mark(expr.GetToken().Line)
+	grouped := expressionPrecedence(expr) < precedence
+	if grouped {
+		p.write("(")
+	}
+
+	switch node := expr.(type) {
+	case *AstAssignment:
+		p.write(node.Left.Value + " = ")
+		p.expression(node.Value, precedenceLowest)
+	case *AstStructFieldAssignment:
+		p.expression(node.Left, precedenceLowest)
+		p.write(" = ")
+		p.expression(node.Value, precedenceLowest)
+	case *AstBinOperation:
+		if node.Left == p.omitted {
+			p.write(string(node.Operator) + " ")
+		} else {
+			p.expression(node.Left, precedences[node.Operator])
+			p.write(" " + string(node.Operator) + " ")
+		}
+		// operators are left associative, so the right operand of the same precedence needs parens
+		p.expression(node.Right, precedences[node.Operator]+1)
+	case *AstUnary:
+		p.write(string(node.Operator))
+		p.expression(node.Right, precedencePrefix)
+	case *AstEmptier:
+		p.write("?")
+		if node.IsArray {
+			p.write("[]")
+		}
+		p.write(node.Type)
+	case *AstIdentifier:
+		p.write(node.Value)
+	case *AstNumInt:
+		p.write(literal(node.Token, strconv.FormatInt(node.Value, 10)))
+	case *AstNumFloat:
+		value := strconv.FormatFloat(node.Value, 'f', -1, 64)
+		if !strings.Contains(value, ".") {
+			value += "."
+		}
+		p.write(literal(node.Token, value))
+	case *AstBoolean:
+		p.write(strconv.FormatBool(node.Value))
+	case *AstArray:
+		p.write("[]" + node.ElementsType + "{")
+		p.expressions(node.Elements)
+		p.write("}")
+	case *AstArrayIndexCall:
+		p.expression(node.Left, precedenceIndex)
+		p.write("[")
+		p.expression(node.Index, precedenceLowest)
+		p.write("]")
+	case *AstFunction:
+		p.write("fn(" + formatVarAndTypes(node.Arguments) + ") " + node.ReturnType + " {")
+		p.newline()
+		p.block(node.StatementsBlock)
+		p.write("}")
+	case *AstFunctionCall:
+		p.expression(node.Function, precedenceIndex)
+		p.write("(")
+		p.expressions(node.Arguments)
+		p.write(")")
+	case *AstStruct:
+		p.write(node.Ident.Value + "{")
+		for i, field := range node.Fields {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(field, precedenceLowest)
+		}
+		p.write("}")
+	case *AstStructFieldCall:
+		p.expression(node.StructExpr, precedenceIndex)
+		p.write("." + node.Field.Value)
+	case *AstEnumElementCall:
+		p.expression(node.EnumExpr, precedenceIndex)
+		p.write(":" + node.Element.Value)
+	default:
+		panic(fmt.Sprintf("unsupported expression %T", expr))
+	}
+
+	if grouped {
+		p.write(")")
+	}
+}
+
+func (p *printer) expressions(expressions []AstExpression) {
+	for i, expr := range expressions {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(expr, precedenceLowest)
+	}
+}
+
+// expressionPrecedence returns how strong the expression binds its operands,
+// operands (identifiers, calls, literals, etc) bind stronger than any operator
+func expressionPrecedence(expr AstExpression) int {
+	switch node := expr.(type) {
+	case *AstAssignment, *AstStructFieldAssignment:
+		return precedenceAssignment
+	case *AstBinOperation:
+		return precedences[node.Operator]
+	case *AstUnary:
+		return precedencePrefix
+	default:
+		return precedenceIndex
+	}
+}
+
+// literal keeps the number as it's written in the source, e.g. '1.' is not turned into '1.0'
+func literal(token Token, value string) string {
+	if token.Value != "" {
+		return token.Value
+	}
+	return value
+}
+
+func formatVarAndTypes(vars []*AstVarAndType) string {
+	s := make([]string, 0, len(vars))
+	for _, v := range vars {
+		s = append(s, v.VarType+" "+v.Var.Value)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,202 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected string
+	}{
+		"spacing and indentation": {
+			input: `a=1+2*3
+  if a>1&&!(a==7) {
+b = fn(int x,[]float y) int {
+        return -x
+     }
+}`,
+			expected: `a = 1 + 2 * 3
+if a > 1 && !(a == 7) {
+   b = fn(int x, []float y) int {
+      return -x
+   }
+}
+`,
+		},
+		"parens are kept only where needed": {
+			input: `a = (1 + 2) * 3 - (4 - 5) - (6 * 7)
+b = (a)
+c = -(a + 1)
+d = (a < 1) == (b < 2)
+`,
+			expected: `a = (1 + 2) * 3 - (4 - 5) - 6 * 7
+b = a
+c = -(a + 1)
+d = a < 1 == b < 2
+`,
+		},
+		"struct fields are aligned": {
+			input: `struct target {
+   vec2 pos
+     []float hits
+  Kind kind
+}
+t = target{pos = vec2{x = 1., y = 2.},
+   hits = []float{1.5, 2.}, kind = Kind:spore}
+t.pos = vec2{x = 0., y = 0.}
+`,
+			expected: `struct target {
+   vec2    pos
+   []float hits
+   Kind    kind
+}
+t = target{pos = vec2{x = 1., y = 2.}, hits = []float{1.5, 2.}, kind = Kind:spore}
+t.pos = vec2{x = 0., y = 0.}
+`,
+		},
+		"switch, enum and handlers": {
+			input: `enum state {idle,
+   moving}
+switch s {
+case == state:idle
+ a = ?[]int
+default
+ a = []int{}
+}
+switch {
+  case s == state:moving
+   b = 1
+}
+on tick {
+persist n = 0
+}
+on hit(int damage, float force) {
+   print(damage)
+}
+`,
+			expected: `enum state {idle, moving}
+switch s {
+case == state:idle
+   a = ?[]int
+default
+   a = []int{}
+}
+switch {
+case s == state:moving
+   b = 1
+}
+on tick {
+   persist n = 0
+}
+on hit(int damage, float force) {
+   print(damage)
+}
+`,
+		},
+		"comments and blank lines": {
+			input: `// header
+
+
+a = 1 // one
+if a > 0 { // positive
+   // inside
+
+   b = 2
+
+
+
+   // the last in the block
+} else { // else
+   b = 3 // three
+}
+switch a {
+
+// the first case
+case == 1 // one
+   c = 1
+   // still in the case
+case == 2
+   c = 2
+}
+d = []int{1, // first
+   2} // second
+// the end`,
+			expected: `// header
+
+a = 1 // one
+if a > 0 { // positive
+   // inside
+
+   b = 2
+
+   // the last in the block
+} else { // else
+   b = 3 // three
+}
+switch a {
+// the first case
+case == 1 // one
+   c = 1
+   // still in the case
+case == 2
+   c = 2
+}
+d = []int{1, 2} // first
+// second
+// the end
+`,
+		},
+		"tests": {
+			input: `test   "sum"{
+assertEq(1+1,2) // two
+}`,
+			expected: `test "sum" {
+   assertEq(1 + 1, 2) // two
+}
+`,
+		},
+	}
+
+	for name, test := range tests {
+		formatted, err := Format(test.input)
+		require.Nil(t, err, name)
+		require.Equal(t, test.expected, formatted, name)
+
+		again, err := Format(formatted)
+		require.Nil(t, err, name)
+		require.Equal(t, formatted, again, "%s: formatting should be idempotent", name)
+	}
+}
+
+func TestFormatKeepsProgram(t *testing.T) {
+	input := `f = fn(int a, int b) int {
+  return (a - b) * (a + -b) - (a - (b - 1))
+}
+r = f(7, 2)
+s = !(r > 1) || r == 2 && true
+`
+	formatted, err := Format(input)
+	require.Nil(t, err)
+
+	exec := func(source string) *Environment {
+		program, err := NewParser(NewLexer(source)).Parse()
+		require.Nil(t, err)
+		env := NewEnvironment()
+		require.Nil(t, NewExecAstVisitor().ExecAst(program, env))
+		return env
+	}
+	expected, actual := exec(input), exec(formatted)
+	for _, name := range []string{"r", "s"} {
+		expectedObj, _ := expected.Get(name)
+		actualObj, _ := actual.Get(name)
+		require.Equal(t, expectedObj.Inspect(), actualObj.Inspect(), name)
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	_, err := Format("a = \n")
+	require.NotNil(t, err)
+}
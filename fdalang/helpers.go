@@ -48,6 +48,7 @@ func (e *Environment) Keys() []string {
 	return keys
 }
 
+// Deprecated: use Bind, it derives definition from Go type and returns errors instead of exiting
 func (e *Environment) LoadVarsInStruct(definition *AstStructDefinition, s map[string]interface{}) *ObjStruct {
 	fields := make(map[string]Object)
 	for k, v := range s {
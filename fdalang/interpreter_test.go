@@ -602,6 +602,17 @@ b = a[1]
 	require.NotNil(t, err)
 }
 
+func TestIntegerDivisionByZeroNegative(t *testing.T) {
+	input := `a = 0
+b = 1 / a
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	err = NewExecAstVisitor().ExecAst(astProgram, NewEnvironment())
+	require.NotNil(t, err)
+	require.Equal(t, "integer division by zero\nline:2, pos 7", err.Error())
+}
+
 func TestExecSwitch(t *testing.T) {
 	input := `a = 10
 switch {
@@ -693,6 +704,188 @@ func TestExecAssignmentToBuiltinShouldFail(t *testing.T) {
 	require.NotNil(t, err)
 }
 
+func TestVec2(t *testing.T) {
+	input := `struct obj {
+   vec2 pos
+}
+a = vec2{x = 3., y = 0.}
+o = obj{pos = vec2{x = 0., y = 4.}}
+sum = a + o.pos
+scaled = a * 2.
+dist = a.distance(o.pos)
+dist2 = o.pos.distance(a)
+ax = a.x
+dotRes = a.dot(o.pos)
+e = ?vec2
+isEmpty = empty(e)
+distance = 1
+len = a.len()
+`
+	env := testExecAngGetEnv(t, input)
+
+	sum, ok := env.Get("sum")
+	require.True(t, ok)
+	require.IsType(t, &ObjVec2{}, sum)
+	require.Equal(t, Vec2{X: 3, Y: 4}, sum.(*ObjVec2).Value)
+
+	scaled, _ := env.Get("scaled")
+	require.Equal(t, Vec2{X: 6, Y: 0}, scaled.(*ObjVec2).Value)
+
+	for _, name := range []string{"dist", "dist2"} {
+		dist, ok := env.Get(name)
+		require.True(t, ok)
+		require.IsType(t, &ObjFloat{}, dist)
+		require.Equal(t, 5., dist.(*ObjFloat).Value)
+	}
+
+	ax, _ := env.Get("ax")
+	require.Equal(t, 3., ax.(*ObjFloat).Value)
+
+	dotRes, _ := env.Get("dotRes")
+	require.Equal(t, 0., dotRes.(*ObjFloat).Value)
+
+	isEmpty, _ := env.Get("isEmpty")
+	require.Equal(t, true, isEmpty.(*ObjBoolean).Value)
+
+	// names of vec2 methods are free for variables
+	length, _ := env.Get("len")
+	require.Equal(t, 3., length.(*ObjFloat).Value)
+}
+
+func TestVec2FieldAssignmentNegative(t *testing.T) {
+	input := `a = vec2{x = 3., y = 0.}
+a.x = 1.
+`
+	l := NewLexer(input)
+	p := NewParser(l)
+	astProgram, err := p.Parse()
+	require.Nil(t, err)
+	err = NewExecAstVisitor().ExecAst(astProgram, NewEnvironment())
+	require.NotNil(t, err, "vec2 should be immutable")
+}
+
+func TestRandIsDeterministicBySeed(t *testing.T) {
+	input := `a = randInt(1, 100)
+b = randFloat()
+c = randChance(0.5)
+`
+	l := NewLexer(input)
+	p := NewParser(l)
+	astProgram, err := p.Parse()
+	require.Nil(t, err)
+
+	run := func(seed int64) []string {
+		env := NewEnvironment()
+		e := NewExecAstVisitor()
+		e.SetRandSeed(seed)
+		require.Nil(t, e.ExecAst(astProgram, env))
+		var result []string
+		for _, name := range []string{"a", "b", "c"} {
+			v, ok := env.Get(name)
+			require.True(t, ok)
+			result = append(result, v.Inspect())
+		}
+		return result
+	}
+
+	require.Equal(t, run(42), run(42))
+
+	program, err := NewProgram(`chance = fn(float p) bool {
+   return randChance(p)
+}
+c = chance(1.)
+`)
+	require.Nil(t, err, "only names starting with rand are taken by the generator")
+	_, err = NewRuntime(program).Run()
+	require.Nil(t, err)
+
+	e := NewExecAstVisitor()
+	e.SetRandSeed(7)
+	state := e.RandState()
+	first, _ := e.rand.IntRange(0, 1000)
+	e.SetRandState(state)
+	second, _ := e.rand.IntRange(0, 1000)
+	require.Equal(t, first, second)
+}
+
+func TestCallFromGo(t *testing.T) {
+	input := `struct state {
+   int hp
+}
+s = state{hp = 100}
+onHit = fn(int damage) int {
+   s.hp = s.hp - damage
+   return s.hp
+}
+`
+	l := NewLexer(input)
+	p := NewParser(l)
+	env := NewEnvironment()
+	astProgram, err := p.Parse()
+	require.Nil(t, err)
+
+	e := NewExecAstVisitor()
+	require.Nil(t, e.ExecAst(astProgram, env))
+
+	result, err := e.Call(env, "onHit", &ObjInteger{Value: 30})
+	require.Nil(t, err)
+	require.IsType(t, &ObjInteger{}, result)
+	require.Equal(t, int64(70), result.(*ObjInteger).Value)
+
+	_, err = e.Call(env, "onHit", &ObjFloat{Value: 30})
+	require.NotNil(t, err, "argument type mismatch")
+
+	_, err = e.Call(env, "onHit")
+	require.NotNil(t, err, "arguments count mismatch")
+
+	_, err = e.Call(env, "s")
+	require.NotNil(t, err, "not a function")
+}
+
+func TestDispatchEvents(t *testing.T) {
+	input := `struct state {
+   int hits
+   int ticks
+   int order
+}
+s = state{hits = 0, ticks = 0, order = 0}
+on damaged(int amount) {
+   s.hits = s.hits + amount
+   s.order = s.order * 10 + 1
+}
+on tick {
+   s.ticks = s.ticks + 1
+   s.order = s.order * 10 + 2
+}
+`
+	l := NewLexer(input)
+	p := NewParser(l)
+	astProgram, err := p.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{"damaged", "tick"}, HandledEvents(astProgram))
+
+	env := NewEnvironment()
+	e := NewExecAstVisitor()
+	require.Nil(t, e.ExecAst(astProgram, env))
+
+	err = e.Dispatch(env, []Event{
+		{Name: "tick"},
+		{Name: "collision"},
+		{Name: "damaged", Args: []Object{&ObjInteger{Value: 5}}},
+		{Name: "damaged", Args: []Object{&ObjInteger{Value: 7}}},
+	})
+	require.Nil(t, err)
+
+	s, _ := env.Get("s")
+	fields := s.(*ObjStruct).Fields
+	require.Equal(t, int64(12), fields["hits"].(*ObjInteger).Value)
+	require.Equal(t, int64(1), fields["ticks"].(*ObjInteger).Value)
+	require.Equal(t, int64(112), fields["order"].(*ObjInteger).Value)
+
+	err = e.Dispatch(env, []Event{{Name: "damaged", Args: []Object{&ObjFloat{Value: 5}}}})
+	require.NotNil(t, err, "argument type mismatch")
+}
+
 func testExecAngGetEnv(t *testing.T, input string) *Environment {
 	l := NewLexer(input)
 	p := NewParser(l)
@@ -1,8 +1,8 @@
 package fdalang
 
 import (
-	"errors"
 	"fmt"
+	"strings"
 	"unicode"
 )
 
@@ -13,22 +13,49 @@ type Lexer struct {
 	nextChar rune
 	line     int
 	pos      int
+	trivia   Trivia
+}
+
+// Trivia is the part of the source the parser doesn't get, tools like the formatter use it to keep the source layout
+type Trivia struct {
+	Comments []*Comment
+	// BlankLines are lines containing only spaces
+	BlankLines map[int]bool
+}
+
+// Comment is the `//` comment, Text includes the slashes
+type Comment struct {
+	Text string
+	Line int
+	Col  int
+	Pos  int
+	// Trailing comment follows the code on the same line
+	Trailing bool
 }
 
 func NewLexer(input string) *Lexer {
 	l := &Lexer{input: []rune(input)}
+	l.trivia.BlankLines = make(map[int]bool)
 
 	l.fetch(1, 1)
 	return l
 }
 
 func (l *Lexer) fetch(line, pos int) {
-	l.currChar = l.input[l.inputPos]
-	l.nextChar = l.input[l.inputPos+1]
+	l.currChar = l.charAt(l.inputPos)
+	l.nextChar = l.charAt(l.inputPos + 1)
 	l.line = line
 	l.pos = pos
 }
 
+// charAt returns 0 after the end of input, so short inputs (e.g. empty file in the editor) are not a problem
+func (l *Lexer) charAt(pos int) rune {
+	if pos >= len(l.input) {
+		return rune(0)
+	}
+	return l.input[pos]
+}
+
 func ParseString(s string) ([]Token, bool, error) {
 	if len(s) == 0 {
 		return []Token{}, false, nil
@@ -113,6 +140,9 @@ func (l *Lexer) NextToken() (Token, error) {
 	case '\n':
 		currToken.Value = ""
 		currToken.ID = TokenEOL
+		if l.onlySpacesBefore(l.inputPos) {
+			l.trivia.BlankLines[l.line] = true
+		}
 	case '=':
 		if l.nextChar == '=' {
 			currToken.ID = TokenEq
@@ -159,6 +189,12 @@ func (l *Lexer) NextToken() (Token, error) {
 			currToken.ID = TokenSlash
 			currToken.Value = string(TokenSlash)
 		}
+	case '"':
+		currToken.ID = TokenString
+		currToken.Value, err = l.readString()
+		if err != nil {
+			currToken.ID = TokenInvalid
+		}
 	case 0:
 		currToken.Value = ""
 		currToken.ID = TokenEOC
@@ -185,8 +221,7 @@ func (l *Lexer) NextToken() (Token, error) {
 }
 
 func (l *Lexer) error(format string, args ...interface{}) error {
-	errorMsg := fmt.Sprintf(format, args...)
-	return errors.New(fmt.Sprintf("%s\nline:%d, pos %d", errorMsg, l.line, l.pos))
+	return &ParseError{Msg: fmt.Sprintf(format, args...), Line: l.line, Col: l.pos}
 }
 
 func (l *Lexer) GetCurrLineAndPos() (int, int) {
@@ -199,10 +234,34 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
+// Trivia returns comments and blank lines of the source read so far
+func (l *Lexer) Trivia() *Trivia {
+	return &l.trivia
+}
+
 func (l *Lexer) consumeComment() {
-	for l.currChar != '\n' {
+	comment := &Comment{Line: l.line, Col: l.pos, Pos: l.inputPos}
+	for l.currChar != '\n' && l.currChar != 0 {
 		l.read()
 	}
+	comments := l.trivia.Comments
+	// the parser could go back to the previous token, so the comment could be already read
+	if len(comments) > 0 && comments[len(comments)-1].Pos >= comment.Pos {
+		return
+	}
+	comment.Text = strings.TrimRight(string(l.input[comment.Pos:l.inputPos]), " ")
+	comment.Trailing = !l.onlySpacesBefore(comment.Pos)
+	l.trivia.Comments = append(comments, comment)
+}
+
+// onlySpacesBefore checks that the line has only spaces before the position
+func (l *Lexer) onlySpacesBefore(pos int) bool {
+	for i := pos - 1; i >= 0 && l.input[i] != '\n'; i-- {
+		if l.input[i] != ' ' {
+			return false
+		}
+	}
+	return true
 }
 
 func (l *Lexer) readNumber() (string, bool) {
@@ -229,6 +288,20 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// readString reads the string in double quotes, it can't span several lines
+func (l *Lexer) readString() (string, error) {
+	var result []rune
+	for l.nextChar != '"' {
+		if l.nextChar == '\n' || l.nextChar == 0 {
+			return string(result), l.error("Unterminated string")
+		}
+		result = append(result, l.nextChar)
+		l.read()
+	}
+	l.read()
+	return string(result), nil
+}
+
 func (l *Lexer) readWord() string {
 	result := string(l.currChar)
 	for unicode.IsLetter(l.nextChar) || isDigit(l.nextChar) {
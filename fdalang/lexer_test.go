@@ -154,6 +154,22 @@ func TestLogicalAndOr(t *testing.T) {
 	testLexerInput(input, tests, t)
 }
 
+func TestLexerTest(t *testing.T) {
+	input := `test "it works" {`
+
+	tests := []expectedTestToken{
+		{TokenTest, "test"},
+		{TokenString, "it works"},
+		{TokenLBrace, "{"},
+		{TokenEOC, ""},
+	}
+
+	testLexerInput(input, tests, t)
+
+	_, err := NewLexer("\"not closed\n").NextToken()
+	require.NotNil(t, err)
+}
+
 func TestLexerStruct(t *testing.T) {
 	input := `struct point {
    float x
@@ -285,3 +301,30 @@ func testLexerInput(input string, tests []expectedTestToken, t *testing.T) {
 		require.Equal(t, tt.expectedValue, tok.Value, "[%d] token value wrong", i)
 	}
 }
+
+func TestTrivia(t *testing.T) {
+	input := `// header
+
+a = 1 // one
+   
+b = 2
+// no newline at the end`
+
+	l := NewLexer(input)
+	for {
+		tok, err := l.NextToken()
+		require.Nil(t, err)
+		if tok.ID == TokenEOC {
+			break
+		}
+	}
+
+	trivia := l.Trivia()
+	require.Len(t, trivia.Comments, 3)
+	assert.Equal(t, Comment{Text: "// header", Line: 1, Col: 1, Pos: 0}, *trivia.Comments[0])
+	assert.Equal(t, "// one", trivia.Comments[1].Text)
+	assert.True(t, trivia.Comments[1].Trailing)
+	assert.Equal(t, 6, trivia.Comments[2].Line)
+	assert.False(t, trivia.Comments[2].Trailing)
+	assert.Equal(t, map[int]bool{2: true, 4: true}, trivia.BlankLines)
+}
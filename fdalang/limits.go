@@ -0,0 +1,36 @@
+package fdalang
+
+// ExecLimits bound one execution, i.e. one call of ExecAst, Dispatch or Call. Execution fails
+// with runtime error when a limit is exceeded. 0 means no limit
+type ExecLimits struct {
+	// Operations is the maximum number of executed operations, see ExecStats.TotalOperations.
+	// It's checked before every statement, so the statement in progress is finished.
+	Operations int
+	// CallDepth is the maximum depth of nested script function calls, e.g. it stops endless recursion
+	CallDepth int
+}
+
+func (e *ExecAstVisitor) SetLimits(limits ExecLimits) {
+	e.limits = limits
+}
+
+func (e *ExecAstVisitor) Limits() ExecLimits {
+	return e.limits
+}
+
+func (e *ExecAstVisitor) checkOperationsLimit(node AstNode) error {
+	limit := e.limits.Operations
+	if limit > 0 && e.statsCounters.totalOperations > limit {
+		return runtimeError(node, "operations limit exceeded: %d of %d operations executed",
+			e.statsCounters.totalOperations, limit)
+	}
+	return nil
+}
+
+func (e *ExecAstVisitor) checkCallDepthLimit(node AstNode) error {
+	limit := e.limits.CallDepth
+	if limit > 0 && e.statsCounters.callDepth >= limit {
+		return runtimeError(node, "call depth limit exceeded: %d nested calls", limit)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestOperationsLimit(t *testing.T) {
+	input := `a = 1
+b = a + 1
+c = b + 1
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	stats, err := r.Run()
+	require.Nil(t, err)
+	total := stats.TotalOperations()
+
+	r = NewRuntime(program)
+	r.Executor().SetLimits(ExecLimits{Operations: total})
+	_, err = r.Run()
+	require.Nil(t, err)
+
+	r = NewRuntime(program)
+	r.Executor().SetLimits(ExecLimits{Operations: 3})
+	_, err = r.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "operations limit exceeded")
+	require.Contains(t, err.Error(), "line:3")
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	input := `fact = fn(int n) int {
+   if n < 2 {
+      return 1
+   }
+   return n * fact(n - 1)
+}
+r = fact(5)
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	r.Executor().SetLimits(ExecLimits{CallDepth: 5})
+	_, err = r.Run()
+	require.Nil(t, err)
+	result, _ := r.Env().Get("r")
+	require.Equal(t, "120", result.Inspect())
+
+	r = NewRuntime(program)
+	r.Executor().SetLimits(ExecLimits{CallDepth: 4})
+	_, err = r.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "call depth limit exceeded: 4 nested calls")
+}
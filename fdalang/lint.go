@@ -0,0 +1,280 @@
+package fdalang
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LintIssue is the suspicious code found by the lint rule
+type LintIssue struct {
+	Rule string `json:"rule"`
+	Msg  string `json:"message"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+// LintRule finds one kind of issues, rules are independent, so any of them could be disabled
+type LintRule struct {
+	Name string
+	// Doc is one line description of what the rule finds
+	Doc string
+	Run func(pass *LintPass)
+}
+
+// LintPass is the program the rule runs on, the rule reports found issues with Report
+type LintPass struct {
+	Program *AstStatementsBlock
+	// Info is the result of Check of the program, types of expressions with type errors are unknown
+	Info *TypeInfo
+
+	rule   *LintRule
+	issues []*LintIssue
+}
+
+func (p *LintPass) Report(node AstNode, format string, args ...interface{}) {
+	t := node.GetToken()
+	p.issues = append(p.issues, &LintIssue{Rule: p.rule.Name, Msg: fmt.Sprintf(format, args...), Line: t.Line, Col: t.Col})
+}
+
+// LintRules returns all rules of the linter
+func LintRules() []*LintRule {
+	return []*LintRule{
+		{
+			Name: "unused-var",
+			Doc:  "variable is assigned but never read",
+			Run:  lintUnusedVars,
+		},
+		{
+			Name: "float-equality",
+			Doc:  "floats are compared with == or !=, results of computations are rarely exactly equal",
+			Run:  lintFloatEquality,
+		},
+		{
+			Name: "unreachable",
+			Doc:  "statement after return is never executed",
+			Run:  lintUnreachable,
+		},
+		{
+			Name: "enum-switch",
+			Doc:  "switch on enum without default misses elements of the enum",
+			Run:  lintEnumSwitch,
+		},
+		{
+			Name: "missing-return",
+			Doc:  "function of non-void type can reach the end of its body without return",
+			Run:  lintMissingReturn,
+		},
+	}
+}
+
+// SelectLintRules returns rules with the names, all rules if enabled is empty, without the disabled ones
+func SelectLintRules(enabled, disabled []string) ([]*LintRule, error) {
+	all := LintRules()
+	byName := make(map[string]*LintRule, len(all))
+	for _, rule := range all {
+		byName[rule.Name] = rule
+	}
+	for _, names := range [][]string{enabled, disabled} {
+		for _, name := range names {
+			if byName[name] == nil {
+				return nil, fmt.Errorf("unknown lint rule '%s'", name)
+			}
+		}
+	}
+
+	selected := all
+	if len(enabled) > 0 {
+		selected = make([]*LintRule, 0, len(enabled))
+		for _, rule := range all {
+			if containsString(enabled, rule.Name) {
+				selected = append(selected, rule)
+			}
+		}
+	}
+	rules := make([]*LintRule, 0, len(selected))
+	for _, rule := range selected {
+		if !containsString(disabled, rule.Name) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint runs the rules on the program checked with Check, issues are sorted by position
+func Lint(program *AstStatementsBlock, info *TypeInfo, rules []*LintRule) []*LintIssue {
+	issues := make([]*LintIssue, 0)
+	for _, rule := range rules {
+		pass := &LintPass{Program: program, Info: info, rule: rule}
+		rule.Run(pass)
+		issues = append(issues, pass.issues...)
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return issues
+}
+
+// lintUnusedVars reports variables declared by assignment which are never read, arguments are not reported
+// as event handlers and functions passed as callbacks have to declare them all
+func lintUnusedVars(pass *LintPass) {
+	assigned := make(map[*AstIdentifier]bool)
+	Inspect(pass.Program, func(node AstNode) bool {
+		if n, ok := node.(*AstAssignment); ok {
+			assigned[n.Left] = true
+		}
+		return true
+	})
+
+	read := make(map[*Symbol]bool)
+	declarations := make([]*AstIdentifier, 0)
+	for ident, sym := range pass.Info.Uses {
+		if !assigned[ident] {
+			read[sym] = true
+		} else if sym.Kind == SymbolVar && sym.Token == ident.Token {
+			declarations = append(declarations, ident)
+		}
+	}
+	sort.Slice(declarations, func(i, j int) bool { return declarations[i].Token.Pos < declarations[j].Token.Pos })
+	for _, ident := range declarations {
+		if !read[pass.Info.Uses[ident]] {
+			pass.Report(ident, "'%s' is assigned but never read", ident.Value)
+		}
+	}
+}
+
+func lintFloatEquality(pass *LintPass) {
+	Inspect(pass.Program, func(node AstNode) bool {
+		op, ok := node.(*AstBinOperation)
+		if !ok || op.Operator != TokenEq && op.Operator != TokenNotEq {
+			return true
+		}
+		if pass.Info.Types[op.Left] == TypeFloat || pass.Info.Types[op.Right] == TypeFloat {
+			pass.Report(op, "floats are compared with '%s', compare the difference with a tolerance instead", op.Operator)
+		}
+		return true
+	})
+}
+
+func lintUnreachable(pass *LintPass) {
+	Inspect(pass.Program, func(node AstNode) bool {
+		block, ok := node.(*AstStatementsBlock)
+		if !ok {
+			return true
+		}
+		for i, stmt := range block.Statements {
+			if statementReturns(stmt) && i+1 < len(block.Statements) {
+				pass.Report(block.Statements[i+1], "unreachable code: statement after return")
+				break
+			}
+		}
+		return true
+	})
+}
+
+// blockReturns tells if every path of the block ends with return
+func blockReturns(block *AstStatementsBlock) bool {
+	if block == nil {
+		return false
+	}
+	for _, stmt := range block.Statements {
+		if statementReturns(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// statementReturns tells if every path of the statement ends with return. Switch without default
+// doesn't return even if its cases are exhaustive, it's not known at runtime.
+func statementReturns(stmt AstStatement) bool {
+	switch n := stmt.(type) {
+	case *AstReturn:
+		return true
+	case *AstIf:
+		return blockReturns(n.PositiveBranch) && blockReturns(n.ElseBranch)
+	case *AstSwitch:
+		if !blockReturns(n.DefaultBranch) {
+			return false
+		}
+		for _, c := range n.Cases {
+			if !blockReturns(c.PositiveBranch) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// lintEnumSwitch reports switches without default branch comparing the enum value with its elements
+// if some elements are not compared with. Switches having other conditions are skipped.
+func lintEnumSwitch(pass *LintPass) {
+	definitions := make(map[Token]*AstEnumDefinition)
+	Inspect(pass.Program, func(node AstNode) bool {
+		if n, ok := node.(*AstEnumDefinition); ok {
+			definitions[n.Token] = n
+		}
+		return true
+	})
+
+	Inspect(pass.Program, func(node AstNode) bool {
+		n, ok := node.(*AstSwitch)
+		if !ok || n.SwitchExpression == nil || n.DefaultBranch != nil {
+			return true
+		}
+		enum := pass.Info.TypeSymbol(pass.Info.Types[n.SwitchExpression], n.Token.Line)
+		if enum == nil || enum.Kind != SymbolEnum {
+			return true
+		}
+		compared := make(map[string]bool)
+		for _, c := range n.Cases {
+			op, ok := c.Condition.(*AstBinOperation)
+			if !ok || op.Operator != TokenEq || op.Left != n.SwitchExpression {
+				return true
+			}
+			element, ok := op.Right.(*AstEnumElementCall)
+			if !ok {
+				return true
+			}
+			compared[element.Element.Value] = true
+		}
+
+		elements := sortedKeys(enum.Members)
+		if def, ok := definitions[enum.Token]; ok {
+			elements = def.Elements
+		}
+		var missed []string
+		for _, el := range elements {
+			if !compared[el] {
+				missed = append(missed, el)
+			}
+		}
+		if len(missed) > 0 {
+			pass.Report(n, "switch on enum '%s' misses %s, add cases or default", enum.Name, strings.Join(missed, ", "))
+		}
+		return true
+	})
+}
+
+// lintMissingReturn reports the same functions as Check does, so the lint output is complete without check errors
+func lintMissingReturn(pass *LintPass) {
+	Inspect(pass.Program, func(node AstNode) bool {
+		n, ok := node.(*AstFunction)
+		if ok && n.ReturnType != TypeVoid && !blockReturns(n.StatementsBlock) {
+			pass.Report(n, "function declared as '%s' can reach the end without return", n.ReturnType)
+		}
+		return true
+	})
+}
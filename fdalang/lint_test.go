@@ -0,0 +1,101 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+const lintedSource = `enum kind {rock, metal, gold}
+a = 1
+b = 2.
+if b == 1. {
+   a = 3
+}
+pick = fn(kind k, int unused) int {
+   switch k {
+   case == kind:rock
+      return 1
+   }
+   if k == kind:metal {
+      return 2
+      a = 5
+   }
+}
+pick(kind:rock, 1)
+switch pick(kind:gold, 2) {
+case == 1
+   b = 3.
+}
+`
+
+func lint(t *testing.T, source string, rules []*LintRule) []*LintIssue {
+	program, err := NewParser(NewLexer(source)).Parse()
+	require.Nil(t, err)
+	info := NewExecAstVisitor().Check(program, nil)
+	// Check reports missing returns too, the linted programs have no other errors
+	for _, e := range info.Errors {
+		require.Contains(t, e.Msg, "doesn't return a value on every path")
+	}
+	return Lint(program, info, rules)
+}
+
+func TestLint(t *testing.T) {
+	issues := lint(t, lintedSource, LintRules())
+	assert.Equal(t, []*LintIssue{
+		{Rule: "unused-var", Msg: "'a' is assigned but never read", Line: 2, Col: 1},
+		{Rule: "float-equality", Msg: "floats are compared with '==', compare the difference with a tolerance instead", Line: 4, Col: 6},
+		{Rule: "missing-return", Msg: "function declared as 'int' can reach the end without return", Line: 7, Col: 8},
+		{Rule: "enum-switch", Msg: "switch on enum 'kind' misses metal, gold, add cases or default", Line: 8, Col: 4},
+		{Rule: "unused-var", Msg: "'a' is assigned but never read", Line: 14, Col: 7},
+		{Rule: "unreachable", Msg: "unreachable code: statement after return", Line: 14, Col: 7},
+	}, issues)
+}
+
+func TestLintClean(t *testing.T) {
+	issues := lint(t, `enum kind {rock, metal}
+sign = fn(float x) int {
+   if x > 0. {
+      return 1
+   } else {
+      switch {
+      case x < 0.
+         return -1
+      default
+         return 0
+      }
+   }
+}
+name = fn(kind k) int {
+   switch k {
+   case == kind:rock
+      return 1
+   case == kind:metal
+      return 2
+   }
+   return 0
+}
+on tick(float dt) {
+   commands = sign(dt) + name(kind:rock)
+   commands = commands + 1
+}
+`, LintRules())
+	assert.Empty(t, issues)
+}
+
+func TestSelectLintRules(t *testing.T) {
+	rules, err := SelectLintRules(nil, []string{"unused-var"})
+	require.Nil(t, err)
+	assert.Len(t, rules, len(LintRules())-1)
+	assert.Len(t, lint(t, lintedSource, rules), 4)
+
+	rules, err = SelectLintRules([]string{"unreachable", "missing-return"}, []string{"missing-return"})
+	require.Nil(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "unreachable", rules[0].Name)
+
+	_, err = SelectLintRules([]string{"style"}, nil)
+	require.NotNil(t, err)
+	assert.Equal(t, "unknown lint rule 'style'", err.Error())
+}
@@ -0,0 +1,236 @@
+package fdalang
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const memorySerializationVersion = 1
+
+// Memory is the persistent scope of the bot. Variables declared with `persist name = value`
+// are stored here and survive between ExecAst calls, while ordinary variables live in
+// the Environment which host may recreate every tick:
+//
+//	mem := fdalang.NewMemory(1000)
+//	// every tick
+//	env := fdalang.NewEnvironment()
+//	env.SetMemory(mem)
+//	err := executor.ExecAst(program, env)
+type Memory struct {
+	store map[string]Object
+	// sizes are sizes of values when they were set, structs are changed in place by field assignment,
+	// so Set with the same changed value accounts the difference
+	sizes map[string]int
+	limit int
+	size  int
+}
+
+// NewMemory creates memory limited by total size of stored values, see ObjectSize. 0 means no limit
+func NewMemory(limit int) *Memory {
+	return &Memory{
+		store: make(map[string]Object),
+		sizes: make(map[string]int),
+		limit: limit,
+	}
+}
+
+func (m *Memory) Get(name string) (Object, bool) {
+	obj, ok := m.store[name]
+	return obj, ok
+}
+
+func (m *Memory) Set(name string, val Object) error {
+	valSize := ObjectSize(val)
+	newSize := m.size + valSize - m.sizes[name]
+	if m.limit > 0 && newSize > m.limit {
+		return fmt.Errorf("memory limit exceeded: %d of %d used, '%s' needs %d",
+			m.size, m.limit, name, valSize)
+	}
+	m.store[name] = val
+	m.sizes[name] = valSize
+	m.size = newSize
+	return nil
+}
+
+// recount measures stored values again. Structs are changed in place, also through variables
+// referencing the same struct as the persistent one, e.g. `x = persisted` and then `x.field = ...`,
+// so sizes accounted by Set could be outdated
+func (m *Memory) recount() error {
+	size := 0
+	for name, obj := range m.store {
+		m.sizes[name] = ObjectSize(obj)
+		size += m.sizes[name]
+	}
+	m.size = size
+	if m.limit > 0 && m.size > m.limit {
+		return fmt.Errorf("memory limit exceeded: %d of %d used after the execution", m.size, m.limit)
+	}
+	return nil
+}
+
+func (m *Memory) Size() int {
+	return m.size
+}
+
+func (m *Memory) Limit() int {
+	return m.limit
+}
+
+func (m *Memory) Keys() []string {
+	keys := make([]string, 0, len(m.store))
+	for k := range m.store {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ObjectSize returns the number of objects the value consists of,
+// arrays and structs are counted together with their elements and fields
+func ObjectSize(obj Object) int {
+	switch o := obj.(type) {
+	case *ObjArray:
+		size := 1
+		for _, el := range o.Elements {
+			size += ObjectSize(el)
+		}
+		return size
+	case *ObjStruct:
+		size := 1
+		for _, f := range o.Fields {
+			size += ObjectSize(f)
+		}
+		return size
+	default:
+		return 1
+	}
+}
+
+type serializedMemory struct {
+	Version     int                         `json:"version"`
+	Limit       int                         `json:"limit"`
+	Definitions *SerializedDefinitions      `json:"definitions"`
+	Values      map[string]SerializedObject `json:"values"`
+}
+
+func (m *Memory) MarshalJSON() ([]byte, error) {
+	s := &objectSerializer{definitions: newSerializedDefinitions()}
+	result := serializedMemory{
+		Version:     memorySerializationVersion,
+		Limit:       m.limit,
+		Definitions: s.definitions,
+		Values:      make(map[string]SerializedObject, len(m.store)),
+	}
+	for name, obj := range m.store {
+		serialized, err := s.serialize(obj, name)
+		if err != nil {
+			return nil, err
+		}
+		result.Values[name] = serialized
+	}
+	return json.Marshal(result)
+}
+
+func (m *Memory) UnmarshalJSON(data []byte) error {
+	var serialized serializedMemory
+	if err := json.Unmarshal(data, &serialized); err != nil {
+		return err
+	}
+	if serialized.Version != memorySerializationVersion {
+		return fmt.Errorf("unsupported memory version %d, expected %d",
+			serialized.Version, memorySerializationVersion)
+	}
+
+	d := newObjectDeserializer(serialized.Definitions)
+	restored := NewMemory(serialized.Limit)
+	for name, so := range serialized.Values {
+		obj, err := d.deserialize(so, name)
+		if err != nil {
+			return err
+		}
+		if err = restored.Set(name, obj); err != nil {
+			return err
+		}
+	}
+	*m = *restored
+	return nil
+}
+
+// SetMemory attaches persistent memory to the environment. Should be called on the top level environment
+func (e *Environment) SetMemory(m *Memory) {
+	e.memory = m
+}
+
+// Memory returns persistent memory attached to the top level environment
+func (e *Environment) Memory() *Memory {
+	if e.outer != nil {
+		return e.outer.Memory()
+	}
+	return e.memory
+}
+
+// isPersistent returns true if the name refers to the variable in memory and is not shadowed by local one
+func (e *Environment) isPersistent(name string) bool {
+	mem := e.Memory()
+	if mem == nil {
+		return false
+	}
+	if _, ok := e.getLocal(name); ok {
+		return false
+	}
+	_, ok := mem.Get(name)
+	return ok
+}
+
+// persistentRoot returns the name of the persistent variable the expression like `a.b[i].c` starts with
+func (e *Environment) persistentRoot(expr AstExpression) (string, bool) {
+	for {
+		switch n := expr.(type) {
+		case *AstStructFieldCall:
+			expr = n.StructExpr
+		case *AstArrayIndexCall:
+			expr = n.Left
+		case *AstIdentifier:
+			return n.Value, e.isPersistent(n.Value)
+		default:
+			return "", false
+		}
+	}
+}
+
+// checkMemoryLimit measures persistent values at the end of the execution, see Memory.recount
+func checkMemoryLimit(env *Environment) error {
+	if mem := env.Memory(); mem != nil {
+		return mem.recount()
+	}
+	return nil
+}
+
+func (e *ExecAstVisitor) execPersist(node *AstPersist, env *Environment) error {
+	e.operation(Operation{Type: OperationPersist})
+	mem := env.Memory()
+	if mem == nil {
+		return runtimeError(node, "persistent memory is not available, it should be set by host")
+	}
+	varName := node.Assignment.Left.Value
+	if _, exists := e.builtins[varName]; exists {
+		return runtimeError(node.Assignment.Left, "Builtins are immutable")
+	}
+	if _, ok := env.getLocal(varName); ok {
+		return runtimeError(node, "'%s' is already defined as ordinary variable", varName)
+	}
+	if _, ok := mem.Get(varName); ok {
+		// initialized in one of the previous runs
+		return nil
+	}
+
+	value, err := e.execExpression(node.Assignment.Value, env)
+	if err != nil {
+		return err
+	}
+	if err = mem.Set(varName, value); err != nil {
+		return runtimeError(node, "%s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestPersistSurvivesBetweenRuns(t *testing.T) {
+	input := `enum Colors {red, green, blue}
+struct point {
+   float x
+   float y
+}
+persist ticks = 0
+persist target = ?point
+persist color = Colors:red
+ticks = ticks + 1
+tmp = ticks * 2
+if empty(target) {
+   target = point{x = 1., y = 2.}
+}
+color = Colors:blue
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	mem := NewMemory(0)
+	for i := 0; i < 3; i++ {
+		env := NewEnvironment()
+		env.SetMemory(mem)
+		require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+		_, ok := env.Store()["ticks"]
+		require.False(t, ok, "persistent var should not leak into locals")
+	}
+
+	ticks, ok := mem.Get("ticks")
+	require.True(t, ok)
+	require.Equal(t, int64(3), ticks.(*ObjInteger).Value)
+	require.Equal(t, []string{"color", "target", "ticks"}, mem.Keys())
+
+	data, err := json.Marshal(mem)
+	require.Nil(t, err)
+
+	restored := NewMemory(0)
+	require.Nil(t, json.Unmarshal(data, restored))
+	require.Equal(t, mem.Size(), restored.Size())
+	for _, name := range mem.Keys() {
+		expected, _ := mem.Get(name)
+		actual, ok := restored.Get(name)
+		require.True(t, ok, name)
+		require.Equal(t, expected.Type(), actual.Type(), name)
+		require.Equal(t, expected.Inspect(), actual.Inspect(), name)
+	}
+
+	env := NewEnvironment()
+	env.SetMemory(restored)
+	require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+	ticks, _ = restored.Get("ticks")
+	require.Equal(t, int64(4), ticks.(*ObjInteger).Value)
+}
+
+func TestPersistMemoryLimit(t *testing.T) {
+	input := `persist a = []int{1, 2, 3}
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	env := NewEnvironment()
+	env.SetMemory(NewMemory(3))
+	err = NewExecAstVisitor().ExecAst(astProgram, env)
+	require.NotNil(t, err, "array of 3 ints needs 4 objects")
+}
+
+func TestPersistMemoryLimitOnFieldAssignment(t *testing.T) {
+	input := `struct node {
+   int v
+   []int items
+}
+persist n = node{v = 1, items = []int{}}
+n.v = 2
+n.items = []int{1, 2, 3}
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	mem := NewMemory(5)
+	env := NewEnvironment()
+	env.SetMemory(mem)
+	err = NewExecAstVisitor().ExecAst(astProgram, env)
+	require.NotNil(t, err, "node with 3 ints in items needs 6 objects")
+	require.Contains(t, err.Error(), "memory limit exceeded: 3 of 5 used, 'n' needs 6")
+	require.Equal(t, 3, mem.Size())
+	n, _ := mem.Get("n")
+	require.Equal(t, "node{items: []int{}, v: 2}", n.Inspect(), "failed assignment is reverted")
+}
+
+func TestPersistMemoryLimitThroughAlias(t *testing.T) {
+	input := `struct s {
+   []int a
+}
+persist t = s{a = []int{1}}
+x = t
+x.a = []int{1, 2, 3, 4, 5, 6, 7, 8, 9}
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	mem := NewMemory(5)
+	env := NewEnvironment()
+	env.SetMemory(mem)
+	err = NewExecAstVisitor().ExecAst(astProgram, env)
+	require.NotNil(t, err, "t is changed through x")
+	require.Equal(t, "memory limit exceeded: 11 of 5 used after the execution", err.Error())
+	require.Equal(t, 11, mem.Size())
+}
+
+func TestPersistNonFiniteFloatsJSON(t *testing.T) {
+	input := `persist inf = 1. / 0.
+persist v = vec2{x = 0., y = -1.} / 0.
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	mem := NewMemory(0)
+	env := NewEnvironment()
+	env.SetMemory(mem)
+	require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+
+	data, err := json.Marshal(mem)
+	require.Nil(t, err)
+	restored := NewMemory(0)
+	require.Nil(t, json.Unmarshal(data, restored))
+	inf, _ := restored.Get("inf")
+	require.True(t, math.IsInf(inf.(*ObjFloat).Value, 1))
+	v, _ := restored.Get("v")
+	require.True(t, math.IsNaN(v.(*ObjVec2).Value.X))
+	require.True(t, math.IsInf(v.(*ObjVec2).Value.Y, -1))
+}
+
+func TestPersistWithoutMemoryNegative(t *testing.T) {
+	input := `persist a = 1
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	err = NewExecAstVisitor().ExecAst(astProgram, NewEnvironment())
+	require.NotNil(t, err)
+}
@@ -2,7 +2,9 @@ package fdalang
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -92,6 +94,7 @@ func (rv *ObjReturnValue) Type() ObjectType { return TypeReturnValue }
 func (rv *ObjReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type ObjFunction struct {
+	Token      Token
 	Arguments  []*AstVarAndType
 	Statements *AstStatementsBlock
 	ReturnType string
@@ -113,9 +116,15 @@ func (s *ObjStruct) Type() ObjectType { return ObjectType(s.Definition.Name) }
 func (s *ObjStruct) Inspect() string {
 	var out bytes.Buffer
 
+	names := make([]string, 0, len(s.Fields))
+	for k := range s.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
 	var elements []string
-	for k, v := range s.Fields {
-		elements = append(elements, fmt.Sprintf("%s: %s", k, v.Inspect()))
+	for _, k := range names {
+		elements = append(elements, fmt.Sprintf("%s: %s", k, s.Fields[k].Inspect()))
 	}
 
 	out.WriteString(s.Definition.Name)
@@ -128,6 +137,10 @@ func (s *ObjStruct) Inspect() string {
 
 type BuiltinFunction func(env *Environment, args []Object) (Object, error)
 
+// BuiltinFunctionCtx is builtin which receives the context of the execution, see ExecAstContext.
+// Builtins which could block (e.g. waiting for the game server) should use it and respect cancellation
+type BuiltinFunctionCtx func(ctx context.Context, env *Environment, args []Object) (Object, error)
+
 type ArgTypes []string
 
 type ObjBuiltin struct {
@@ -135,6 +148,8 @@ type ObjBuiltin struct {
 	ArgTypes   ArgTypes
 	Fn         BuiltinFunction
 	ReturnType string
+	// CtxFn is used instead of Fn when set
+	CtxFn BuiltinFunctionCtx
 }
 
 func (b *ObjBuiltin) Type() ObjectType { return TypeBuiltinFn }
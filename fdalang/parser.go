@@ -1,7 +1,6 @@
 package fdalang
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 )
@@ -54,6 +53,21 @@ type Parser struct {
 
 	unaryExprFunctions map[TokenID]unaryExprFunction
 	binExprFunctions   map[TokenID]binExprFunctions
+
+	// recovering is set by ParseWithRecovery, errors are collected instead of stopping the parsing
+	recovering bool
+	errors     []*ParseError
+}
+
+// ParseError is the syntax error at the position of the source
+type ParseError struct {
+	Msg  string
+	Line int
+	Col  int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s\nline:%d, pos %d", e.Msg, e.Line, e.Col)
 }
 
 func NewParser(l *Lexer) *Parser {
@@ -139,28 +153,130 @@ func (p *Parser) Parse() (*AstStatementsBlock, error) {
 
 	statements, err := p.parseBlockOfStatements(TokenIDs(TokenEOC))
 	program.Statements = statements
+	program.End = p.currToken
 
 	return program, err
 }
 
+// ParseExpression parses the input consisting of a single expression, e.g. condition of a breakpoint
+func (p *Parser) ParseExpression() (AstExpression, error) {
+	var err error
+	if p.currToken, err = p.l.NextToken(); err != nil {
+		return nil, err
+	}
+	if p.nextToken, err = p.l.NextToken(); err != nil {
+		return nil, err
+	}
+
+	expr, err := p.parseExpression(precedenceLowest, []TokenID{TokenEOL, TokenEOC})
+	if err != nil {
+		return nil, err
+	}
+	if err = p.read(); err != nil {
+		return nil, err
+	}
+	if p.currToken.ID == TokenEOL {
+		if err = p.read(); err != nil {
+			return nil, err
+		}
+	}
+	if p.currToken.ID != TokenEOC {
+		return nil, p.parseError("expected end of expression, got '%s'", p.currToken.ID)
+	}
+	return expr, nil
+}
+
+// ParseWithRecovery parses the whole input even if it has syntax errors, it's used by tools
+// working with the source being edited. Statement with an error is skipped up to the end of its line
+// (or of its block) and parsing continues from the next one, so the AST contains all valid statements.
+func (p *Parser) ParseWithRecovery() (*AstStatementsBlock, []*ParseError) {
+	p.recovering = true
+	p.errors = nil
+	program, err := p.Parse()
+	if err != nil {
+		p.addError(err)
+	}
+	if program == nil {
+		program = &AstStatementsBlock{}
+	}
+	return program, p.errors
+}
+
 func (p *Parser) parseBlockOfStatements(terminatedTokens []TokenID) ([]AstStatement, error) {
 	var statements []AstStatement
 
 	for !p.currTokenIn(terminatedTokens) {
+		start := p.currToken
 		stmt, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			if !p.recovering {
+				return nil, err
+			}
+			p.addError(err)
+			if start.ID == TokenEOC {
+				// unclosed block, the caller reports what is missed
+				return statements, nil
+			}
+			p.skipStatement(start)
+			continue
 		}
 		if stmt != nil {
 			statements = append(statements, stmt)
 		}
 		if err = p.read(); err != nil {
-			return nil, err
+			if !p.recovering {
+				return nil, err
+			}
+			// invalid token starts the next statement and it is skipped as well
+			p.addError(err)
 		}
 	}
 	return statements, nil
 }
 
+// skipStatement moves to the start of the statement following the one started with the token.
+// Blocks are skipped as a whole, closing brace of the enclosing block stops skipping.
+func (p *Parser) skipStatement(start Token) {
+	p.l.BackToToken(start)
+	p.currToken, _ = p.l.NextToken()
+	p.nextToken, _ = p.l.NextToken()
+	depth := 0
+	for {
+		switch p.currToken.ID {
+		case TokenEOC:
+			return
+		case TokenEOL:
+			if depth == 0 {
+				_ = p.read()
+				return
+			}
+		case TokenLBrace:
+			depth++
+		case TokenRBrace:
+			if depth == 0 && p.currToken != start {
+				return
+			}
+			if depth > 0 {
+				depth--
+			}
+		}
+		_ = p.read()
+	}
+}
+
+func (p *Parser) addError(err error) {
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		parseErr = &ParseError{Msg: err.Error(), Line: p.currToken.Line, Col: p.currToken.Col}
+	}
+	for _, e := range p.errors {
+		if e.Line == parseErr.Line && e.Col == parseErr.Col {
+			return
+		}
+	}
+	p.errors = append(p.errors, parseErr)
+}
+
 func (p *Parser) parseStatement() (AstStatement, error) {
 	switch p.currToken.ID {
 	case TokenIdent:
@@ -175,6 +291,12 @@ func (p *Parser) parseStatement() (AstStatement, error) {
 		return p.parseEnumDefinition()
 	case TokenSwitch:
 		return p.parseSwitch()
+	case TokenOn:
+		return p.parseEventHandler()
+	case TokenPersist:
+		return p.parsePersist()
+	case TokenTest:
+		return p.parseTest()
 	case TokenEOL:
 		return nil, nil
 	default:
@@ -285,6 +407,21 @@ func (p *Parser) parseAssignment(terminatedTokens []TokenID) (*AstAssignment, er
 	return assignStmt, nil
 }
 
+func (p *Parser) parsePersist() (*AstPersist, error) {
+	stmt := &AstPersist{Token: p.currToken}
+	if err := p.requireToken(TokenIdent); err != nil {
+		return nil, err
+	}
+
+	assignment, err := p.parseAssignment(TokenIDs(TokenEOL))
+	if err != nil {
+		return nil, err
+	}
+	stmt.Assignment = assignment
+
+	return stmt, nil
+}
+
 func (p *Parser) parseReturn() (*AstReturn, error) {
 	stmt := &AstReturn{Token: p.currToken}
 	var err error
@@ -462,10 +599,16 @@ func (p *Parser) parseSwitch() (AstStatement, error) {
 	if err = p.read(); err != nil {
 		return nil, err
 	}
+	// empty lines, e.g. with comments, before the first case
+	for p.currToken.ID == TokenEOL {
+		if err = p.read(); err != nil {
+			return nil, err
+		}
+	}
 
 	cases := make([]*AstCase, 0)
 	for p.currToken.ID == TokenCase {
-		caseBlock := &AstCase{Token: Token{}}
+		caseBlock := &AstCase{Token: p.currToken}
 
 		if stmt.SwitchExpression != nil {
 			caseBlock.Condition, err = p.parseRightPartOfExpression(
@@ -491,7 +634,7 @@ func (p *Parser) parseSwitch() (AstStatement, error) {
 		if err != nil {
 			return nil, err
 		}
-		caseBlock.PositiveBranch = &AstStatementsBlock{Statements: statements}
+		caseBlock.PositiveBranch = &AstStatementsBlock{Statements: statements, End: p.currToken}
 		cases = append(cases, caseBlock)
 	}
 	stmt.Cases = cases
@@ -504,7 +647,7 @@ func (p *Parser) parseSwitch() (AstStatement, error) {
 		if err != nil {
 			return nil, err
 		}
-		stmt.DefaultBranch = &AstStatementsBlock{Statements: statements}
+		stmt.DefaultBranch = &AstStatementsBlock{Statements: statements, End: p.currToken}
 	}
 
 	return stmt, nil
@@ -537,7 +680,7 @@ func (p *Parser) parseIf() (AstStatement, error) {
 		return nil, err
 	}
 
-	stmt.PositiveBranch = &AstStatementsBlock{Statements: statements}
+	stmt.PositiveBranch = &AstStatementsBlock{Statements: statements, End: p.currToken}
 
 	if err = p.read(); err != nil {
 		return nil, err
@@ -552,7 +695,7 @@ func (p *Parser) parseIf() (AstStatement, error) {
 	}
 
 	statements, err = p.parseBlockOfStatements(TokenIDs(TokenRBrace))
-	stmt.ElseBranch = &AstStatementsBlock{Statements: statements}
+	stmt.ElseBranch = &AstStatementsBlock{Statements: statements, End: p.currToken}
 
 	return stmt, err
 }
@@ -630,11 +773,72 @@ func (p *Parser) parseFunction(terminatedTokens []TokenID) (AstExpression, error
 		return nil, err
 	}
 	statements, err := p.parseBlockOfStatements(TokenIDs(TokenRBrace))
-	function.StatementsBlock = &AstStatementsBlock{Statements: statements}
+	function.StatementsBlock = &AstStatementsBlock{Statements: statements, End: p.currToken}
 
 	return function, err
 }
 
+func (p *Parser) parseEventHandler() (AstStatement, error) {
+	node := &AstEventHandler{Token: p.currToken, Arguments: make([]*AstVarAndType, 0)}
+
+	if err := p.requireToken(TokenIdent); err != nil {
+		return nil, err
+	}
+	node.Event = p.currToken.Value
+
+	var err error
+	if p.nextToken.ID == TokenLParen {
+		if err = p.requireToken(TokenLParen); err != nil {
+			return nil, err
+		}
+		if err = p.read(); err != nil {
+			return nil, err
+		}
+		node.Arguments, err = p.parseVarAndTypes(TokenRParen, TokenComma)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectCurToken(TokenRParen); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = p.requireTokenSequence([]TokenID{TokenLBrace, TokenEOL}); err != nil {
+		return nil, err
+	}
+
+	if err = p.read(); err != nil {
+		return nil, err
+	}
+	statements, err := p.parseBlockOfStatements(TokenIDs(TokenRBrace))
+	node.StatementsBlock = &AstStatementsBlock{Statements: statements, End: p.currToken}
+
+	return node, err
+}
+
+func (p *Parser) parseTest() (AstStatement, error) {
+	node := &AstTest{Token: p.currToken}
+
+	if err := p.requireToken(TokenString); err != nil {
+		return nil, err
+	}
+	node.Name = p.currToken.Value
+	if node.Name == "" {
+		return nil, p.parseError("test name should not be empty")
+	}
+
+	if err := p.requireTokenSequence([]TokenID{TokenLBrace, TokenEOL}); err != nil {
+		return nil, err
+	}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	statements, err := p.parseBlockOfStatements(TokenIDs(TokenRBrace))
+	node.StatementsBlock = &AstStatementsBlock{Statements: statements, End: p.currToken}
+
+	return node, err
+}
+
 func (p *Parser) parseVarAndTypes(endToken TokenID, delimiterToken TokenID) ([]*AstVarAndType, error) {
 	var err error
 	vars := make([]*AstVarAndType, 0)
@@ -990,6 +1194,5 @@ func (p *Parser) registerBinExprFunction(TokenID TokenID, fn binExprFunctions) {
 }
 
 func (p *Parser) parseError(format string, args ...interface{}) error {
-	msg := fmt.Sprintf(format, args...)
-	return errors.New(fmt.Sprintf("%s\nline:%d, pos %d", msg, p.currToken.Line, p.currToken.Col))
+	return &ParseError{Msg: fmt.Sprintf(format, args...), Line: p.currToken.Line, Col: p.currToken.Col}
 }
@@ -180,6 +180,25 @@ b = 2
 	assert.IsType(t, &AstIf{}, astProgram.Statements[0])
 }
 
+func TestParseTest(t *testing.T) {
+	input := `test "moves to the target" {
+   assert(true)
+}
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	require.Len(t, astProgram.Statements, 1)
+	require.IsType(t, &AstTest{}, astProgram.Statements[0])
+	test := astProgram.Statements[0].(*AstTest)
+	assert.Equal(t, "moves to the target", test.Name)
+	assert.Len(t, test.StatementsBlock.Statements, 1)
+
+	for _, input := range []string{"test {\n}\n", "test \"\" {\n}\n", "test \"a {\n}\n", "test a {\n}\n"} {
+		_, err = NewParser(NewLexer(input)).Parse()
+		require.NotNil(t, err, input)
+	}
+}
+
 func TestArrayAsInvalidStatementNegative(t *testing.T) {
 	input := `int[]{1, 2.1, 3}
 `
@@ -206,3 +225,61 @@ func TestInvalidExpr(t *testing.T) {
 	_, err := p.Parse()
 	require.NotNil(t, err)
 }
+
+func TestParseEventHandler(t *testing.T) {
+	input := `on tick {
+   a = 1
+}
+on collision(int kind, float force) {
+   b = 2
+}
+`
+	l := NewLexer(input)
+	p := NewParser(l)
+
+	astProgram, err := p.Parse()
+	require.Nil(t, err)
+	require.Len(t, astProgram.Statements, 2)
+
+	require.IsType(t, &AstEventHandler{}, astProgram.Statements[0])
+	tick, _ := astProgram.Statements[0].(*AstEventHandler)
+	assert.Equal(t, "tick", tick.Event)
+	assert.Len(t, tick.Arguments, 0)
+	assert.Len(t, tick.StatementsBlock.Statements, 1)
+
+	require.IsType(t, &AstEventHandler{}, astProgram.Statements[1])
+	collision, _ := astProgram.Statements[1].(*AstEventHandler)
+	assert.Equal(t, "collision", collision.Event)
+	require.Len(t, collision.Arguments, 2)
+	assert.Equal(t, "float", collision.Arguments[1].VarType)
+	assert.Equal(t, "force", collision.Arguments[1].Var.Value)
+}
+
+func TestParseWithRecovery(t *testing.T) {
+	input := `a = 1
+b = 
+f = fn(int x) int {
+   c = x +
+   return x
+}
+d = 2
+`
+	p := NewParser(NewLexer(input))
+
+	astProgram, errors := p.ParseWithRecovery()
+	require.Len(t, errors, 2)
+	assert.Equal(t, 2, errors[0].Line)
+	assert.Equal(t, 4, errors[1].Line)
+
+	require.Len(t, astProgram.Statements, 3)
+	require.IsType(t, &AstStatementWithVoidedExpression{}, astProgram.Statements[1])
+	stmt, _ := astProgram.Statements[1].(*AstStatementWithVoidedExpression)
+	require.IsType(t, &AstAssignment{}, stmt.Expr)
+	require.IsType(t, &AstFunction{}, stmt.Expr.(*AstAssignment).Value)
+	fn, _ := stmt.Expr.(*AstAssignment).Value.(*AstFunction)
+	assert.Len(t, fn.StatementsBlock.Statements, 1, "valid statement of the body is kept")
+
+	astProgram, errors = NewParser(NewLexer("a = 1\nif a {\n")).ParseWithRecovery()
+	require.NotEmpty(t, errors)
+	require.NotNil(t, astProgram)
+}
@@ -0,0 +1,186 @@
+package fdalang
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// Field numbers of the messages from github.com/google/pprof/proto/profile.proto
+const (
+	pprofProfileSampleType    = 1
+	pprofProfileSample        = 2
+	pprofProfileLocation      = 4
+	pprofProfileFunction      = 5
+	pprofProfileStringTable   = 6
+	pprofProfileTimeNanos     = 9
+	pprofProfileDurationNanos = 10
+	pprofProfilePeriodType    = 11
+	pprofProfilePeriod        = 12
+	pprofProfileDefaultType   = 14
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+
+	pprofFunctionID        = 1
+	pprofFunctionName      = 2
+	pprofFunctionFilename  = 4
+	pprofFunctionStartLine = 5
+)
+
+// protoBuffer is minimal protocol buffers encoder enough to write pprof profiles
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) int64Field(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(uint64(x))
+}
+
+func (b *protoBuffer) bytesField(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) messageField(field int, msg *protoBuffer) {
+	b.bytesField(field, msg.data)
+}
+
+func (b *protoBuffer) packedInt64sField(field int, xs []int64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	b.bytesField(field, packed.data)
+}
+
+// pprofBuilder collects string table, functions and locations of the profile
+type pprofBuilder struct {
+	profile   protoBuffer
+	strings   map[string]int64
+	functions map[string]int64
+	locations map[profileFrame]int64
+	table     []string
+}
+
+func (b *pprofBuilder) stringIndex(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := int64(len(b.table))
+	b.strings[s] = i
+	b.table = append(b.table, s)
+	return i
+}
+
+func (b *pprofBuilder) valueType(field int, typ, unit string) {
+	var vt protoBuffer
+	vt.int64Field(pprofValueTypeType, b.stringIndex(typ))
+	vt.int64Field(pprofValueTypeUnit, b.stringIndex(unit))
+	b.profile.messageField(field, &vt)
+}
+
+func (b *pprofBuilder) functionID(p *Profiler, name string) int64 {
+	if id, ok := b.functions[name]; ok {
+		return id
+	}
+	id := int64(len(b.functions) + 1)
+	b.functions[name] = id
+
+	var fn protoBuffer
+	fn.int64Field(pprofFunctionID, id)
+	fn.int64Field(pprofFunctionName, b.stringIndex(name))
+	fn.int64Field(pprofFunctionFilename, b.stringIndex(p.options.FileName))
+	fn.int64Field(pprofFunctionStartLine, int64(p.functionLines[name]))
+	b.profile.messageField(pprofProfileFunction, &fn)
+	return id
+}
+
+func (b *pprofBuilder) locationID(p *Profiler, frame profileFrame) int64 {
+	if id, ok := b.locations[frame]; ok {
+		return id
+	}
+	id := int64(len(b.locations) + 1)
+	b.locations[frame] = id
+
+	var line protoBuffer
+	line.int64Field(pprofLineFunctionID, b.functionID(p, frame.function))
+	line.int64Field(pprofLineLine, int64(frame.line))
+	var loc protoBuffer
+	loc.int64Field(pprofLocationID, id)
+	loc.messageField(pprofLocationLine, &line)
+	b.profile.messageField(pprofProfileLocation, &loc)
+	return id
+}
+
+// WritePprof writes collected data in gzipped pprof protobuf format, it could be viewed
+// with `go tool pprof`. Sample values are operations count and, with WallTime option, nanoseconds
+func (p *Profiler) WritePprof(w io.Writer) error {
+	b := &pprofBuilder{
+		strings:   make(map[string]int64),
+		functions: make(map[string]int64),
+		locations: make(map[profileFrame]int64),
+	}
+	// string table should start with empty string
+	b.stringIndex("")
+
+	b.valueType(pprofProfileSampleType, "operations", "count")
+	if p.options.WallTime {
+		b.valueType(pprofProfileSampleType, "wall", "nanoseconds")
+	}
+	for _, s := range p.sortedSamples() {
+		locations := make([]int64, len(s.frames))
+		for i, frame := range s.frames {
+			locations[i] = b.locationID(p, frame)
+		}
+		values := []int64{s.operations}
+		if p.options.WallTime {
+			values = append(values, s.wallTime.Nanoseconds())
+		}
+		var sample protoBuffer
+		sample.packedInt64sField(pprofSampleLocationID, locations)
+		sample.packedInt64sField(pprofSampleValue, values)
+		b.profile.messageField(pprofProfileSample, &sample)
+	}
+	b.profile.int64Field(pprofProfileTimeNanos, time.Now().UnixNano())
+	b.profile.int64Field(pprofProfileDurationNanos, p.duration.Nanoseconds())
+	b.valueType(pprofProfilePeriodType, "operations", "count")
+	b.profile.int64Field(pprofProfilePeriod, 1)
+	b.profile.int64Field(pprofProfileDefaultType, b.stringIndex("operations"))
+	// string table is written last because strings are added on the way
+	for _, s := range b.table {
+		b.profile.bytesField(pprofProfileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.profile.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
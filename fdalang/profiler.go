@@ -0,0 +1,256 @@
+package fdalang
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MainFunctionName is the name of the top level code of the program in profiles and debugger call stacks
+const MainFunctionName = "main"
+
+type ProfilerOptions struct {
+	// WallTime enables measuring of the time besides operations count, it makes execution noticeably slower
+	WallTime bool
+	// FileName is the source file name shown in pprof, "main.fda" by default
+	FileName string
+}
+
+// Profiler attributes executed operations to the source lines and script functions.
+// Cost of every operation goes to the line of the statement being executed and to the stack
+// of script functions (and event handlers) it was called from. Profiler accumulates data
+// over all executions until Reset, it is not safe for concurrent use.
+//
+//	profiler := fdalang.NewProfiler(fdalang.ProfilerOptions{})
+//	executor.SetProfiler(profiler)
+//	// executions...
+//	err := profiler.WritePprof(file) // go tool pprof -list . file
+type Profiler struct {
+	options ProfilerOptions
+	stack   []profileFrame
+	// samples by stack key
+	samples map[string]*profileSample
+	// functionLines are the lines the functions start at
+	functionLines map[string]int
+	// current is the sample of the current stack, nil when the stack is changed
+	current *profileSample
+	// last is the sample of the last operation
+	last     *profileSample
+	lastTime time.Time
+	duration time.Duration
+}
+
+type profileFrame struct {
+	function string
+	line     int
+}
+
+type profileSample struct {
+	// frames are from the innermost to the outermost one
+	frames     []profileFrame
+	operations int64
+	wallTime   time.Duration
+}
+
+func NewProfiler(options ProfilerOptions) *Profiler {
+	if options.FileName == "" {
+		options.FileName = "main.fda"
+	}
+	p := &Profiler{options: options}
+	p.Reset()
+	return p
+}
+
+// SetProfiler enables profiling of the executions, nil disables it
+func (e *ExecAstVisitor) SetProfiler(p *Profiler) {
+	e.profiler = p
+}
+
+// Reset drops collected data
+func (p *Profiler) Reset() {
+	p.samples = make(map[string]*profileSample)
+	p.functionLines = map[string]int{MainFunctionName: 1}
+	p.duration = 0
+	p.begin()
+}
+
+func (p *Profiler) begin() {
+	p.stack = []profileFrame{{function: MainFunctionName}}
+	p.current = nil
+	p.last = nil
+	if p.options.WallTime {
+		p.lastTime = time.Now()
+	}
+}
+
+func (p *Profiler) end() {
+	p.tick()
+	p.last = nil
+}
+
+func (p *Profiler) setLine(line int) {
+	p.stack[len(p.stack)-1].line = line
+	p.current = nil
+}
+
+func (p *Profiler) push(function string, line int) {
+	if _, ok := p.functionLines[function]; !ok {
+		p.functionLines[function] = line
+	}
+	p.stack = append(p.stack, profileFrame{function: function, line: line})
+	p.current = nil
+}
+
+func (p *Profiler) pop() {
+	p.stack = p.stack[:len(p.stack)-1]
+	p.current = nil
+}
+
+// operation adds the cost of one operation to the current stack
+func (p *Profiler) operation() {
+	p.tick()
+	if p.current == nil {
+		p.current = p.sample()
+	}
+	p.current.operations++
+	p.last = p.current
+}
+
+// tick attributes the time passed since the previous operation to the stack of that operation
+func (p *Profiler) tick() {
+	if !p.options.WallTime {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(p.lastTime)
+	p.lastTime = now
+	p.duration += elapsed
+	if p.last != nil {
+		p.last.wallTime += elapsed
+	}
+}
+
+func (p *Profiler) sample() *profileSample {
+	var key strings.Builder
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(&key, "%s:%d;", p.stack[i].function, p.stack[i].line)
+	}
+	if s, ok := p.samples[key.String()]; ok {
+		return s
+	}
+	s := &profileSample{frames: make([]profileFrame, len(p.stack))}
+	for i := range p.stack {
+		s.frames[i] = p.stack[len(p.stack)-1-i]
+	}
+	p.samples[key.String()] = s
+	return s
+}
+
+// sortedSamples returns samples in deterministic order
+func (p *Profiler) sortedSamples() []*profileSample {
+	keys := make([]string, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]*profileSample, len(keys))
+	for i, k := range keys {
+		samples[i] = p.samples[k]
+	}
+	return samples
+}
+
+type profileCost struct {
+	operations int64
+	wallTime   time.Duration
+}
+
+func (c *profileCost) add(s *profileSample) {
+	c.operations += s.operations
+	c.wallTime += s.wallTime
+}
+
+// WriteReport writes plain text report: functions with own (flat) and total (cum) cost
+// followed by the source annotated with the cost of every line
+func (p *Profiler) WriteReport(w io.Writer, source string) error {
+	flat := make(map[string]*profileCost)
+	cum := make(map[string]*profileCost)
+	lines := make(map[int]*profileCost)
+	var total profileCost
+	for _, s := range p.sortedSamples() {
+		total.add(s)
+		leaf := s.frames[0]
+		if lines[leaf.line] == nil {
+			lines[leaf.line] = &profileCost{}
+		}
+		lines[leaf.line].add(s)
+		if flat[leaf.function] == nil {
+			flat[leaf.function] = &profileCost{}
+		}
+		flat[leaf.function].add(s)
+		seen := make(map[string]bool)
+		for _, f := range s.frames {
+			if seen[f.function] {
+				continue
+			}
+			seen[f.function] = true
+			if cum[f.function] == nil {
+				cum[f.function] = &profileCost{}
+			}
+			cum[f.function].add(s)
+		}
+	}
+
+	functions := make([]string, 0, len(cum))
+	for name := range cum {
+		functions = append(functions, name)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		a, b := cum[functions[i]], cum[functions[j]]
+		if a.operations != b.operations {
+			return a.operations > b.operations
+		}
+		return functions[i] < functions[j]
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Total: %d operations", total.operations)
+	if p.options.WallTime {
+		fmt.Fprintf(bw, ", %s", p.duration)
+	}
+	fmt.Fprintf(bw, "\n\n%10s %10s  %s\n", "flat", "cum", "function")
+	for _, name := range functions {
+		var flatOps int64
+		if flat[name] != nil {
+			flatOps = flat[name].operations
+		}
+		fmt.Fprintf(bw, "%10d %10d  %s (line %d)\n", flatOps, cum[name].operations, name, p.functionLines[name])
+	}
+
+	fmt.Fprintf(bw, "\n%10s", "ops")
+	if p.options.WallTime {
+		fmt.Fprintf(bw, " %12s", "time")
+	}
+	fmt.Fprintf(bw, " %5s\n", "line")
+	for i, text := range strings.Split(strings.TrimRight(source, "\n"), "\n") {
+		line := i + 1
+		cost, ok := lines[line]
+		if ok {
+			fmt.Fprintf(bw, "%10d", cost.operations)
+		} else {
+			fmt.Fprintf(bw, "%10s", ".")
+		}
+		if p.options.WallTime {
+			if ok {
+				fmt.Fprintf(bw, " %12s", cost.wallTime)
+			} else {
+				fmt.Fprintf(bw, " %12s", ".")
+			}
+		}
+		fmt.Fprintf(bw, " %5d  %s\n", line, text)
+	}
+	return bw.Flush()
+}
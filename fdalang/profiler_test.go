@@ -0,0 +1,93 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const profiledSource = `sum = fn(int n) int {
+   if n < 1 {
+      return 0
+   }
+   return n + sum(n - 1)
+}
+a = sum(3)
+b = absInt(-a)
+on tick() {
+   c = sum(1)
+}
+`
+
+func profileSource(t *testing.T, options ProfilerOptions) *Profiler {
+	program, err := NewProgram(profiledSource)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	profiler := NewProfiler(options)
+	r.Executor().SetProfiler(profiler)
+	_, err = r.Run()
+	require.Nil(t, err)
+	_, err = r.Dispatch([]Event{{Name: "tick"}})
+	require.Nil(t, err)
+	return profiler
+}
+
+func TestProfilerReport(t *testing.T) {
+	profiler := profileSource(t, ProfilerOptions{})
+
+	var report bytes.Buffer
+	require.Nil(t, profiler.WriteReport(&report, profiledSource))
+	lines := strings.Split(report.String(), "\n")
+
+	require.Contains(t, lines[0], "Total: ")
+	require.Regexp(t, `^\s+\d+\s+\d+\s+main \(line 1\)$`, lines[3], "main has the largest total cost")
+	require.Contains(t, report.String(), " sum (line 1)")
+	require.Contains(t, report.String(), " on tick (line 9)")
+	require.Regexp(t, `\n\s+\.\s+6  }\n`, report.String(), "lines without statements have no cost")
+	require.Regexp(t, `\n\s+\d+\s+5     return n \+ sum\(n - 1\)\n`, report.String())
+}
+
+func TestProfilerStacks(t *testing.T) {
+	profiler := profileSource(t, ProfilerOptions{WallTime: true})
+
+	stacks := make(map[string]int64)
+	for _, s := range profiler.samples {
+		var frames []string
+		for _, f := range s.frames {
+			frames = append(frames, f.function)
+		}
+		stacks[strings.Join(frames, "<")] += s.operations
+	}
+	require.Contains(t, stacks, "sum<sum<sum<sum<main")
+	require.Contains(t, stacks, "sum<on tick<main")
+	require.NotContains(t, stacks, "absInt<main", "builtins are attributed to the caller")
+	require.True(t, profiler.duration > 0)
+}
+
+func TestProfilerPprof(t *testing.T) {
+	profiler := profileSource(t, ProfilerOptions{WallTime: true, FileName: "bot.fda"})
+
+	var buf bytes.Buffer
+	require.Nil(t, profiler.WritePprof(&buf))
+	gz, err := gzip.NewReader(&buf)
+	require.Nil(t, err)
+	data, err := ioutil.ReadAll(gz)
+	require.Nil(t, err)
+
+	for _, s := range []string{"operations", "count", "wall", "nanoseconds", "bot.fda", "main", "sum", "on tick"} {
+		require.True(t, bytes.Contains(data, []byte(s)), s)
+	}
+}
+
+func TestProtoBufferVarint(t *testing.T) {
+	var b protoBuffer
+	b.varint(1)
+	b.varint(300)
+	b.int64Field(1, 150)
+	b.int64Field(2, 0)
+	require.Equal(t, []byte{0x01, 0xac, 0x02, 0x08, 0x96, 0x01}, b.data)
+}
@@ -0,0 +1,191 @@
+package fdalang
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrAlreadyRun is returned by the second Run of the same Runtime: top level statements declare
+// structs, enums and handlers which can't be declared twice in the same environment
+var ErrAlreadyRun = errors.New("runtime has already run the program, create a new Runtime to run it again")
+
+// Program is the parsed and checked bot source. It is immutable after creation and could be
+// shared between goroutines: any number of Runtimes may execute the same Program concurrently.
+//
+// What is safe to share:
+//   - Program itself and its AST: the interpreter never modifies AST nodes, struct and enum
+//     definitions declared in the program are only read after parsing;
+//   - ReservedObjTrue and ReservedObjFalse: booleans are never changed in place.
+//
+// What is not safe to share:
+//   - Runtime, ExecAstVisitor, Environment and Memory: they are owned by one goroutine at a time;
+//   - Objects (structs and arrays are mutable by script) and host builtins with their own state.
+//     Host should bind separate values into each Runtime and make builtins safe for concurrent
+//     calls if they are registered in several Runtimes.
+type Program struct {
+	ast           *AstStatementsBlock
+	handledEvents []string
+	tests         []*AstTest
+	builtins      map[string]*ObjBuiltin
+}
+
+// ProgramOptions declare what the host provides to every Runtime, the program is type checked against them
+type ProgramOptions struct {
+	// Env declares values, structs and enums the host binds into runtimes, e.g. with Bind or Fixtures.Apply.
+	// Only types are used, values are not shared with runtimes
+	Env *Environment
+	// Builtins are host builtins, they are added to every Runtime created by NewRuntime
+	Builtins map[string]*ObjBuiltin
+}
+
+// NewProgram parses the source and type checks it, see Check. The program could use only builtins,
+// NewProgramWithOptions declares values and builtins of the host
+func NewProgram(source string) (*Program, error) {
+	return NewProgramWithOptions(source, ProgramOptions{})
+}
+
+// NewProgramWithOptions parses the source and type checks it with host declarations of the options,
+// the first check error is returned
+func NewProgramWithOptions(source string, options ProgramOptions) (*Program, error) {
+	ast, err := NewParser(NewLexer(source)).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTopLevelDeclarations(ast); err != nil {
+		return nil, err
+	}
+	executor := NewExecAstVisitor()
+	executor.AddBuiltinFunctions(options.Builtins)
+	if info := executor.Check(ast, options.Env); len(info.Errors) > 0 {
+		return nil, info.Errors[0]
+	}
+	return &Program{
+		ast:           ast,
+		handledEvents: HandledEvents(ast),
+		tests:         Tests(ast),
+		builtins:      options.Builtins,
+	}, nil
+}
+
+// Ast returns the program AST. It is shared by all Runtimes and must not be modified
+func (p *Program) Ast() *AstStatementsBlock {
+	return p.ast
+}
+
+// HandledEvents returns names of the events handled by the program in declaration order
+func (p *Program) HandledEvents() []string {
+	return append([]string(nil), p.handledEvents...)
+}
+
+// Tests returns `test` blocks of the program in declaration order, see RunTests
+func (p *Program) Tests() []*AstTest {
+	return append([]*AstTest(nil), p.tests...)
+}
+
+// checkTopLevelDeclarations reports duplicated declarations which would fail only at runtime otherwise
+func checkTopLevelDeclarations(ast *AstStatementsBlock) error {
+	structs := make(map[string]bool)
+	enums := make(map[string]bool)
+	handlers := make(map[string]bool)
+	tests := make(map[string]bool)
+	for _, stmt := range ast.Statements {
+		switch n := stmt.(type) {
+		case *AstStructDefinition:
+			if n.Name == TypeVec2 {
+				return runtimeError(n, "struct '%s' is a builtin type and can't be redefined", n.Name)
+			}
+			if structs[n.Name] {
+				return runtimeError(n, "struct '%s' already defined", n.Name)
+			}
+			structs[n.Name] = true
+		case *AstEnumDefinition:
+			if enums[n.Name] {
+				return runtimeError(n, "enum '%s' already defined", n.Name)
+			}
+			enums[n.Name] = true
+		case *AstEventHandler:
+			if handlers[n.Event] {
+				return runtimeError(n, "handler for event '%s' already defined", n.Event)
+			}
+			handlers[n.Event] = true
+		case *AstTest:
+			if tests[n.Name] {
+				return runtimeError(n, "test '%s' already defined", n.Name)
+			}
+			tests[n.Name] = true
+		}
+	}
+	return nil
+}
+
+// Runtime is the independent execution state of the Program: own executor with builtins and
+// random generator and own top level environment. Runtime must not be used from several
+// goroutines at the same time, but different Runtimes of the same Program could run in parallel.
+type Runtime struct {
+	program  *Program
+	executor *ExecAstVisitor
+	env      *Environment
+	ran      bool
+}
+
+func NewRuntime(program *Program) *Runtime {
+	executor := NewExecAstVisitor()
+	executor.AddBuiltinFunctions(program.builtins)
+	return &Runtime{
+		program:  program,
+		executor: executor,
+		env:      NewEnvironment(),
+	}
+}
+
+func (r *Runtime) Program() *Program {
+	return r.program
+}
+
+// Executor could be used to set callback, random seed or register host builtins before Run
+func (r *Runtime) Executor() *ExecAstVisitor {
+	return r.executor
+}
+
+// Env could be used to bind host values or attach Memory before Run
+func (r *Runtime) Env() *Environment {
+	return r.env
+}
+
+// Run executes the program top level statements in the runtime environment. It could be called once,
+// state between ticks is kept in the environment and changed by Dispatch or Call, ErrAlreadyRun is returned
+// by the next Run. Stats are returned even if the execution failed
+func (r *Runtime) Run() (ExecStats, error) {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is Run which stops when the context is done, see ExecAstVisitor.ExecAstContext
+func (r *Runtime) RunContext(ctx context.Context) (ExecStats, error) {
+	if r.ran {
+		return ExecStats{}, ErrAlreadyRun
+	}
+	r.ran = true
+	err := r.executor.ExecAstContext(ctx, r.program.ast, r.env)
+	return r.executor.Stats(), err
+}
+
+// Dispatch runs event handlers, see ExecAstVisitor.Dispatch
+func (r *Runtime) Dispatch(events []Event) (ExecStats, error) {
+	err := r.executor.Dispatch(r.env, events)
+	return r.executor.Stats(), err
+}
+
+func (r *Runtime) DispatchContext(ctx context.Context, events []Event) (ExecStats, error) {
+	err := r.executor.DispatchContext(ctx, r.env, events)
+	return r.executor.Stats(), err
+}
+
+// Call calls the script function defined in the runtime environment, see ExecAstVisitor.Call.
+// Stats of the call are available with Executor().Stats()
+func (r *Runtime) Call(name string, args ...Object) (Object, error) {
+	return r.executor.Call(r.env, name, args...)
+}
+
+func (r *Runtime) CallContext(ctx context.Context, name string, args ...Object) (Object, error) {
+	return r.executor.CallContext(ctx, r.env, name, args...)
+}
@@ -0,0 +1,175 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const concurrentProgramSource = `enum Colors {red, green, blue}
+struct unit {
+   int hp
+   vec2 pos
+   Colors color
+   []int hits
+}
+persist ticks = 0
+me = unit{hp = 100, pos = vec2{x = 0., y = 0.}, color = Colors:red, hits = []int{}}
+step = fn(int damage) int {
+   me.hp = me.hp - damage
+   me.pos = me.pos + vec2{x = 1., y = 1.}
+   return me.hp
+}
+on hit(int damage) {
+   step(damage)
+   me.color = Colors:blue
+   if randChance(0.5) {
+      me.hp = me.hp - 1
+   }
+}
+on tick() {
+   ticks = ticks + 1
+}
+`
+
+func runConcurrentProgram(program *Program, seed int64, damage int64) (string, error) {
+	r := NewRuntime(program)
+	r.Executor().SetRandSeed(seed)
+	r.Env().SetMemory(NewMemory(0))
+	if _, err := r.Run(); err != nil {
+		return "", err
+	}
+	for i := 0; i < 10; i++ {
+		events := []Event{
+			{Name: "tick"},
+			{Name: "hit", Args: []Object{&ObjInteger{Value: damage}}},
+		}
+		if _, err := r.Dispatch(events); err != nil {
+			return "", err
+		}
+	}
+	hp, err := r.Call("step", &ObjInteger{Value: damage})
+	if err != nil {
+		return "", err
+	}
+	me, _ := r.Env().Get("me")
+	ticks, _ := r.Env().Memory().Get("ticks")
+	return fmt.Sprintf("%s %s %s", hp.Inspect(), me.Inspect(), ticks.Inspect()), nil
+}
+
+func TestProgramConcurrentRuntimes(t *testing.T) {
+	program, err := NewProgram(concurrentProgramSource)
+	require.Nil(t, err)
+	require.Equal(t, []string{"hit", "tick"}, program.HandledEvents())
+
+	const runtimes = 64
+	expected := make([]string, runtimes)
+	for i := range expected {
+		expected[i], err = runConcurrentProgram(program, int64(i%4), int64(i%3))
+		require.Nil(t, err)
+	}
+
+	results := make([]string, runtimes)
+	errs := make([]error, runtimes)
+	var wg sync.WaitGroup
+	for i := 0; i < runtimes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = runConcurrentProgram(program, int64(i%4), int64(i%3))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		require.Nil(t, errs[i])
+		require.Equal(t, expected[i], results[i], "runtime #%d", i)
+	}
+}
+
+func TestProgramConcurrentRuntimesWithBoundValues(t *testing.T) {
+	type mech struct {
+		Hp  int64 `fda:"hp"`
+		Pos Vec2  `fda:"pos"`
+	}
+	host := NewEnvironment()
+	require.Nil(t, host.Bind("m", mech{}))
+	program, err := NewProgramWithOptions(`m.hp = m.hp - 10
+m.pos = m.pos * 2.
+`, ProgramOptions{Env: host})
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 32)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := NewRuntime(program)
+			if errs[i] = r.Env().Bind("m", mech{Hp: int64(i), Pos: Vec2{X: 1, Y: 1}}); errs[i] != nil {
+				return
+			}
+			if _, errs[i] = r.Run(); errs[i] != nil {
+				return
+			}
+			var m mech
+			if errs[i] = r.Env().Decode("m", &m); errs[i] != nil {
+				return
+			}
+			if m.Hp != int64(i)-10 || m.Pos != (Vec2{X: 2, Y: 2}) {
+				errs[i] = fmt.Errorf("unexpected result %+v", m)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		require.Nil(t, err, "runtime #%d", i)
+	}
+}
+
+func TestNewProgramDuplicatedDeclarationsNegative(t *testing.T) {
+	tests := []string{
+		"struct a {\n   int x\n}\nstruct a {\n   int y\n}\n",
+		"enum a {x, y}\nenum a {z}\n",
+		"on tick() {\n   a = 1\n}\non tick() {\n   a = 2\n}\n",
+		"test \"a\" {\n   a = 1\n}\ntest \"a\" {\n   a = 2\n}\n",
+	}
+	for _, source := range tests {
+		_, err := NewProgram(source)
+		require.NotNil(t, err, source)
+	}
+}
+
+func TestNewProgramTypeErrorsNegative(t *testing.T) {
+	_, err := NewProgram("a = 1 + true\n")
+	require.NotNil(t, err)
+	require.IsType(t, &CheckError{}, err)
+
+	_, err = NewProgram("m.hp = 1\n")
+	require.NotNil(t, err, "host values should be declared with options")
+	type mech struct {
+		Hp int64 `fda:"hp"`
+	}
+	host := NewEnvironment()
+	require.Nil(t, host.Bind("m", mech{}))
+	_, err = NewProgramWithOptions("m.hp = true\n", ProgramOptions{Env: host})
+	require.NotNil(t, err)
+	_, err = NewProgramWithOptions("m.hp = 1\n", ProgramOptions{Env: host})
+	require.Nil(t, err)
+}
+
+func TestRuntimeRunOnce(t *testing.T) {
+	program, err := NewProgram("struct p {\n   int x\n}\na = p{x = 1}\n")
+	require.Nil(t, err)
+	r := NewRuntime(program)
+	_, err = r.Run()
+	require.Nil(t, err)
+	_, err = r.Run()
+	require.Equal(t, ErrAlreadyRun, err)
+
+	_, err = NewRuntime(program).Run()
+	require.Nil(t, err, "every runtime runs the program")
+}
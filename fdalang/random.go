@@ -0,0 +1,108 @@
+package fdalang
+
+import (
+	"fmt"
+)
+
+const (
+	BuiltinRandInt    = "randInt"
+	BuiltinRandFloat  = "randFloat"
+	BuiltinRandChance = "randChance"
+)
+
+// Rand is a small deterministic pseudo random generator (splitmix64).
+// Its whole state is one uint64 so host can snapshot and restore it between ticks
+// and get exactly the same sequence in replays.
+type Rand struct {
+	state uint64
+}
+
+func NewRand(seed int64) *Rand {
+	return &Rand{state: uint64(seed)}
+}
+
+func (r *Rand) State() uint64 {
+	return r.state
+}
+
+func (r *Rand) SetState(state uint64) {
+	r.state = state
+}
+
+func (r *Rand) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Float64 returns number in [0, 1)
+func (r *Rand) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
+
+// IntRange returns number in [lo, hi] without modulo bias
+func (r *Rand) IntRange(lo, hi int64) (int64, error) {
+	if hi < lo {
+		return 0, fmt.Errorf("invalid range: lower bound %d is greater than upper bound %d", lo, hi)
+	}
+	n := uint64(hi-lo) + 1
+	if n == 0 {
+		// full int64 range
+		return int64(r.Uint64()), nil
+	}
+	limit := -n % n
+	for {
+		v := r.Uint64()
+		if v >= limit {
+			return lo + int64(v%n), nil
+		}
+	}
+}
+
+func (e *ExecAstVisitor) SetRandSeed(seed int64) {
+	e.rand = NewRand(seed)
+}
+
+func (e *ExecAstVisitor) RandState() uint64 {
+	return e.rand.State()
+}
+
+func (e *ExecAstVisitor) SetRandState(state uint64) {
+	e.rand.SetState(state)
+}
+
+func (e *ExecAstVisitor) setupRandBuiltinFunctions() {
+	e.builtins[BuiltinRandInt] = &ObjBuiltin{
+		Name:       BuiltinRandInt,
+		ArgTypes:   ArgTypes{TypeInt, TypeInt},
+		ReturnType: TypeInt,
+		Fn: func(env *Environment, args []Object) (Object, error) {
+			lo := args[0].(*ObjInteger).Value
+			hi := args[1].(*ObjInteger).Value
+			value, err := e.rand.IntRange(lo, hi)
+			if err != nil {
+				return nil, BuiltinFuncError("%s: %s", BuiltinRandInt, err.Error())
+			}
+			return &ObjInteger{Value: value}, nil
+		},
+	}
+	e.builtins[BuiltinRandFloat] = &ObjBuiltin{
+		Name:       BuiltinRandFloat,
+		ArgTypes:   ArgTypes{},
+		ReturnType: TypeFloat,
+		Fn: func(env *Environment, args []Object) (Object, error) {
+			return &ObjFloat{Value: e.rand.Float64()}, nil
+		},
+	}
+	e.builtins[BuiltinRandChance] = &ObjBuiltin{
+		Name:       BuiltinRandChance,
+		ArgTypes:   ArgTypes{TypeFloat},
+		ReturnType: TypeBool,
+		Fn: func(env *Environment, args []Object) (Object, error) {
+			p := args[0].(*ObjFloat).Value
+			return nativeBooleanToBoolean(e.rand.Float64() < p), nil
+		},
+	}
+}
@@ -5,6 +5,9 @@ import (
 )
 
 func execScalarBinOperation(left, right Object, operator TokenID) (Object, error) {
+	if left.Type() == TypeVec2 || right.Type() == TypeVec2 {
+		return vec2BinOperation(left, right, operator)
+	}
 	if left.Type() == TypeInt {
 		left, _ := left.(*ObjInteger)
 		right, _ := right.(*ObjInteger)
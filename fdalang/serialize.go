@@ -0,0 +1,300 @@
+package fdalang
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// SerializedObject is typed representation of the script value used for JSON encoding.
+// Only one of the value fields is filled depending on the Type.
+type SerializedObject struct {
+	Type         string                      `json:"type"`
+	Empty        bool                        `json:"empty,omitempty"`
+	Int          int64                       `json:"int,omitempty"`
+	Float        SerializedFloat             `json:"float,omitempty"`
+	Bool         bool                        `json:"bool,omitempty"`
+	Enum         string                      `json:"enum,omitempty"`
+	Vec2         *SerializedVec2             `json:"vec2,omitempty"`
+	ElementsType string                      `json:"elementsType,omitempty"`
+	Elements     []SerializedObject          `json:"elements,omitempty"`
+	Fields       map[string]SerializedObject `json:"fields,omitempty"`
+	Function     *SerializedFunction         `json:"function,omitempty"`
+}
+
+// SerializedFloat is float encoded in JSON as number, infinities and NaN which JSON numbers
+// can't represent are encoded as strings "+Inf", "-Inf" and "NaN"
+type SerializedFloat float64
+
+func (f SerializedFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func (f *SerializedFloat) UnmarshalJSON(data []byte) error {
+	var special string
+	if err := json.Unmarshal(data, &special); err == nil {
+		switch special {
+		case "+Inf":
+			*f = SerializedFloat(math.Inf(1))
+		case "-Inf":
+			*f = SerializedFloat(math.Inf(-1))
+		case "NaN":
+			*f = SerializedFloat(math.NaN())
+		default:
+			return fmt.Errorf("invalid float '%s'", special)
+		}
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = SerializedFloat(v)
+	return nil
+}
+
+// SerializedVec2 is Vec2 with coordinates encoded as SerializedFloat
+type SerializedVec2 struct {
+	X SerializedFloat
+	Y SerializedFloat
+}
+
+// SerializedFunction references function literal in the program AST by its position
+// and the scope the function was created in
+type SerializedFunction struct {
+	Pos   int `json:"pos"`
+	Scope int `json:"scope"`
+}
+
+// SerializedDefinitions keeps struct and enum definitions needed to restore serialized values
+type SerializedDefinitions struct {
+	Structs map[string]map[string]string `json:"structs,omitempty"`
+	Enums   map[string][]string          `json:"enums,omitempty"`
+}
+
+func newSerializedDefinitions() *SerializedDefinitions {
+	return &SerializedDefinitions{
+		Structs: make(map[string]map[string]string),
+		Enums:   make(map[string][]string),
+	}
+}
+
+// addStruct adds definition, definitions are identified by name so
+// different structs with the same name in different scopes can't be serialized together
+func (d *SerializedDefinitions) addStruct(def *AstStructDefinition) error {
+	fields := make(map[string]string, len(def.Fields))
+	for name, f := range def.Fields {
+		fields[name] = f.VarType
+	}
+	if existing, ok := d.Structs[def.Name]; ok {
+		if !reflect.DeepEqual(existing, fields) {
+			return fmt.Errorf("different structs with the same name '%s' can't be serialized", def.Name)
+		}
+		return nil
+	}
+	d.Structs[def.Name] = fields
+	return nil
+}
+
+func (d *SerializedDefinitions) addEnum(def *AstEnumDefinition) error {
+	if existing, ok := d.Enums[def.Name]; ok {
+		if !reflect.DeepEqual(existing, def.Elements) {
+			return fmt.Errorf("different enums with the same name '%s' can't be serialized", def.Name)
+		}
+		return nil
+	}
+	d.Enums[def.Name] = def.Elements
+	return nil
+}
+
+// objectSerializer converts objects to serialized form collecting used definitions on the way
+type objectSerializer struct {
+	definitions *SerializedDefinitions
+	// scopeID is set when function values could be serialized
+	scopeID func(env *Environment) int
+}
+
+func (s *objectSerializer) serialize(obj Object, path string) (SerializedObject, error) {
+	result := SerializedObject{Type: string(obj.Type()), Empty: isEmptyObject(obj)}
+	switch o := obj.(type) {
+	case *ObjInteger:
+		result.Int = o.Value
+	case *ObjFloat:
+		result.Float = SerializedFloat(o.Value)
+	case *ObjBoolean:
+		result.Bool = o.Value
+	case *ObjVec2:
+		result.Vec2 = &SerializedVec2{X: SerializedFloat(o.Value.X), Y: SerializedFloat(o.Value.Y)}
+	case *ObjEnum:
+		if err := s.definitions.addEnum(o.Definition); err != nil {
+			return result, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		result.Enum = o.Definition.Elements[o.Value]
+	case *ObjArray:
+		result.ElementsType = o.ElementsType
+		for i, el := range o.Elements {
+			serialized, err := s.serialize(el, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return result, err
+			}
+			result.Elements = append(result.Elements, serialized)
+		}
+	case *ObjStruct:
+		if err := s.definitions.addStruct(o.Definition); err != nil {
+			return result, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		result.Fields = make(map[string]SerializedObject, len(o.Fields))
+		for name, field := range o.Fields {
+			serialized, err := s.serialize(field, path+"."+name)
+			if err != nil {
+				return result, err
+			}
+			result.Fields[name] = serialized
+		}
+	case *ObjFunction:
+		if s.scopeID == nil {
+			return result, fmt.Errorf("%s: functions can be serialized only as part of environment snapshot", path)
+		}
+		result.Function = &SerializedFunction{Pos: o.Token.Pos, Scope: s.scopeID(o.Env)}
+	default:
+		return result, fmt.Errorf("%s: value of type '%s' can't be serialized", path, obj.Type())
+	}
+	return result, nil
+}
+
+// objectDeserializer restores objects, struct and enum definitions are created once per deserializer
+// so all restored values of the same type share the definition
+type objectDeserializer struct {
+	definitions *SerializedDefinitions
+	structs     map[string]*AstStructDefinition
+	enums       map[string]*AstEnumDefinition
+	// function is set when function values could be restored
+	function func(sf *SerializedFunction) (*ObjFunction, error)
+}
+
+func newObjectDeserializer(definitions *SerializedDefinitions) *objectDeserializer {
+	if definitions == nil {
+		definitions = newSerializedDefinitions()
+	}
+	return &objectDeserializer{
+		definitions: definitions,
+		structs:     make(map[string]*AstStructDefinition),
+		enums:       make(map[string]*AstEnumDefinition),
+	}
+}
+
+func (d *objectDeserializer) structDefinition(name string) (*AstStructDefinition, bool) {
+	if def, ok := d.structs[name]; ok {
+		return def, true
+	}
+	fields, ok := d.definitions.Structs[name]
+	if !ok {
+		return nil, false
+	}
+	def := &AstStructDefinition{Name: name, Fields: make(map[string]*AstVarAndType, len(fields))}
+	for fieldName, varType := range fields {
+		def.Fields[fieldName] = &AstVarAndType{VarType: varType, Var: &AstIdentifier{Value: fieldName}}
+	}
+	d.structs[name] = def
+	return def, true
+}
+
+func (d *objectDeserializer) enumDefinition(name string) (*AstEnumDefinition, bool) {
+	if def, ok := d.enums[name]; ok {
+		return def, true
+	}
+	elements, ok := d.definitions.Enums[name]
+	if !ok {
+		return nil, false
+	}
+	def := &AstEnumDefinition{Name: name, Elements: elements}
+	d.enums[name] = def
+	return def, true
+}
+
+func (d *objectDeserializer) deserialize(so SerializedObject, path string) (Object, error) {
+	emptier := Emptier{Empty: so.Empty}
+	switch {
+	case so.Type == TypeInt:
+		return &ObjInteger{Emptier: emptier, Value: so.Int}, nil
+	case so.Type == TypeFloat:
+		return &ObjFloat{Emptier: emptier, Value: float64(so.Float)}, nil
+	case so.Type == TypeBool:
+		return nativeBooleanToBoolean(so.Bool), nil
+	case so.Type == TypeVec2:
+		obj := &ObjVec2{Emptier: emptier}
+		if so.Vec2 != nil {
+			obj.Value = Vec2{X: float64(so.Vec2.X), Y: float64(so.Vec2.Y)}
+		}
+		return obj, nil
+	case so.Type == TypeFunction:
+		if d.function == nil || so.Function == nil {
+			return nil, fmt.Errorf("%s: functions can be restored only as part of environment snapshot", path)
+		}
+		fn, err := d.function(so.Function)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		return fn, nil
+	case strings.HasPrefix(so.Type, "[]"):
+		elements := make([]Object, len(so.Elements))
+		for i, el := range so.Elements {
+			obj, err := d.deserialize(el, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			if string(obj.Type()) != so.ElementsType {
+				return nil, fmt.Errorf("%s[%d]: array element should be '%s' but '%s' given",
+					path, i, so.ElementsType, obj.Type())
+			}
+			elements[i] = obj
+		}
+		return &ObjArray{Emptier: emptier, ElementsType: so.ElementsType, Elements: elements}, nil
+	}
+
+	if def, ok := d.enumDefinition(so.Type); ok {
+		for i, el := range def.Elements {
+			if el == so.Enum {
+				return &ObjEnum{Definition: def, Value: int8(i)}, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: enum '%s' doesn't have element '%s'", path, def.Name, so.Enum)
+	}
+
+	if def, ok := d.structDefinition(so.Type); ok {
+		fields := make(map[string]Object, len(so.Fields))
+		for name, field := range so.Fields {
+			declared, ok := def.Fields[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: struct '%s' doesn't have the field '%s'", path, def.Name, name)
+			}
+			obj, err := d.deserialize(field, path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			if string(obj.Type()) != declared.VarType {
+				return nil, fmt.Errorf("%s.%s: field defined as '%s' but '%s' given",
+					path, name, declared.VarType, obj.Type())
+			}
+			fields[name] = obj
+		}
+		if !so.Empty && len(fields) != len(def.Fields) {
+			return nil, fmt.Errorf("%s: struct '%s' should have %d fields but %d given",
+				path, def.Name, len(def.Fields), len(fields))
+		}
+		return &ObjStruct{Emptier: emptier, Definition: def, Fields: fields}, nil
+	}
+
+	return nil, fmt.Errorf("%s: unknown type '%s'", path, so.Type)
+}
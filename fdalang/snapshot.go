@@ -0,0 +1,266 @@
+package fdalang
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+)
+
+const snapshotVersion = 1
+
+// Snapshot is typed and versioned state of the Environment: variables of all nested scopes,
+// struct and enum definitions, event handlers and persistent memory.
+// Function values are stored by reference to the function literal in the program AST,
+// so the snapshot could be restored only with the same program.
+// Values referenced from several variables are restored as independent copies.
+//
+// Snapshot could be encoded with encoding/json or with compact binary encoding by MarshalBinary.
+type Snapshot struct {
+	Version     int                    `json:"version"`
+	Definitions *SerializedDefinitions `json:"definitions"`
+	// Scopes[0] is the snapshotted environment, others are its outer scopes and scopes of closures
+	Scopes      []SerializedScope           `json:"scopes"`
+	MemoryLimit int                         `json:"memoryLimit,omitempty"`
+	Memory      map[string]SerializedObject `json:"memory,omitempty"`
+}
+
+type SerializedScope struct {
+	// Outer is index of the outer scope, -1 for the top level one
+	Outer   int                         `json:"outer"`
+	Vars    map[string]SerializedObject `json:"vars,omitempty"`
+	Structs []string                    `json:"structs,omitempty"`
+	Enums   []string                    `json:"enums,omitempty"`
+	// EventHandlers are positions of the handlers in the program
+	EventHandlers []int `json:"eventHandlers,omitempty"`
+}
+
+func (e *Environment) Snapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version:     snapshotVersion,
+		Definitions: newSerializedDefinitions(),
+	}
+
+	ids := make(map[*Environment]int)
+	var queue []*Environment
+	scopeID := func(env *Environment) int {
+		if id, ok := ids[env]; ok {
+			return id
+		}
+		ids[env] = len(queue)
+		queue = append(queue, env)
+		return ids[env]
+	}
+	s := &objectSerializer{definitions: snapshot.Definitions, scopeID: scopeID}
+
+	scopeID(e)
+	if mem := e.Memory(); mem != nil {
+		snapshot.MemoryLimit = mem.limit
+		snapshot.Memory = make(map[string]SerializedObject, len(mem.store))
+		for name, obj := range mem.store {
+			serialized, err := s.serialize(obj, name)
+			if err != nil {
+				return nil, err
+			}
+			snapshot.Memory[name] = serialized
+		}
+	}
+
+	for i := 0; i < len(queue); i++ {
+		env := queue[i]
+		scope := SerializedScope{Outer: -1, Vars: make(map[string]SerializedObject, len(env.store))}
+		if env.outer != nil {
+			scope.Outer = scopeID(env.outer)
+		}
+		for name, obj := range env.store {
+			serialized, err := s.serialize(obj, name)
+			if err != nil {
+				return nil, err
+			}
+			scope.Vars[name] = serialized
+		}
+		for name, def := range env.structDefinitions {
+			if err := snapshot.Definitions.addStruct(def); err != nil {
+				return nil, err
+			}
+			scope.Structs = append(scope.Structs, name)
+		}
+		for name, def := range env.enumDefinitions {
+			if err := snapshot.Definitions.addEnum(def); err != nil {
+				return nil, err
+			}
+			scope.Enums = append(scope.Enums, name)
+		}
+		for _, h := range env.eventHandlers {
+			scope.EventHandlers = append(scope.EventHandlers, h.Token.Pos)
+		}
+		sort.Strings(scope.Structs)
+		sort.Strings(scope.Enums)
+		sort.Ints(scope.EventHandlers)
+		snapshot.Scopes = append(snapshot.Scopes, scope)
+	}
+
+	return snapshot, nil
+}
+
+// Restore creates environment from the snapshot. Program should be the same the snapshot was made with,
+// it is used to restore function values and event handlers.
+func (s *Snapshot) Restore(program *AstStatementsBlock) (*Environment, error) {
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", s.Version, snapshotVersion)
+	}
+	if len(s.Scopes) == 0 {
+		return nil, fmt.Errorf("snapshot doesn't have any scope")
+	}
+
+	envs := make([]*Environment, len(s.Scopes))
+	for i := range s.Scopes {
+		envs[i] = NewEnvironment()
+	}
+	for i, scope := range s.Scopes {
+		if scope.Outer >= len(envs) || scope.Outer < -1 || scope.Outer == i {
+			return nil, fmt.Errorf("scope #%d has invalid outer scope %d", i, scope.Outer)
+		}
+		if scope.Outer != -1 {
+			envs[i].outer = envs[scope.Outer]
+		}
+	}
+	for i := range envs {
+		depth := 0
+		for env := envs[i]; env.outer != nil; env = env.outer {
+			if depth++; depth > len(envs) {
+				return nil, fmt.Errorf("scope #%d has cyclic outer scopes", i)
+			}
+		}
+	}
+
+	var nodes map[int]AstNode
+	nodeAt := func(pos int) (AstNode, error) {
+		if program == nil {
+			return nil, fmt.Errorf("program is required to restore functions and event handlers")
+		}
+		if nodes == nil {
+			nodes = astNodesByPos(program)
+		}
+		node, ok := nodes[pos]
+		if !ok {
+			return nil, fmt.Errorf("program doesn't have function or event handler at position %d", pos)
+		}
+		return node, nil
+	}
+
+	d := newObjectDeserializer(s.Definitions)
+	d.function = func(sf *SerializedFunction) (*ObjFunction, error) {
+		if sf.Scope < 0 || sf.Scope >= len(envs) {
+			return nil, fmt.Errorf("function refers to unknown scope %d", sf.Scope)
+		}
+		node, err := nodeAt(sf.Pos)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := node.(*AstFunction)
+		if !ok {
+			return nil, fmt.Errorf("program doesn't have function at position %d", sf.Pos)
+		}
+		return &ObjFunction{
+			Token:      fn.Token,
+			Arguments:  fn.Arguments,
+			Statements: fn.StatementsBlock,
+			ReturnType: fn.ReturnType,
+			Env:        envs[sf.Scope],
+		}, nil
+	}
+
+	for i, scope := range s.Scopes {
+		env := envs[i]
+		for _, name := range scope.Structs {
+			def, ok := d.structDefinition(name)
+			if !ok {
+				return nil, fmt.Errorf("snapshot doesn't have definition of struct '%s'", name)
+			}
+			if err := env.RegisterStructDefinition(def); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range scope.Enums {
+			def, ok := d.enumDefinition(name)
+			if !ok {
+				return nil, fmt.Errorf("snapshot doesn't have definition of enum '%s'", name)
+			}
+			if err := env.RegisterEnumDefinition(def); err != nil {
+				return nil, err
+			}
+		}
+		for _, pos := range scope.EventHandlers {
+			node, err := nodeAt(pos)
+			if err != nil {
+				return nil, err
+			}
+			h, ok := node.(*AstEventHandler)
+			if !ok {
+				return nil, fmt.Errorf("program doesn't have event handler at position %d", pos)
+			}
+			if err = env.RegisterEventHandler(h); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, scope := range s.Scopes {
+		for name, so := range scope.Vars {
+			obj, err := d.deserialize(so, name)
+			if err != nil {
+				return nil, err
+			}
+			envs[i].Set(name, obj)
+		}
+	}
+
+	if s.Memory != nil {
+		mem := NewMemory(s.MemoryLimit)
+		for name, so := range s.Memory {
+			obj, err := d.deserialize(so, name)
+			if err != nil {
+				return nil, err
+			}
+			if err = mem.Set(name, obj); err != nil {
+				return nil, err
+			}
+		}
+		root := envs[0]
+		for root.outer != nil {
+			root = root.outer
+		}
+		root.SetMemory(mem)
+	}
+
+	return envs[0], nil
+}
+
+// snapshotData has the same fields as Snapshot but without Marshal/UnmarshalBinary methods
+type snapshotData Snapshot
+
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode((*snapshotData)(s)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode((*snapshotData)(s))
+}
+
+// astNodesByPos collects function literals and event handlers of the program by their positions
+func astNodesByPos(program *AstStatementsBlock) map[int]AstNode {
+	nodes := make(map[int]AstNode)
+	Inspect(program, func(node AstNode) bool {
+		switch n := node.(type) {
+		case *AstFunction, *AstEventHandler:
+			nodes[n.GetToken().Pos] = n
+		}
+		return true
+	})
+	return nodes
+}
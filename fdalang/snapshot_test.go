@@ -0,0 +1,143 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	input := `enum Colors {red, green, blue}
+struct point {
+   float x
+   float y
+}
+persist hits = 0
+col = Colors:green
+p = point{x = 1., y = 2.}
+pts = []point{p}
+v = vec2{x = 1., y = 1.}
+add = fn(int x, int y) int {
+   return x + y
+}
+on damaged(int amount) {
+   hits = hits + amount
+}
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+
+	env := NewEnvironment()
+	env.SetMemory(NewMemory(100))
+	e := NewExecAstVisitor()
+	require.Nil(t, e.ExecAst(astProgram, env))
+	require.Nil(t, e.Dispatch(env, []Event{{Name: "damaged", Args: []Object{&ObjInteger{Value: 3}}}}))
+
+	snapshot, err := env.Snapshot()
+	require.Nil(t, err)
+
+	jsonData, err := json.Marshal(snapshot)
+	require.Nil(t, err)
+	binaryData, err := snapshot.MarshalBinary()
+	require.Nil(t, err)
+
+	fromJson := &Snapshot{}
+	require.Nil(t, json.Unmarshal(jsonData, fromJson))
+	fromBinary := &Snapshot{}
+	require.Nil(t, fromBinary.UnmarshalBinary(binaryData))
+
+	for _, restoredSnapshot := range []*Snapshot{fromJson, fromBinary} {
+		restored, err := restoredSnapshot.Restore(astProgram)
+		require.Nil(t, err)
+
+		for _, name := range []string{"col", "p", "pts", "v"} {
+			expected, _ := env.Get(name)
+			actual, ok := restored.Get(name)
+			require.True(t, ok, name)
+			require.Equal(t, expected.Type(), actual.Type(), name)
+			require.Equal(t, expected.Inspect(), actual.Inspect(), name)
+		}
+
+		_, ok := restored.StructDefinition("point")
+		require.True(t, ok)
+
+		result, err := e.Call(restored, "add", &ObjInteger{Value: 2}, &ObjInteger{Value: 5})
+		require.Nil(t, err)
+		require.Equal(t, int64(7), result.(*ObjInteger).Value)
+
+		require.Nil(t, e.Dispatch(restored, []Event{{Name: "damaged", Args: []Object{&ObjInteger{Value: 2}}}}))
+		hits, _ := restored.Get("hits")
+		require.Equal(t, int64(5), hits.(*ObjInteger).Value)
+	}
+}
+
+func TestSnapshotClosureScope(t *testing.T) {
+	input := `makeAdder = fn(int base) int {
+   return base
+}
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	env := NewEnvironment()
+	require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+
+	inner := NewEnclosedEnvironment(env)
+	inner.Set("local", &ObjInteger{Value: 1})
+	snapshot, err := inner.Snapshot()
+	require.Nil(t, err)
+	require.Len(t, snapshot.Scopes, 2)
+
+	restored, err := snapshot.Restore(astProgram)
+	require.Nil(t, err)
+	_, ok := restored.Store()["local"]
+	require.True(t, ok)
+	_, ok = restored.Get("makeAdder")
+	require.True(t, ok)
+
+	_, err = snapshot.Restore(nil)
+	require.NotNil(t, err, "functions can't be restored without program")
+}
+
+func TestSnapshotNonFiniteFloats(t *testing.T) {
+	input := `inf = 1. / 0.
+negInf = -1. / 0.
+nan = inf - inf
+v = vec2{x = 1., y = -1.} / 0.
+w = vec2{x = 0., y = 2.} / 0.
+`
+	astProgram, err := NewParser(NewLexer(input)).Parse()
+	require.Nil(t, err)
+	env := NewEnvironment()
+	require.Nil(t, NewExecAstVisitor().ExecAst(astProgram, env))
+
+	snapshot, err := env.Snapshot()
+	require.Nil(t, err)
+	jsonData, err := json.Marshal(snapshot)
+	require.Nil(t, err)
+	require.Contains(t, string(jsonData), `"float":"+Inf"`)
+
+	fromJson := &Snapshot{}
+	require.Nil(t, json.Unmarshal(jsonData, fromJson))
+	restored, err := fromJson.Restore(astProgram)
+	require.Nil(t, err)
+
+	float := func(name string) float64 {
+		obj, ok := restored.Get(name)
+		require.True(t, ok, name)
+		return obj.(*ObjFloat).Value
+	}
+	require.True(t, math.IsInf(float("inf"), 1))
+	require.True(t, math.IsInf(float("negInf"), -1))
+	require.True(t, math.IsNaN(float("nan")))
+
+	v, _ := restored.Get("v")
+	require.True(t, math.IsInf(v.(*ObjVec2).Value.X, 1))
+	require.True(t, math.IsInf(v.(*ObjVec2).Value.Y, -1))
+	w, _ := restored.Get("w")
+	require.True(t, math.IsNaN(w.(*ObjVec2).Value.X))
+	require.True(t, math.IsInf(w.(*ObjVec2).Value.Y, 1))
+
+	require.NotNil(t, json.Unmarshal([]byte(`{"type":"float","float":"Infinity"}`), &SerializedObject{}))
+}
@@ -0,0 +1,143 @@
+package fdalang
+
+import (
+	"time"
+)
+
+// ExecStats is the report of one execution (ExecAst, Dispatch or Call). It is collected always,
+// counters are cheap enough to be used for "CPU" scoring of bots. Calls made by builtins back into
+// the executor are reported as a part of the outer execution.
+type ExecStats struct {
+	// Operations is the number of executed operations by type, the same operations ExecCallback receives
+	Operations map[OperationType]int
+	// Builtins is the number of calls by builtin name
+	Builtins map[string]int
+	// FunctionCalls is the number of script function calls by the name the function was called with,
+	// calls of function literals without name are counted as "anonymous"
+	FunctionCalls map[string]int
+	// MaxCallDepth is the maximum depth of nested script function calls
+	MaxCallDepth int
+	// Allocations is the number of allocated objects, see AllocStats
+	Allocations int
+	Elapsed     time.Duration
+}
+
+// TotalOperations returns the number of all executed operations
+func (s ExecStats) TotalOperations() int {
+	total := 0
+	for _, count := range s.Operations {
+		total += count
+	}
+	return total
+}
+
+type execStatsCounters struct {
+	operations      [operationTypesCount]int
+	totalOperations int
+	builtins        map[string]int
+	functionCalls   map[string]int
+	callDepth       int
+	maxCallDepth    int
+	started         time.Time
+	elapsed         time.Duration
+}
+
+// Stats returns the report of the current or the last execution
+func (e *ExecAstVisitor) Stats() ExecStats {
+	c := &e.statsCounters
+	stats := ExecStats{
+		Operations:    make(map[OperationType]int),
+		Builtins:      make(map[string]int, len(c.builtins)),
+		FunctionCalls: make(map[string]int, len(c.functionCalls)),
+		MaxCallDepth:  c.maxCallDepth,
+		Allocations:   e.allocStats.Allocated,
+		Elapsed:       c.elapsed,
+	}
+	for t, count := range c.operations {
+		if count > 0 {
+			stats.Operations[OperationType(t)] = count
+		}
+	}
+	for name, count := range c.builtins {
+		stats.Builtins[name] = count
+	}
+	for name, count := range c.functionCalls {
+		stats.FunctionCalls[name] = count
+	}
+	return stats
+}
+
+// beginExecution resets stats and allocations accounting, should be called by every public entry point
+// of the execution together with deferred endExecution. Execution entered from a builtin during another
+// execution is a part of the outer one, so it's counted and limited together with it
+func (e *ExecAstVisitor) beginExecution() {
+	e.executionDepth++
+	if e.executionDepth > 1 {
+		return
+	}
+	e.resetAllocated()
+	e.statsCounters = execStatsCounters{
+		builtins:      make(map[string]int),
+		functionCalls: make(map[string]int),
+		started:       time.Now(),
+	}
+	if e.profiler != nil {
+		e.profiler.begin()
+	}
+	if e.debugger != nil {
+		e.debugger.begin()
+	}
+}
+
+func (e *ExecAstVisitor) endExecution() {
+	e.executionDepth--
+	if e.executionDepth > 0 {
+		return
+	}
+	e.statsCounters.elapsed = time.Since(e.statsCounters.started)
+	if e.profiler != nil {
+		e.profiler.end()
+	}
+}
+
+// operation counts the operation and passes it to the host callback
+func (e *ExecAstVisitor) operation(op Operation) {
+	e.statsCounters.operations[op.Type]++
+	e.statsCounters.totalOperations++
+	if op.Type == OperationBuiltin {
+		e.statsCounters.builtins[op.FuncName]++
+	}
+	if e.profiler != nil {
+		e.profiler.operation()
+	}
+	e.execCallback(op)
+}
+
+func (e *ExecAstVisitor) enterFunction(node *AstFunctionCall, fn *ObjFunction) {
+	c := &e.statsCounters
+	name := "anonymous"
+	if ident, ok := node.Function.(*AstIdentifier); ok {
+		name = ident.Value
+	}
+	c.functionCalls[name]++
+	c.callDepth++
+	if c.callDepth > c.maxCallDepth {
+		c.maxCallDepth = c.callDepth
+	}
+	if e.profiler != nil {
+		e.profiler.push(name, fn.Token.Line)
+	}
+	if e.debugger != nil {
+		e.debugger.push(name)
+	}
+}
+
+func (e *ExecAstVisitor) leaveFunction() {
+	e.statsCounters.callDepth--
+	if e.profiler != nil {
+		e.profiler.pop()
+	}
+	if e.debugger != nil {
+		e.debugger.pop()
+	}
+}
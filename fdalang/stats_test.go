@@ -0,0 +1,84 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"testing"
+)
+
+func TestExecStats(t *testing.T) {
+	input := `fact = fn(int n) int {
+   if n < 2 {
+      return 1
+   }
+   return n * fact(n - 1)
+}
+a = fact(4)
+b = absInt(-2) + absInt(3)
+c = []int{a, b}
+`
+	program, err := NewProgram(input)
+	require.Nil(t, err)
+	r := NewRuntime(program)
+
+	callbackOperations := 0
+	r.Executor().SetExecCallback(func(operation Operation) {
+		callbackOperations++
+	})
+	stats, err := r.Run()
+	require.Nil(t, err)
+
+	require.Equal(t, map[string]int{"fact": 4}, stats.FunctionCalls)
+	require.Equal(t, 4, stats.MaxCallDepth)
+	require.Equal(t, map[string]int{BuiltinAbsInt: 2}, stats.Builtins)
+	require.Equal(t, 2, stats.Operations[OperationBuiltin])
+	require.Equal(t, 6, stats.Operations[OperationFunctionCall])
+	require.Equal(t, 3, stats.Allocations)
+	require.Equal(t, callbackOperations, stats.TotalOperations())
+	require.True(t, stats.Elapsed > 0)
+
+	_, err = r.Call("fact", &ObjInteger{Value: 2})
+	require.Nil(t, err)
+	stats = r.Executor().Stats()
+	require.Equal(t, map[string]int{"fact": 2}, stats.FunctionCalls, "stats should be reset for every execution")
+	require.Equal(t, 2, stats.MaxCallDepth)
+	require.Empty(t, stats.Builtins)
+}
+
+func TestExecStatsReentrantCall(t *testing.T) {
+	input := `fact = fn(int n) int {
+   if n < 2 {
+      return 1
+   }
+   return n * fact(n - 1)
+}
+a = fact(2)
+b = hostFact(3)
+`
+	var r *Runtime
+	program, err := NewProgramWithOptions(input, ProgramOptions{Builtins: map[string]*ObjBuiltin{
+		"hostFact": {
+			Name:       "hostFact",
+			ArgTypes:   ArgTypes{TypeInt},
+			ReturnType: TypeInt,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return r.Call("fact", args[0])
+			},
+		},
+	}})
+	require.Nil(t, err)
+	r = NewRuntime(program)
+
+	callbackOperations := 0
+	r.Executor().SetExecCallback(func(operation Operation) {
+		callbackOperations++
+	})
+	stats, err := r.Run()
+	require.Nil(t, err)
+
+	require.Equal(t, map[string]int{"fact": 5}, stats.FunctionCalls, "nested call is a part of the outer stats")
+	require.Equal(t, 3, stats.MaxCallDepth)
+	require.Equal(t, map[string]int{"hostFact": 1}, stats.Builtins)
+	require.Equal(t, callbackOperations, stats.TotalOperations())
+	require.Equal(t, stats, r.Executor().Stats())
+}
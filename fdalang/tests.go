@@ -0,0 +1,235 @@
+package fdalang
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	BuiltinAssert   = "assert"
+	BuiltinAssertEq = "assertEq"
+)
+
+// floatTolerance is the relative difference of floats assertEq treats as equal, results of
+// the same computation could differ in the last bits, e.g. after sqrt
+const floatTolerance = 1e-9
+
+// AssertionError is the failure of assert or assertEq builtins
+type AssertionError struct {
+	Msg string
+	// Left and Right are Inspect() of the values compared by assertEq
+	Left  string
+	Right string
+	Line  int
+	Col   int
+}
+
+func (e *AssertionError) Error() string {
+	msg := e.Msg
+	if e.Left != "" || e.Right != "" {
+		msg += "\n" + InspectDiff(e.Left, e.Right)
+	}
+	return fmt.Sprintf("%s\nline:%d, pos %d", msg, e.Line, e.Col)
+}
+
+// InspectDiff shows both values one under another and marks the first differing char
+func InspectDiff(left, right string) string {
+	l, r := []rune(left), []rune(right)
+	i := 0
+	for i < len(l) && i < len(r) && l[i] == r[i] {
+		i++
+	}
+	diff := fmt.Sprintf("   left:  %s\n   right: %s", left, right)
+	if i == len(l) && i == len(r) {
+		return diff + "\n   (values differ beyond the printed precision)"
+	}
+	return diff + "\n          " + strings.Repeat(" ", i) + "^"
+}
+
+func (e *ExecAstVisitor) setupAssertBuiltinFunctions() {
+	e.testBuiltins[BuiltinAssert] = &ObjBuiltin{
+		Name:       BuiltinAssert,
+		ArgTypes:   ArgTypes{TypeBool},
+		ReturnType: TypeVoid,
+		Fn: func(env *Environment, args []Object) (Object, error) {
+			if !args[0].(*ObjBoolean).Value {
+				return nil, &AssertionError{Msg: "assertion failed"}
+			}
+			return &ObjVoid{}, nil
+		},
+	}
+	e.testBuiltins[BuiltinAssertEq] = &ObjBuiltin{
+		Name:       BuiltinAssertEq,
+		ArgTypes:   ArgTypes{"any", "any"},
+		ReturnType: TypeVoid,
+		Fn: func(env *Environment, args []Object) (Object, error) {
+			left, right := args[0], args[1]
+			if left.Type() != right.Type() {
+				return nil, &AssertionError{
+					Msg:   fmt.Sprintf("assertEq failed: types differ, '%s' and '%s'", left.Type(), right.Type()),
+					Left:  left.Inspect(),
+					Right: right.Inspect(),
+				}
+			}
+			if !objectsEqual(left, right) {
+				return nil, &AssertionError{
+					Msg:   "assertEq failed: values are not equal",
+					Left:  left.Inspect(),
+					Right: right.Inspect(),
+				}
+			}
+			return &ObjVoid{}, nil
+		},
+	}
+}
+
+// objectsEqual compares values deeply, objects should have the same type
+func objectsEqual(a, b Object) bool {
+	switch left := a.(type) {
+	case *ObjInteger:
+		right := b.(*ObjInteger)
+		return left.Empty == right.Empty && left.Value == right.Value
+	case *ObjFloat:
+		right := b.(*ObjFloat)
+		return left.Empty == right.Empty && floatsEqual(left.Value, right.Value)
+	case *ObjBoolean:
+		return left.Value == b.(*ObjBoolean).Value
+	case *ObjVec2:
+		right := b.(*ObjVec2)
+		return left.Empty == right.Empty &&
+			floatsEqual(left.Value.X, right.Value.X) && floatsEqual(left.Value.Y, right.Value.Y)
+	case *ObjEnum:
+		return left.Value == b.(*ObjEnum).Value
+	case *ObjArray:
+		right := b.(*ObjArray)
+		if left.Empty != right.Empty || len(left.Elements) != len(right.Elements) {
+			return false
+		}
+		for i := range left.Elements {
+			if left.Elements[i].Type() != right.Elements[i].Type() ||
+				!objectsEqual(left.Elements[i], right.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *ObjStruct:
+		right := b.(*ObjStruct)
+		if left.Empty != right.Empty || len(left.Fields) != len(right.Fields) {
+			return false
+		}
+		for name, field := range left.Fields {
+			other, ok := right.Fields[name]
+			if !ok || field.Type() != other.Type() || !objectsEqual(field, other) {
+				return false
+			}
+		}
+		return true
+	default:
+		// functions are equal only to themselves
+		return a == b
+	}
+}
+
+func floatsEqual(a, b float64) bool {
+	return math.Abs(a-b) <= floatTolerance*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// Tests returns top level `test` blocks of the program in declaration order
+func Tests(program *AstStatementsBlock) []*AstTest {
+	tests := make([]*AstTest, 0)
+	for _, stmt := range program.Statements {
+		if t, ok := stmt.(*AstTest); ok {
+			tests = append(tests, t)
+		}
+	}
+	return tests
+}
+
+// TestResult is the result of one test, Err is nil if the test passed
+type TestResult struct {
+	Test    *AstTest
+	Err     error
+	Elapsed time.Duration
+}
+
+type TestOptions struct {
+	// Fixtures are applied to the environment of every test, their events are dispatched before the test body
+	Fixtures *Fixtures
+	// Setup is called for the runtime of every test before the program runs, e.g. to set limits or output
+	Setup func(r *Runtime)
+	// Filter selects tests to run by name, all tests run if it's nil
+	Filter func(name string) bool
+}
+
+// RunTests runs tests of the program. Every test gets its own Runtime: fixtures are applied,
+// the program is executed (test blocks are skipped), events of the fixtures are dispatched
+// and then the body of the test runs, so tests don't see changes made by each other.
+func RunTests(program *Program, options TestOptions) []*TestResult {
+	results := make([]*TestResult, 0)
+	for _, test := range program.Tests() {
+		if options.Filter != nil && !options.Filter(test.Name) {
+			continue
+		}
+		start := time.Now()
+		err := runTest(program, test, options)
+		results = append(results, &TestResult{Test: test, Err: err, Elapsed: time.Since(start)})
+	}
+	return results
+}
+
+// runTest turns panic of the interpreter or of a host builtin into the failure of the test,
+// so other tests still run
+func runTest(program *Program, test *AstTest, options TestOptions) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = runtimeError(test, "test '%s' panicked: %v", test.Name, p)
+		}
+	}()
+	r := NewRuntime(program)
+	if options.Setup != nil {
+		options.Setup(r)
+	}
+	if options.Fixtures != nil {
+		if err := options.Fixtures.Apply(r.env); err != nil {
+			return fmt.Errorf("fixtures: %s", err.Error())
+		}
+	}
+	if _, err := r.Run(); err != nil {
+		return err
+	}
+	if options.Fixtures != nil && len(options.Fixtures.Events) > 0 {
+		events, err := options.Fixtures.EventList(r.env)
+		if err != nil {
+			return fmt.Errorf("fixtures: %s", err.Error())
+		}
+		if _, err = r.Dispatch(events); err != nil {
+			return err
+		}
+	}
+	_, err = r.RunTest(test)
+	return err
+}
+
+// RunTest executes the body of the test in its own scope enclosed by the runtime environment,
+// Run should be called before to declare everything the test uses
+func (r *Runtime) RunTest(test *AstTest) (ExecStats, error) {
+	r.executor.beginExecution()
+	defer r.executor.endExecution()
+	if r.executor.debugger != nil {
+		r.executor.debugger.push("test " + test.Name)
+		defer r.executor.debugger.pop()
+	}
+	env := NewEnclosedEnvironment(r.env)
+	// assert builtins are declared in the scope of the test, they shadow variables of the program
+	for name, builtin := range r.executor.testBuiltins {
+		env.Set(name, builtin)
+	}
+	// returned value is ignored, return is used only to stop the test
+	_, err := r.executor.execStatementsBlock(test.StatementsBlock, env)
+	if err == nil {
+		err = checkMemoryLimit(r.env)
+	}
+	return r.executor.Stats(), err
+}
@@ -0,0 +1,167 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"strings"
+	"testing"
+)
+
+const testsProgram = `struct point {
+   int x
+   int y
+}
+counter = point{x = 0, y = 0}
+moved = fn(point p, int dx) point {
+   return point{x = p.x + dx, y = p.y}
+}
+on damaged(int damage) {
+   counter.x = counter.x + damage
+}
+test "move" {
+   assertEq(moved(point{x = 1, y = 2}, 2), point{x = 3, y = 2})
+   counter.y = 5
+}
+test "isolated" {
+   assertEq(counter, point{x = 10, y = 0})
+   assert(mech.hp > 0)
+}
+test "wrong field" {
+   p = moved(point{x = 1, y = 2}, 1)
+   assertEq(p, point{x = 2, y = 3})
+}
+test "false" {
+   assert(1 > 2)
+}
+`
+
+func TestRunTests(t *testing.T) {
+	fixtures, err := LoadFixtures(strings.NewReader(`{
+  "structs": {"mech": {"hp": "int"}},
+  "vars": {"mech": {"type": "mech", "value": {"hp": 100}}},
+  "events": [{"name": "damaged", "args": [{"type": "int", "value": 10}]}]
+}`))
+	require.Nil(t, err)
+	host := NewEnvironment()
+	require.Nil(t, fixtures.Apply(host))
+	program, err := NewProgramWithOptions(testsProgram, ProgramOptions{Env: host})
+	require.Nil(t, err)
+	require.Len(t, program.Tests(), 4)
+
+	// tests are not executed as a part of the program
+	r := NewRuntime(program)
+	_, err = r.Run()
+	require.Nil(t, err)
+
+	results := RunTests(program, TestOptions{Fixtures: fixtures})
+	require.Len(t, results, 4)
+	assert.Nil(t, results[0].Err)
+	assert.Nil(t, results[1].Err, "changes of the previous test are not visible")
+
+	require.IsType(t, &AssertionError{}, results[2].Err)
+	failure := results[2].Err.(*AssertionError)
+	assert.Equal(t, "point{x: 2, y: 2}", failure.Left)
+	assert.Equal(t, "point{x: 2, y: 3}", failure.Right)
+	assert.Equal(t, 22, failure.Line)
+	assert.Equal(t, `assertEq failed: values are not equal
+   left:  point{x: 2, y: 2}
+   right: point{x: 2, y: 3}
+                         ^
+line:22, pos 4`, failure.Error())
+
+	require.NotNil(t, results[3].Err)
+	assert.Equal(t, "assertion failed\nline:25, pos 4", results[3].Err.Error())
+
+	results = RunTests(program, TestOptions{Filter: func(name string) bool { return name == "isolated" }})
+	require.Len(t, results, 1)
+	assert.Contains(t, results[0].Err.Error(), "left:  point{x: 0, y: 0}", "events are dispatched only with fixtures")
+}
+
+func TestRunTestsPanic(t *testing.T) {
+	program, err := NewProgramWithOptions(`test "boom" {
+   boom()
+}
+test "ok" {
+   assert(true)
+}
+`, ProgramOptions{Builtins: map[string]*ObjBuiltin{
+		"boom": {
+			Name:       "boom",
+			ReturnType: TypeVoid,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				panic("host failure")
+			},
+		},
+	}})
+	require.Nil(t, err)
+
+	results := RunTests(program, TestOptions{})
+	require.Len(t, results, 2)
+	require.NotNil(t, results[0].Err)
+	assert.Equal(t, "test 'boom' panicked: host failure\nline:1, pos 1", results[0].Err.Error())
+	assert.Nil(t, results[1].Err, "the rest of tests run after the panic")
+}
+
+func TestAssertEq(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		equal bool
+	}{
+		"ints":             {"assertEq(1, 1)", true},
+		"different ints":   {"assertEq(1, 2)", false},
+		"types":            {"assertEq(1, 1.)", false},
+		"floats tolerance": {"assertEq(0.1 + 0.2, 0.3)", true},
+		"floats":           {"assertEq(0.101, 0.1)", false},
+		"arrays":           {"assertEq([]int{1, 2}, []int{1, 2})", true},
+		"arrays length":    {"assertEq([]int{1, 2}, []int{1})", false},
+		"vec2":             {"assertEq(vec2{x = 1., y = 2.}, vec2{x = 1., y = 2.})", true},
+		"empty":            {"assertEq(?int, 0)", false},
+		"bools":            {"assertEq(true, 1 > 2)", false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			program, err := NewProgram("test \"eq\" {\n   " + test.input + "\n}\n")
+			require.Nil(t, err)
+			results := RunTests(program, TestOptions{})
+			require.Len(t, results, 1)
+			if test.equal {
+				require.Nil(t, results[0].Err)
+			} else {
+				require.IsType(t, &AssertionError{}, results[0].Err)
+			}
+		})
+	}
+}
+
+func TestAssertOnlyInTests(t *testing.T) {
+	_, err := NewProgram("assert(true)\n")
+	require.NotNil(t, err)
+	assert.Equal(t, "identifier not found: assert\nline:1, pos 1", err.Error())
+
+	program, err := NewProgram(`assert = 5
+assertEq = fn(int a) int {
+   return a + assert
+}
+test "shadowed" {
+   assertEq(1, 1)
+   assert(true)
+}
+`)
+	require.Nil(t, err, "assert names are free outside tests")
+	r := NewRuntime(program)
+	_, err = r.Run()
+	require.Nil(t, err)
+	result, err := r.Call("assertEq", &ObjInteger{Value: 1})
+	require.Nil(t, err)
+	assert.Equal(t, "6", result.Inspect())
+
+	results := RunTests(program, TestOptions{})
+	require.Len(t, results, 1)
+	assert.Nil(t, results[0].Err, "assert builtins shadow variables of the program in tests")
+}
+
+func TestInspectDiff(t *testing.T) {
+	assert.Equal(t, "   left:  []int{1, 2}\n   right: []int{1, 3}\n                   ^", InspectDiff("[]int{1, 2}", "[]int{1, 3}"))
+	assert.Equal(t, "   left:  0.10\n   right: 0.10\n   (values differ beyond the printed precision)", InspectDiff("0.10", "0.10"))
+}
@@ -33,6 +33,7 @@ const (
 
 	TokenNumInt   TokenID = "int"
 	TokenNumFloat TokenID = "float"
+	TokenString   TokenID = "string"
 
 	TokenLParen   TokenID = "("
 	TokenRParen   TokenID = ")"
@@ -55,6 +56,9 @@ const (
 	TokenSwitch   TokenID = "switch"
 	TokenCase     TokenID = "case"
 	TokenDefault  TokenID = "default"
+	TokenOn       TokenID = "on"
+	TokenPersist  TokenID = "persist"
+	TokenTest     TokenID = "test"
 
 	// type hints
 	TokenType TokenID = "type"
@@ -87,6 +91,9 @@ var strToKeywordMap = map[string]TokenID{
 	"switch":  TokenSwitch,
 	"case":    TokenCase,
 	"default": TokenDefault,
+	"on":      TokenOn,
+	"persist": TokenPersist,
+	"test":    TokenTest,
 }
 
 func TokensKeywords() map[TokenID]bool {
@@ -103,6 +110,9 @@ func TokensKeywords() map[TokenID]bool {
 		TokenSwitch: true,
 		TokenCase: true,
 		TokenDefault: true,
+		TokenOn: true,
+		TokenPersist: true,
+		TokenTest: true,
 	}
 }
 
@@ -0,0 +1,186 @@
+package fdalang
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// UnmarshalError describes where in the object tree decoding failed, e.g. "commands.cannon.shoot"
+type UnmarshalError struct {
+	Path string
+	Msg  string
+}
+
+func (u *UnmarshalError) Error() string {
+	return fmt.Sprintf("%s: %s", u.Path, u.Msg)
+}
+
+type emptiable interface {
+	IsEmpty() bool
+}
+
+// Decode writes the variable value into Go value, see Unmarshal
+func (e *Environment) Decode(name string, v interface{}) error {
+	obj, ok := e.Get(name)
+	if !ok {
+		return &UnmarshalError{Path: name, Msg: "variable is not defined"}
+	}
+	return unmarshal(obj, v, name)
+}
+
+// Unmarshal writes script object into Go value pointed by v. Structs are matched by the same
+// `fda:"name"` tags as in Bind. Empty values (?type) can be decoded only into pointers and slices,
+// where they become nil.
+func Unmarshal(obj Object, v interface{}) error {
+	return unmarshal(obj, v, string(obj.Type()))
+}
+
+func unmarshal(obj Object, v interface{}, path string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &UnmarshalError{Path: path, Msg: fmt.Sprintf("decode target should be non-nil pointer, got %T", v)}
+	}
+	return decodeObject(obj, rv.Elem(), path)
+}
+
+func isEmptyObject(obj Object) bool {
+	e, ok := obj.(emptiable)
+	return ok && e.IsEmpty()
+}
+
+func decodeObject(obj Object, v reflect.Value, path string) error {
+	t := v.Type()
+	mismatch := func() error {
+		return &UnmarshalError{
+			Path: path,
+			Msg:  fmt.Sprintf("can't decode '%s' into Go type '%s'", obj.Type(), t),
+		}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		if isEmptyObject(obj) {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return decodeObject(obj, v.Elem(), path)
+	}
+	if isEmptyObject(obj) {
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return &UnmarshalError{
+			Path: path,
+			Msg:  fmt.Sprintf("empty '%s' can be decoded only into pointer or slice, got '%s'", obj.Type(), t),
+		}
+	}
+
+	switch {
+	case t == vec2GoType:
+		vec, ok := obj.(*ObjVec2)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.ValueOf(vec.Value))
+	case isGoEnum(t):
+		enum, ok := obj.(*ObjEnum)
+		if !ok || enum.Definition.Name != t.Name() {
+			return mismatch()
+		}
+		if isGoInt(t.Kind()) {
+			v.SetInt(int64(enum.Value))
+		} else {
+			v.SetUint(uint64(enum.Value))
+		}
+	case isGoInt(t.Kind()):
+		integer, ok := obj.(*ObjInteger)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(integer.Value) {
+			return &UnmarshalError{Path: path, Msg: fmt.Sprintf("value %d overflows '%s'", integer.Value, t)}
+		}
+		v.SetInt(integer.Value)
+	case isGoUint(t.Kind()):
+		integer, ok := obj.(*ObjInteger)
+		if !ok {
+			return mismatch()
+		}
+		if integer.Value < 0 || v.OverflowUint(uint64(integer.Value)) {
+			return &UnmarshalError{Path: path, Msg: fmt.Sprintf("value %d overflows '%s'", integer.Value, t)}
+		}
+		v.SetUint(uint64(integer.Value))
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		float, ok := obj.(*ObjFloat)
+		if !ok {
+			return mismatch()
+		}
+		v.SetFloat(float.Value)
+	case t.Kind() == reflect.Bool:
+		boolean, ok := obj.(*ObjBoolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(boolean.Value)
+	case t.Kind() == reflect.Struct:
+		structObj, ok := obj.(*ObjStruct)
+		if !ok {
+			return mismatch()
+		}
+		return decodeStruct(structObj, v, path)
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		array, ok := obj.(*ObjArray)
+		if !ok {
+			return mismatch()
+		}
+		return decodeArray(array, v, path)
+	default:
+		return mismatch()
+	}
+
+	return nil
+}
+
+func decodeStruct(obj *ObjStruct, v reflect.Value, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fieldName, ok := goFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		fieldPath := path + "." + fieldName
+		fieldObj, ok := obj.Fields[fieldName]
+		if !ok {
+			return &UnmarshalError{
+				Path: fieldPath,
+				Msg:  fmt.Sprintf("struct '%s' doesn't have the field", obj.Definition.Name),
+			}
+		}
+		if err := decodeObject(fieldObj, v.Field(i), fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeArray(obj *ObjArray, v reflect.Value, path string) error {
+	if v.Kind() == reflect.Array {
+		if v.Len() != len(obj.Elements) {
+			return &UnmarshalError{
+				Path: path,
+				Msg:  fmt.Sprintf("array length mismatch: Go array has %d, got %d", v.Len(), len(obj.Elements)),
+			}
+		}
+	} else {
+		v.Set(reflect.MakeSlice(v.Type(), len(obj.Elements), len(obj.Elements)))
+	}
+	for i, el := range obj.Elements {
+		if err := decodeObject(el, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package fdalang
+
+import (
+	"fmt"
+	"math"
+)
+
+const TypeVec2 = "vec2"
+
+const (
+	BuiltinVec2Len      = "len"
+	BuiltinVec2Norm     = "norm"
+	BuiltinVec2Dot      = "dot"
+	BuiltinVec2Cross    = "cross"
+	BuiltinVec2AngleTo  = "angleTo"
+	BuiltinVec2Rotate   = "rotate"
+	BuiltinVec2Distance = "distance"
+)
+
+type Vec2 struct {
+	X float64
+	Y float64
+}
+
+func (v Vec2) Add(o Vec2) Vec2         { return Vec2{X: v.X + o.X, Y: v.Y + o.Y} }
+func (v Vec2) Sub(o Vec2) Vec2         { return Vec2{X: v.X - o.X, Y: v.Y - o.Y} }
+func (v Vec2) Mul(k float64) Vec2      { return Vec2{X: v.X * k, Y: v.Y * k} }
+func (v Vec2) Dot(o Vec2) float64      { return v.X*o.X + v.Y*o.Y }
+func (v Vec2) Cross(o Vec2) float64    { return v.X*o.Y - v.Y*o.X }
+func (v Vec2) Len() float64            { return math.Hypot(v.X, v.Y) }
+func (v Vec2) Distance(o Vec2) float64 { return v.Sub(o).Len() }
+
+func (v Vec2) Norm() Vec2 {
+	l := v.Len()
+	if l == 0 {
+		return Vec2{}
+	}
+	return v.Mul(1 / l)
+}
+
+// AngleTo returns signed angle in radians to rotate v onto o, in range [-PI, PI]
+func (v Vec2) AngleTo(o Vec2) float64 {
+	return math.Atan2(v.Cross(o), v.Dot(o))
+}
+
+func (v Vec2) Rotate(angle float64) Vec2 {
+	sin, cos := math.Sincos(angle)
+	return Vec2{X: v.X*cos - v.Y*sin, Y: v.X*sin + v.Y*cos}
+}
+
+type ObjVec2 struct {
+	Emptier
+	Value Vec2
+}
+
+func (v *ObjVec2) Type() ObjectType { return TypeVec2 }
+func (v *ObjVec2) Inspect() string {
+	return fmt.Sprintf("vec2{x: %.2f, y: %.2f}", v.Value.X, v.Value.Y)
+}
+
+// vec2Methods are available only as methods on vec2 values (a.distance(b)), so scripts are free
+// to use their names for variables. First argument is always the receiver.
+func vec2Methods() map[string]*ObjBuiltin {
+	return map[string]*ObjBuiltin{
+		BuiltinVec2Len: {
+			Name:       BuiltinVec2Len,
+			ArgTypes:   ArgTypes{TypeVec2},
+			ReturnType: TypeFloat,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjFloat{Value: args[0].(*ObjVec2).Value.Len()}, nil
+			},
+		},
+		BuiltinVec2Norm: {
+			Name:       BuiltinVec2Norm,
+			ArgTypes:   ArgTypes{TypeVec2},
+			ReturnType: TypeVec2,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjVec2{Value: args[0].(*ObjVec2).Value.Norm()}, nil
+			},
+		},
+		BuiltinVec2Dot: {
+			Name:       BuiltinVec2Dot,
+			ArgTypes:   ArgTypes{TypeVec2, TypeVec2},
+			ReturnType: TypeFloat,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjFloat{Value: args[0].(*ObjVec2).Value.Dot(args[1].(*ObjVec2).Value)}, nil
+			},
+		},
+		BuiltinVec2Cross: {
+			Name:       BuiltinVec2Cross,
+			ArgTypes:   ArgTypes{TypeVec2, TypeVec2},
+			ReturnType: TypeFloat,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjFloat{Value: args[0].(*ObjVec2).Value.Cross(args[1].(*ObjVec2).Value)}, nil
+			},
+		},
+		BuiltinVec2AngleTo: {
+			Name:       BuiltinVec2AngleTo,
+			ArgTypes:   ArgTypes{TypeVec2, TypeVec2},
+			ReturnType: TypeFloat,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjFloat{Value: args[0].(*ObjVec2).Value.AngleTo(args[1].(*ObjVec2).Value)}, nil
+			},
+		},
+		BuiltinVec2Rotate: {
+			Name:       BuiltinVec2Rotate,
+			ArgTypes:   ArgTypes{TypeVec2, TypeFloat},
+			ReturnType: TypeVec2,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjVec2{Value: args[0].(*ObjVec2).Value.Rotate(args[1].(*ObjFloat).Value)}, nil
+			},
+		},
+		BuiltinVec2Distance: {
+			Name:       BuiltinVec2Distance,
+			ArgTypes:   ArgTypes{TypeVec2, TypeVec2},
+			ReturnType: TypeFloat,
+			Fn: func(env *Environment, args []Object) (Object, error) {
+				return &ObjFloat{Value: args[0].(*ObjVec2).Value.Distance(args[1].(*ObjVec2).Value)}, nil
+			},
+		},
+	}
+}
+
+func (e *ExecAstVisitor) setupVec2BuiltinFunctions() {
+	for name, builtin := range vec2Methods() {
+		e.vec2Methods[name] = builtin
+	}
+}
+
+// bindVec2Method returns builtin with receiver already applied as the first argument
+func bindVec2Method(method *ObjBuiltin, receiver *ObjVec2) *ObjBuiltin {
+	return &ObjBuiltin{
+		Name:       method.Name,
+		ArgTypes:   method.ArgTypes[1:],
+		ReturnType: method.ReturnType,
+		Fn: func(env *Environment, args []Object) (Object, error) {
+			return method.Fn(env, append([]Object{receiver}, args...))
+		},
+	}
+}
+
+func (e *ExecAstVisitor) execVec2(node *AstStruct, env *Environment) (Object, error) {
+	coords := make(map[string]float64)
+	for _, n := range node.Fields {
+		if n.Left.Value != "x" && n.Left.Value != "y" {
+			return nil, runtimeError(n, "vec2 doesn't have the field '%s'", n.Left.Value)
+		}
+		result, err := e.execExpression(n.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		floatObj, ok := result.(*ObjFloat)
+		if !ok {
+			return nil, runtimeError(n, "Field '%s' defined as 'float' but '%s' given", n.Left.Value, result.Type())
+		}
+		coords[n.Left.Value] = floatObj.Value
+	}
+	if len(coords) != 2 {
+		return nil, runtimeError(node, "Var of vec2 should have 2 fields filled but in fact only %d", len(coords))
+	}
+
+	return &ObjVec2{Value: Vec2{X: coords["x"], Y: coords["y"]}}, nil
+}
+
+func (e *ExecAstVisitor) execVec2FieldCall(node *AstStructFieldCall, vec *ObjVec2) (Object, error) {
+	switch node.Field.Value {
+	case "x":
+		return &ObjFloat{Value: vec.Value.X}, nil
+	case "y":
+		return &ObjFloat{Value: vec.Value.Y}, nil
+	}
+	if method, ok := e.vec2Methods[node.Field.Value]; ok {
+		return bindVec2Method(method, vec), nil
+	}
+	return nil, runtimeError(node, "vec2 doesn't have field or method '%s'", node.Field.Value)
+}
+
+func isVec2ScalarOperation(left, right Object, operator TokenID) bool {
+	if operator != TokenAsterisk && operator != TokenSlash {
+		return false
+	}
+	if left.Type() == TypeVec2 && right.Type() == TypeFloat {
+		return true
+	}
+	return operator == TokenAsterisk && left.Type() == TypeFloat && right.Type() == TypeVec2
+}
+
+func vec2BinOperation(left, right Object, operator TokenID) (Object, error) {
+	if l, ok := left.(*ObjFloat); ok {
+		return &ObjVec2{Value: right.(*ObjVec2).Value.Mul(l.Value)}, nil
+	}
+	l := left.(*ObjVec2)
+	if r, ok := right.(*ObjFloat); ok {
+		switch operator {
+		case TokenAsterisk:
+			return &ObjVec2{Value: l.Value.Mul(r.Value)}, nil
+		case TokenSlash:
+			return &ObjVec2{Value: l.Value.Mul(1 / r.Value)}, nil
+		}
+		return nil, fmt.Errorf("unsupported operator for types: %s %s %s", left.Type(), operator, right.Type())
+	}
+	r := right.(*ObjVec2)
+	switch operator {
+	case TokenPlus:
+		return &ObjVec2{Value: l.Value.Add(r.Value)}, nil
+	case TokenMinus:
+		return &ObjVec2{Value: l.Value.Sub(r.Value)}, nil
+	case TokenEq:
+		return nativeBooleanToBoolean(l.Value == r.Value), nil
+	case TokenNotEq:
+		return nativeBooleanToBoolean(l.Value != r.Value), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator for types: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
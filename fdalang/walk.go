@@ -0,0 +1,303 @@
+package fdalang
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Visitor is called by Walk for every node. If the returned visitor is not nil, Walk visits
+// children of the node with it and then calls its Visit(nil).
+type Visitor interface {
+	Visit(node AstNode) (w Visitor)
+}
+
+// Walk traverses the tree in depth-first order like go/ast.Walk. Note that the expression of `switch x {...}`
+// is shared by conditions of its cases (`case == 1` is `x == 1`), so the same node is visited once for
+// the switch and once for every case.
+func Walk(node AstNode, v Visitor) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, child := range Children(node) {
+		Walk(child, v)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(AstNode) bool
+
+func (f inspector) Visit(node AstNode) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for every node of the tree in depth-first order, children of the node are skipped
+// if f returns false. After children f is called with nil, like in go/ast.Inspect.
+func Inspect(node AstNode, f func(AstNode) bool) {
+	Walk(node, inspector(f))
+}
+
+// Children returns direct children of the node in the source order, nil branches and values are skipped.
+// Identifiers being declared (names of variables, fields and arguments) are children too.
+func Children(node AstNode) []AstNode {
+	var children []AstNode
+	add := func(nodes ...AstNode) {
+		children = append(children, nodes...)
+	}
+	addBlock := func(block *AstStatementsBlock) {
+		if block != nil {
+			add(block)
+		}
+	}
+	addExpr := func(expr AstExpression) {
+		if expr != nil {
+			add(expr)
+		}
+	}
+	addVars := func(vars []*AstVarAndType) {
+		for _, v := range vars {
+			add(v)
+		}
+	}
+
+	switch n := node.(type) {
+	case *AstStatementsBlock:
+		for _, stmt := range n.Statements {
+			add(stmt)
+		}
+	case *AstStatementWithVoidedExpression:
+		addExpr(n.Expr)
+	case *AstAssignment:
+		add(n.Left)
+		addExpr(n.Value)
+	case *AstStructFieldAssignment:
+		add(n.Left)
+		addExpr(n.Value)
+	case *AstUnary:
+		addExpr(n.Right)
+	case *AstBinOperation:
+		addExpr(n.Left)
+		addExpr(n.Right)
+	case *AstArray:
+		for _, el := range n.Elements {
+			add(el)
+		}
+	case *AstArrayIndexCall:
+		addExpr(n.Left)
+		addExpr(n.Index)
+	case *AstReturn:
+		addExpr(n.ReturnValue)
+	case *AstFunction:
+		addVars(n.Arguments)
+		addBlock(n.StatementsBlock)
+	case *AstVarAndType:
+		add(n.Var)
+	case *AstFunctionCall:
+		addExpr(n.Function)
+		for _, arg := range n.Arguments {
+			add(arg)
+		}
+	case *AstIf:
+		addExpr(n.Condition)
+		addBlock(n.PositiveBranch)
+		addBlock(n.ElseBranch)
+	case *AstStructDefinition:
+		fields := make([]*AstVarAndType, 0, len(n.Fields))
+		for _, field := range n.Fields {
+			fields = append(fields, field)
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Token.Pos < fields[j].Token.Pos })
+		addVars(fields)
+	case *AstStruct:
+		add(n.Ident)
+		for _, field := range n.Fields {
+			add(field)
+		}
+	case *AstStructFieldCall:
+		addExpr(n.StructExpr)
+		add(n.Field)
+	case *AstEnumElementCall:
+		addExpr(n.EnumExpr)
+		add(n.Element)
+	case *AstSwitch:
+		addExpr(n.SwitchExpression)
+		for _, c := range n.Cases {
+			add(c)
+		}
+		addBlock(n.DefaultBranch)
+	case *AstCase:
+		addExpr(n.Condition)
+		addBlock(n.PositiveBranch)
+	case *AstEventHandler:
+		addVars(n.Arguments)
+		addBlock(n.StatementsBlock)
+	case *AstTest:
+		addBlock(n.StatementsBlock)
+	case *AstPersist:
+		if n.Assignment != nil {
+			add(n.Assignment)
+		}
+	case *AstIdentifier, *AstNumInt, *AstNumFloat, *AstBoolean, *AstEmptier, *AstEnumDefinition:
+		// leaves
+	default:
+		panic(fmt.Sprintf("unsupported node %T", node))
+	}
+	return children
+}
+
+// Rewrite replaces nodes of the tree with results of f, it's called for every node after its children
+// are rewritten (so f sees the new children) and returns the node itself if it's not replaced.
+// Replacement must fit the place of the node, e.g. a statement can't replace an expression, otherwise Rewrite panics.
+// The tree is changed in place and its new root is returned. Nodes shared in the tree (the expression of
+// `switch`) are rewritten once and stay shared. AST of Program is shared by runtimes and must not be rewritten.
+func Rewrite(node AstNode, f func(AstNode) AstNode) AstNode {
+	r := &rewriter{f: f, done: make(map[AstNode]AstNode)}
+	return r.rewrite(node)
+}
+
+type rewriter struct {
+	f    func(AstNode) AstNode
+	done map[AstNode]AstNode
+}
+
+func (r *rewriter) rewrite(node AstNode) AstNode {
+	if replaced, ok := r.done[node]; ok {
+		return replaced
+	}
+	r.rewriteChildren(node)
+	replaced := r.f(node)
+	r.done[node] = replaced
+	return replaced
+}
+
+func (r *rewriter) expr(expr AstExpression) AstExpression {
+	if expr == nil {
+		return nil
+	}
+	node := r.rewrite(expr)
+	replaced, ok := node.(AstExpression)
+	if !ok {
+		panic(fmt.Sprintf("%T can't replace expression %T", node, expr))
+	}
+	return replaced
+}
+
+func (r *rewriter) stmt(stmt AstStatement) AstStatement {
+	node := r.rewrite(stmt)
+	replaced, ok := node.(AstStatement)
+	if !ok {
+		panic(fmt.Sprintf("%T can't replace statement %T", node, stmt))
+	}
+	return replaced
+}
+
+func (r *rewriter) block(block *AstStatementsBlock) *AstStatementsBlock {
+	if block == nil {
+		return nil
+	}
+	return r.same(block).(*AstStatementsBlock)
+}
+
+func (r *rewriter) ident(ident *AstIdentifier) *AstIdentifier {
+	return r.same(ident).(*AstIdentifier)
+}
+
+func (r *rewriter) vars(vars []*AstVarAndType) {
+	for i, v := range vars {
+		vars[i] = r.same(v).(*AstVarAndType)
+	}
+}
+
+// same rewrites the node which place needs exactly its type, e.g. the identifier being assigned
+func (r *rewriter) same(node AstNode) AstNode {
+	replaced := r.rewrite(node)
+	if reflect.TypeOf(replaced) != reflect.TypeOf(node) {
+		panic(fmt.Sprintf("%T can't replace %T", replaced, node))
+	}
+	return replaced
+}
+
+func (r *rewriter) rewriteChildren(node AstNode) {
+	switch n := node.(type) {
+	case *AstStatementsBlock:
+		for i, stmt := range n.Statements {
+			n.Statements[i] = r.stmt(stmt)
+		}
+	case *AstStatementWithVoidedExpression:
+		n.Expr = r.expr(n.Expr)
+	case *AstAssignment:
+		n.Left = r.ident(n.Left)
+		n.Value = r.expr(n.Value)
+	case *AstStructFieldAssignment:
+		n.Left = r.same(n.Left).(*AstStructFieldCall)
+		n.Value = r.expr(n.Value)
+	case *AstUnary:
+		n.Right = r.expr(n.Right)
+	case *AstBinOperation:
+		n.Left = r.expr(n.Left)
+		n.Right = r.expr(n.Right)
+	case *AstArray:
+		for i, el := range n.Elements {
+			n.Elements[i] = r.expr(el)
+		}
+	case *AstArrayIndexCall:
+		n.Left = r.expr(n.Left)
+		n.Index = r.expr(n.Index)
+	case *AstReturn:
+		n.ReturnValue = r.expr(n.ReturnValue)
+	case *AstFunction:
+		r.vars(n.Arguments)
+		n.StatementsBlock = r.block(n.StatementsBlock)
+	case *AstVarAndType:
+		n.Var = r.ident(n.Var)
+	case *AstFunctionCall:
+		n.Function = r.expr(n.Function)
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = r.expr(arg)
+		}
+	case *AstIf:
+		n.Condition = r.expr(n.Condition)
+		n.PositiveBranch = r.block(n.PositiveBranch)
+		n.ElseBranch = r.block(n.ElseBranch)
+	case *AstStructDefinition:
+		for name, field := range n.Fields {
+			n.Fields[name] = r.same(field).(*AstVarAndType)
+		}
+	case *AstStruct:
+		n.Ident = r.ident(n.Ident)
+		for i, field := range n.Fields {
+			n.Fields[i] = r.same(field).(*AstAssignment)
+		}
+	case *AstStructFieldCall:
+		n.StructExpr = r.expr(n.StructExpr)
+		n.Field = r.ident(n.Field)
+	case *AstEnumElementCall:
+		n.EnumExpr = r.expr(n.EnumExpr)
+		n.Element = r.ident(n.Element)
+	case *AstSwitch:
+		n.SwitchExpression = r.expr(n.SwitchExpression)
+		for i, c := range n.Cases {
+			n.Cases[i] = r.same(c).(*AstCase)
+		}
+		n.DefaultBranch = r.block(n.DefaultBranch)
+	case *AstCase:
+		n.Condition = r.expr(n.Condition)
+		n.PositiveBranch = r.block(n.PositiveBranch)
+	case *AstEventHandler:
+		r.vars(n.Arguments)
+		n.StatementsBlock = r.block(n.StatementsBlock)
+	case *AstTest:
+		n.StatementsBlock = r.block(n.StatementsBlock)
+	case *AstPersist:
+		if n.Assignment != nil {
+			n.Assignment = r.same(n.Assignment).(*AstAssignment)
+		}
+	case *AstIdentifier, *AstNumInt, *AstNumFloat, *AstBoolean, *AstEmptier, *AstEnumDefinition:
+		// leaves
+	default:
+		panic(fmt.Sprintf("unsupported node %T", node))
+	}
+}
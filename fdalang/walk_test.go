@@ -0,0 +1,182 @@
+package fdalang
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"fmt"
+	"testing"
+)
+
+const walkedSource = `struct point {
+   int y
+   int x
+}
+a = 1 + 2 * 3
+move = fn(point p, int dx) int {
+   if p.x > dx {
+      return p.x - dx
+   }
+   return dx
+}
+switch a + 0 {
+case == 7
+   a = 0
+default
+   a = 1
+}
+`
+
+func parseWalked(t *testing.T) *AstStatementsBlock {
+	program, err := NewParser(NewLexer(walkedSource)).Parse()
+	require.Nil(t, err)
+	return program
+}
+
+func assignmentAt(program *AstStatementsBlock, i int) *AstAssignment {
+	return program.Statements[i].(*AstStatementWithVoidedExpression).Expr.(*AstAssignment)
+}
+
+func nodeNames(nodes []AstNode) []string {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, fmt.Sprintf("%T", node))
+	}
+	return names
+}
+
+func TestChildren(t *testing.T) {
+	program := parseWalked(t)
+	require.Len(t, Children(program), 4)
+
+	definition := program.Statements[0].(*AstStructDefinition)
+	fields := Children(definition)
+	require.Len(t, fields, 2)
+	assert.Equal(t, "y", fields[0].(*AstVarAndType).Var.Value, "fields are in the source order")
+
+	assignment := assignmentAt(program, 1)
+	assert.Equal(t, []string{"*fdalang.AstIdentifier", "*fdalang.AstBinOperation"}, nodeNames(Children(assignment)))
+
+	function := assignmentAt(program, 2).Value
+	assert.Equal(t, []string{"*fdalang.AstVarAndType", "*fdalang.AstVarAndType", "*fdalang.AstStatementsBlock"},
+		nodeNames(Children(function)))
+
+	ifStmt := function.(*AstFunction).StatementsBlock.Statements[0]
+	assert.Equal(t, []string{"*fdalang.AstBinOperation", "*fdalang.AstStatementsBlock"}, nodeNames(Children(ifStmt)),
+		"missing else branch is skipped")
+
+	assert.Empty(t, Children(&AstNumInt{}))
+	assert.Panics(t, func() { Children(nil) })
+}
+
+func TestInspect(t *testing.T) {
+	program := parseWalked(t)
+
+	idents := make([]string, 0)
+	Inspect(program, func(node AstNode) bool {
+		switch n := node.(type) {
+		case *AstFunction:
+			// function bodies are skipped
+			return false
+		case *AstIdentifier:
+			idents = append(idents, n.Value)
+		}
+		return true
+	})
+	assert.Equal(t, []string{"y", "x", "a", "move", "a", "a", "a", "a"}, idents,
+		"switch expression is visited for the switch and for the case")
+
+	visits, leaves := 0, 0
+	Inspect(program, func(node AstNode) bool {
+		if node == nil {
+			leaves++
+		} else {
+			visits++
+		}
+		return true
+	})
+	assert.Equal(t, visits, leaves, "every visited node is closed with nil")
+}
+
+type depthVisitor struct {
+	depth    int
+	maxDepth *int
+}
+
+func (v depthVisitor) Visit(node AstNode) Visitor {
+	if node == nil {
+		return nil
+	}
+	if v.depth > *v.maxDepth {
+		*v.maxDepth = v.depth
+	}
+	return depthVisitor{depth: v.depth + 1, maxDepth: v.maxDepth}
+}
+
+func TestWalk(t *testing.T) {
+	maxDepth := 0
+	Walk(parseWalked(t), depthVisitor{maxDepth: &maxDepth})
+	// block, statement, assignment, function, block, if, block, return, bin operation, struct field call, identifier
+	assert.Equal(t, 10, maxDepth)
+}
+
+// foldConstants replaces operations on int literals with their results
+func foldConstants(node AstNode) AstNode {
+	op, ok := node.(*AstBinOperation)
+	if !ok {
+		return node
+	}
+	left, ok := op.Left.(*AstNumInt)
+	if !ok {
+		return node
+	}
+	right, ok := op.Right.(*AstNumInt)
+	if !ok {
+		return node
+	}
+	switch op.Operator {
+	case TokenPlus:
+		return &AstNumInt{Token: op.Token, Value: left.Value + right.Value}
+	case TokenAsterisk:
+		return &AstNumInt{Token: op.Token, Value: left.Value * right.Value}
+	}
+	return node
+}
+
+func TestRewrite(t *testing.T) {
+	program := parseWalked(t)
+	Rewrite(program, foldConstants)
+
+	value := assignmentAt(program, 1).Value
+	require.IsType(t, &AstNumInt{}, value)
+	assert.Equal(t, int64(7), value.(*AstNumInt).Value)
+
+	switchStmt := program.Statements[3].(*AstSwitch)
+	assert.Same(t, switchStmt.SwitchExpression, switchStmt.Cases[0].Condition.(*AstBinOperation).Left,
+		"shared switch expression stays shared")
+
+	env := NewEnvironment()
+	require.Nil(t, NewExecAstVisitor().ExecAst(program, env))
+	a, _ := env.Get("a")
+	assert.Equal(t, int64(0), a.(*ObjInteger).Value)
+}
+
+func TestRewriteWrongReplacement(t *testing.T) {
+	program := parseWalked(t)
+	assert.Panics(t, func() {
+		Rewrite(program, func(node AstNode) AstNode {
+			if _, ok := node.(*AstNumInt); ok {
+				return &AstReturn{}
+			}
+			return node
+		})
+	})
+	assert.Panics(t, func() {
+		Rewrite(program, func(node AstNode) AstNode {
+			if _, ok := node.(*AstIdentifier); ok {
+				return &AstNumInt{}
+			}
+			return node
+		})
+	}, "declared identifiers can be replaced only by identifiers")
+}
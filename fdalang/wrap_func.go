@@ -0,0 +1,115 @@
+package fdalang
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var (
+	errorGoType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextGoType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// WrapFunc creates builtin from ordinary Go function. Argument and return types are derived
+// from the signature with the same rules as in Bind: int64 -> int, float64 -> float, bool, Vec2,
+// enums, structs (by Go type name) and slices. Function may return nothing, a value, an error
+// or a value and an error. If the first argument is context.Context, the function receives
+// the context of the execution, see ExecAstContext.
+//
+//	builtin, err := fdalang.WrapFunc("angle", func(from, to fdalang.Vec2) float64 {...})
+func WrapFunc(name string, fn interface{}) (*ObjBuiltin, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("%s: expected function, got %T", name, fn)
+	}
+	ft := fv.Type()
+	if ft.IsVariadic() {
+		return nil, fmt.Errorf("%s: variadic functions are not supported", name)
+	}
+
+	firstArg := 0
+	if ft.NumIn() > 0 && ft.In(0) == contextGoType {
+		firstArg = 1
+	}
+	argTypes := make(ArgTypes, 0, ft.NumIn()-firstArg)
+	for i := firstArg; i < ft.NumIn(); i++ {
+		argType, err := goLangType(ft.In(i), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: argument #%d: %s", name, i+1-firstArg, err.Error())
+		}
+		argTypes = append(argTypes, argType)
+	}
+
+	returnType, returnsError, err := wrapFuncReturnType(ft)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+
+	builtin := &ObjBuiltin{
+		Name:       name,
+		ArgTypes:   argTypes,
+		ReturnType: returnType,
+	}
+	call := func(ctx context.Context, env *Environment, args []Object) (Object, error) {
+		in := make([]reflect.Value, firstArg, firstArg+len(args))
+		if firstArg == 1 {
+			in[0] = reflect.ValueOf(&ctx).Elem()
+		}
+		for i, arg := range args {
+			value := reflect.New(ft.In(firstArg + i)).Elem()
+			if err := decodeObject(arg, value, fmt.Sprintf("argument #%d", i+1)); err != nil {
+				return nil, BuiltinFuncError("%s: %s", name, err.Error())
+			}
+			in = append(in, value)
+		}
+
+		out := fv.Call(in)
+		if returnsError {
+			if errValue := out[len(out)-1]; !errValue.IsNil() {
+				return nil, BuiltinFuncError("%s: %s", name, errValue.Interface().(error).Error())
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return &ObjVoid{}, nil
+		}
+
+		// the function could be called in the scope of a script function, definitions of structs and enums
+		// of the result are registered globally to be usable after the call
+		result, err := env.root().goValueToObject(out[0], name)
+		if err != nil {
+			return nil, BuiltinFuncError("%s: %s", name, err.Error())
+		}
+		return result, nil
+	}
+	if firstArg == 1 {
+		builtin.CtxFn = call
+	} else {
+		builtin.Fn = func(env *Environment, args []Object) (Object, error) {
+			return call(context.Background(), env, args)
+		}
+	}
+	return builtin, nil
+}
+
+func wrapFuncReturnType(ft reflect.Type) (string, bool, error) {
+	switch ft.NumOut() {
+	case 0:
+		return TypeVoid, false, nil
+	case 1:
+		if ft.Out(0) == errorGoType {
+			return TypeVoid, true, nil
+		}
+		returnType, err := goLangType(ft.Out(0), nil)
+		return returnType, false, err
+	case 2:
+		if ft.Out(1) != errorGoType {
+			return "", false, fmt.Errorf("second return value should be error, got '%s'", ft.Out(1))
+		}
+		returnType, err := goLangType(ft.Out(0), nil)
+		return returnType, true, err
+	default:
+		return "", false, fmt.Errorf("function should return at most a value and an error")
+	}
+}
@@ -0,0 +1,437 @@
+// Package lsp implements Language Server Protocol server for FDALang programs: diagnostics of syntax
+// and type errors, hover with inferred types, go to definition and completion.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/justclimber/fda-lang/fdalang"
+	"github.com/justclimber/fda-lang/internal/baseprotocol"
+)
+
+const diagnosticSource = "fda"
+
+// Server serves one client over the reader and writer, usually stdin and stdout.
+// All messages are handled in the Serve goroutine one by one.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	executor  *fdalang.ExecAstVisitor
+	env       *fdalang.Environment
+	documents map[string]*document
+}
+
+// document is the opened source and the result of its last check
+type document struct {
+	lines []string
+	info  *fdalang.TypeInfo
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		executor:  fdalang.NewExecAstVisitor(),
+		env:       fdalang.NewEnvironment(),
+		documents: make(map[string]*document),
+	}
+}
+
+// SetHost sets the executor with host builtins and the environment with host values and definitions
+// the programs are checked against, e.g. the game serves its editor with the real API of bots
+func (s *Server) SetHost(executor *fdalang.ExecAstVisitor, env *fdalang.Environment) {
+	s.executor = executor
+	s.env = env
+}
+
+// Serve handles messages until exit notification or the end of input
+func (s *Server) Serve() error {
+	for {
+		content, err := baseprotocol.ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err = json.Unmarshal(content, &msg); err != nil {
+			return fmt.Errorf("invalid message: %s", err.Error())
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, respErr := s.handle(&msg)
+		if msg.ID == nil {
+			// errors of notifications could not be reported
+			continue
+		}
+		if respErr != nil {
+			s.send(errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: *respErr})
+		} else {
+			s.send(response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		var params initializeParams
+		if err := s.params(msg, &params); err != nil {
+			return nil, err
+		}
+		if params.InitializationOptions.Fixtures != "" {
+			if err := s.loadFixtures(params.InitializationOptions.Fixtures); err != nil {
+				return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+			}
+		}
+		return map[string]interface{}{
+			"capabilities": serverCapabilities{
+				TextDocumentSync:   textDocumentSyncFull,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: completionOptions{TriggerCharacters: []string{".", ":"}},
+			},
+			"serverInfo": map[string]string{"name": "fda-lsp"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := s.params(msg, &params); err != nil {
+			return nil, err
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := s.params(msg, &params); err != nil {
+			return nil, err
+		}
+		// full sync: the last change is the whole text
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := s.params(msg, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.publishDiagnostics(params.TextDocument.URI, []diagnostic{})
+		return nil, nil
+	case "textDocument/hover":
+		return s.withPosition(msg, s.hover)
+	case "textDocument/definition":
+		return s.withPosition(msg, s.definition)
+	case "textDocument/completion":
+		return s.withPosition(msg, s.completion)
+	default:
+		return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("unsupported method '%s'", msg.Method)}
+	}
+}
+
+func (s *Server) params(msg *message, params interface{}) *responseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// loadFixtures applies fixtures on top of the host environment
+func (s *Server) loadFixtures(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fixtures, err := fdalang.LoadFixtures(f)
+	if err != nil {
+		return err
+	}
+	env := fdalang.NewEnclosedEnvironment(s.env)
+	if err = fixtures.Apply(env); err != nil {
+		return fmt.Errorf("fixtures: %s", err.Error())
+	}
+	s.env = env
+	return nil
+}
+
+// update parses and checks the new text of the document and publishes found errors
+func (s *Server) update(uri, text string) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	doc := &document{lines: strings.Split(text, "\n")}
+	if !strings.HasSuffix(text, "\n") {
+		// the last statement should be terminated as in files saved by editors
+		text += "\n"
+	}
+	program, parseErrors := fdalang.NewParser(fdalang.NewLexer(text)).ParseWithRecovery()
+	doc.info = s.executor.Check(program, s.env)
+	s.documents[uri] = doc
+
+	diagnostics := make([]diagnostic, 0, len(parseErrors)+len(doc.info.Errors))
+	for _, e := range parseErrors {
+		diagnostics = append(diagnostics, doc.diagnostic(e.Line, e.Col, e.Msg))
+	}
+	for _, e := range doc.info.Errors {
+		diagnostics = append(diagnostics, doc.diagnostic(e.Line, e.Col, e.Msg))
+	}
+	s.publishDiagnostics(uri, diagnostics)
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []diagnostic) {
+	s.send(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+type positionHandler func(uri string, doc *document, line, col int) interface{}
+
+func (s *Server) withPosition(msg *message, handler positionHandler) (interface{}, *responseError) {
+	var params textDocumentPositionParams
+	if err := s.params(msg, &params); err != nil {
+		return nil, err
+	}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "document is not opened"}
+	}
+	line, col := doc.fromPosition(params.Position)
+	return handler(params.TextDocument.URI, doc, line, col), nil
+}
+
+func (s *Server) hover(uri string, doc *document, line, col int) interface{} {
+	ident, sym := doc.info.IdentAt(line, col)
+	if sym == nil {
+		return nil
+	}
+	return hover{
+		Contents: markupContent{Kind: "markdown", Value: "```fda\n" + describe(sym) + "\n```"},
+		Range:    doc.rangeOf(ident.Token.Line, ident.Token.Col, len([]rune(ident.Value))),
+	}
+}
+
+func (s *Server) definition(uri string, doc *document, line, col int) interface{} {
+	_, sym := doc.info.IdentAt(line, col)
+	if sym == nil {
+		// type names in declarations are not identifiers, e.g. 'fn(point p) void' or '?point'
+		if word := doc.wordAt(line, col); word != "" {
+			sym = doc.info.TypeSymbol(word, line)
+		}
+	}
+	if sym == nil || sym.Token.Line == 0 {
+		return nil
+	}
+	return location{
+		URI:   uri,
+		Range: doc.rangeOf(sym.Token.Line, sym.Token.Col, len([]rune(sym.Token.Value))),
+	}
+}
+
+func (s *Server) completion(uri string, doc *document, line, col int) interface{} {
+	items := make([]completionItem, 0)
+	prefix := doc.linePrefix(line, col)
+	// the identifier being typed is filtered by the client
+	prefix = strings.TrimRightFunc(prefix, isIdentRune)
+	if prefix == "" || !strings.HasSuffix(prefix, ".") && !strings.HasSuffix(prefix, ":") {
+		for _, sym := range doc.info.Visible(line) {
+			items = append(items, completionItem{Label: sym.Name, Kind: completionKind(sym, false), Detail: sym.Type})
+		}
+		return items
+	}
+
+	separator := prefix[len(prefix)-1:]
+	receiver := receiverExpression(prefix[:len(prefix)-1])
+	if receiver == "" {
+		return items
+	}
+	expr, err := fdalang.NewParser(fdalang.NewLexer(receiver)).ParseExpression()
+	if err != nil {
+		return items
+	}
+	for _, sym := range doc.info.Members(doc.info.ExprType(expr, line), line) {
+		// enum elements are accessed with ':', fields and methods with '.'
+		if (sym.Kind == fdalang.SymbolEnumElement) == (separator == ":") {
+			items = append(items, completionItem{Label: sym.Name, Kind: completionKind(sym, true), Detail: sym.Type})
+		}
+	}
+	return items
+}
+
+// receiverExpression returns the expression at the end of the text, e.g. 'mech.targets[i]' of 'a = mech.targets[i]'
+func receiverExpression(text string) string {
+	runes := []rune(text)
+	depth := 0
+	i := len(runes)
+	for ; i > 0; i-- {
+		r := runes[i-1]
+		switch {
+		case r == ']' || r == ')':
+			depth++
+		case r == '[' || r == '(':
+			if depth == 0 {
+				return string(runes[i:])
+			}
+			depth--
+		case depth > 0 || isIdentRune(r) || r == '.':
+		default:
+			return string(runes[i:])
+		}
+	}
+	return string(runes[i:])
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// completionKind returns the kind of completion item, builtins completed after '.' are vec2 methods
+func completionKind(sym *fdalang.Symbol, member bool) int {
+	switch sym.Kind {
+	case fdalang.SymbolStruct:
+		return completionStruct
+	case fdalang.SymbolField:
+		return completionField
+	case fdalang.SymbolEnum:
+		return completionEnum
+	case fdalang.SymbolEnumElement:
+		return completionEnumMember
+	case fdalang.SymbolBuiltin:
+		if member {
+			return completionMethod
+		}
+		return completionFunction
+	default:
+		if strings.HasPrefix(sym.Type, "fn(") {
+			return completionFunction
+		}
+		return completionVariable
+	}
+}
+
+// describe returns the declaration of the symbol shown on hover
+func describe(sym *fdalang.Symbol) string {
+	switch sym.Kind {
+	case fdalang.SymbolStruct:
+		var fields []string
+		for name, field := range sym.Members {
+			if field.Kind == fdalang.SymbolField {
+				fields = append(fields, fmt.Sprintf("   %s %s", field.Type, name))
+			}
+		}
+		sort.Strings(fields)
+		return fmt.Sprintf("struct %s {\n%s\n}", sym.Name, strings.Join(fields, "\n"))
+	case fdalang.SymbolEnum:
+		elements := make([]string, 0, len(sym.Members))
+		for name := range sym.Members {
+			elements = append(elements, name)
+		}
+		sort.Strings(elements)
+		return fmt.Sprintf("enum %s {%s}", sym.Name, strings.Join(elements, ", "))
+	case fdalang.SymbolEnumElement:
+		return fmt.Sprintf("%s:%s", sym.Type, sym.Name)
+	case fdalang.SymbolField:
+		return fmt.Sprintf("field %s %s", sym.Name, sym.Type)
+	case fdalang.SymbolBuiltin:
+		return fmt.Sprintf("builtin %s %s", sym.Name, sym.Type)
+	default:
+		return fmt.Sprintf("%s %s", sym.Name, sym.Type)
+	}
+}
+
+func (s *Server) send(msg interface{}) {
+	// client is gone if writing fails, nothing could be reported
+	_ = baseprotocol.WriteMessage(s.out, msg)
+}
+
+// diagnostic returns the error highlighting the word it's found at
+func (d *document) diagnostic(line, col int, msg string) diagnostic {
+	length := len([]rune(d.wordAt(line, col)))
+	if length == 0 {
+		length = 1
+	}
+	return diagnostic{
+		Range:    d.rangeOf(line, col, length),
+		Severity: severityError,
+		Source:   diagnosticSource,
+		Message:  strings.TrimSpace(msg),
+	}
+}
+
+// line returns runes of the line, lines and columns of the source are one based
+func (d *document) line(line int) []rune {
+	if line < 1 || line > len(d.lines) {
+		return nil
+	}
+	return []rune(d.lines[line-1])
+}
+
+func (d *document) linePrefix(line, col int) string {
+	runes := d.line(line)
+	if col-1 > len(runes) {
+		col = len(runes) + 1
+	}
+	return string(runes[:col-1])
+}
+
+// wordAt returns the identifier or keyword starting at or covering the column
+func (d *document) wordAt(line, col int) string {
+	runes := d.line(line)
+	if col < 1 || col > len(runes) || !isIdentRune(runes[col-1]) {
+		return ""
+	}
+	start, end := col-1, col-1
+	for start > 0 && isIdentRune(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && isIdentRune(runes[end]) {
+		end++
+	}
+	return string(runes[start:end])
+}
+
+func (d *document) rangeOf(line, col, length int) sourceRange {
+	return sourceRange{Start: d.toPosition(line, col), End: d.toPosition(line, col+length)}
+}
+
+// toPosition converts one based line and column in runes to the protocol position
+func (d *document) toPosition(line, col int) position {
+	runes := d.line(line)
+	if col-1 > len(runes) {
+		col = len(runes) + 1
+	}
+	if col < 1 {
+		col = 1
+	}
+	if line < 1 {
+		line = 1
+	}
+	return position{Line: line - 1, Character: len(utf16.Encode(runes[:col-1]))}
+}
+
+func (d *document) fromPosition(p position) (int, int) {
+	runes := d.line(p.Line + 1)
+	units := 0
+	col := 1
+	for _, r := range runes {
+		if units >= p.Character {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+		col++
+	}
+	return p.Line + 1, col
+}
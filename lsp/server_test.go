@@ -0,0 +1,234 @@
+package lsp
+
+import (
+	"github.com/justclimber/fda-lang/internal/baseprotocol"
+	"github.com/stretchr/testify/require"
+
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testURI = "file:///bot.fda"
+
+const testProgram = `struct target {
+   vec2 pos
+   float hp
+}
+enum state {idle, attack}
+s = state:idle
+closest = fn([]target targets) target {
+   return targets[0]
+}
+t = closest([]target{target{pos = mech.pos, hp = mech.hp}})
+d = t.pos.distance(mech.pos)
+`
+
+const testFixtures = `{
+  "structs": {"Mech": {"pos": "vec2", "hp": "float"}},
+  "vars": {"mech": {"type": "Mech", "value": {"pos": {"x": 0, "y": 0}, "hp": 10}}}
+}`
+
+type testClient struct {
+	t        *testing.T
+	in       io.WriteCloser
+	messages chan map[string]interface{}
+	id       int
+	// pending are messages read while waiting for other ones
+	pending []map[string]interface{}
+}
+
+func newTestClient(t *testing.T) (*testClient, chan error) {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	c := &testClient{t: t, in: clientOut, messages: make(chan map[string]interface{}, 100)}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- NewServer(serverIn, serverOut).Serve()
+		_ = serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			content, err := baseprotocol.ReadMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg map[string]interface{}
+			if err = json.Unmarshal(content, &msg); err != nil {
+				panic(err)
+			}
+			c.messages <- msg
+		}
+	}()
+	return c, served
+}
+
+func (c *testClient) request(method string, params interface{}) map[string]interface{} {
+	c.id++
+	id := float64(c.id)
+	require.Nil(c.t, baseprotocol.WriteMessage(c.in, map[string]interface{}{
+		"jsonrpc": "2.0", "id": c.id, "method": method, "params": params,
+	}))
+	return c.wait(func(msg map[string]interface{}) bool {
+		return msg["id"] == id
+	})
+}
+
+func (c *testClient) result(method string, params interface{}) interface{} {
+	resp := c.request(method, params)
+	require.Nil(c.t, resp["error"])
+	return resp["result"]
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	require.Nil(c.t, baseprotocol.WriteMessage(c.in, map[string]interface{}{
+		"jsonrpc": "2.0", "method": method, "params": params,
+	}))
+}
+
+func (c *testClient) diagnostics() []interface{} {
+	msg := c.wait(func(msg map[string]interface{}) bool {
+		return msg["method"] == "textDocument/publishDiagnostics"
+	})
+	params := msg["params"].(map[string]interface{})
+	require.Equal(c.t, testURI, params["uri"])
+	return params["diagnostics"].([]interface{})
+}
+
+func (c *testClient) wait(match func(msg map[string]interface{}) bool) map[string]interface{} {
+	for i, msg := range c.pending {
+		if match(msg) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return msg
+		}
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			require.True(c.t, ok, "server closed the connection")
+			if match(msg) {
+				return msg
+			}
+			c.pending = append(c.pending, msg)
+		case <-timeout:
+			c.t.Fatalf("timeout, pending messages: %v", c.pending)
+		}
+	}
+}
+
+func (c *testClient) completion(line, character int) map[string]float64 {
+	items := c.result("textDocument/completion", positionParams(line, character)).([]interface{})
+	labels := make(map[string]float64)
+	for _, i := range items {
+		item := i.(map[string]interface{})
+		labels[item["label"].(string)] = item["kind"].(float64)
+	}
+	return labels
+}
+
+func positionParams(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+}
+
+func change(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": testURI},
+		"contentChanges": []interface{}{map[string]interface{}{"text": text}},
+	}
+}
+
+func TestLanguageServer(t *testing.T) {
+	fixtures := filepath.Join(t.TempDir(), "fixtures.json")
+	require.Nil(t, ioutil.WriteFile(fixtures, []byte(testFixtures), 0644))
+	c, served := newTestClient(t)
+
+	initialized := c.result("initialize", map[string]interface{}{
+		"initializationOptions": map[string]interface{}{"fixtures": fixtures},
+	}).(map[string]interface{})
+	caps := initialized["capabilities"].(map[string]interface{})
+	require.Equal(t, true, caps["hoverProvider"])
+	c.notify("initialized", nil)
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "languageId": "fda", "version": 1, "text": testProgram},
+	})
+	require.Empty(t, c.diagnostics())
+
+	hover := c.result("textDocument/hover", positionParams(9, 0)).(map[string]interface{})
+	contents := hover["contents"].(map[string]interface{})
+	require.Equal(t, "```fda\nt target\n```", contents["value"])
+
+	definition := c.result("textDocument/definition", positionParams(10, 4)).(map[string]interface{})
+	start := definition["range"].(map[string]interface{})["start"].(map[string]interface{})
+	require.Equal(t, float64(9), start["line"])
+	require.Equal(t, float64(0), start["character"])
+
+	// type names in declarations lead to their definitions
+	definition = c.result("textDocument/definition", positionParams(6, 33)).(map[string]interface{})
+	start = definition["range"].(map[string]interface{})["start"].(map[string]interface{})
+	require.Equal(t, float64(0), start["line"])
+
+	c.notify("textDocument/didChange", change(testProgram+"a = t.\nb = state:\nc = \n"))
+	diagnostics := c.diagnostics()
+	require.NotEmpty(t, diagnostics)
+	require.Equal(t, float64(11), diagnostics[0].(map[string]interface{})["range"].(map[string]interface{})["start"].(map[string]interface{})["line"])
+
+	require.Equal(t, map[string]float64{"pos": completionField, "hp": completionField}, c.completion(11, 6))
+	require.Equal(t, map[string]float64{"idle": completionEnumMember, "attack": completionEnumMember}, c.completion(12, 10))
+	vec2Members := c.completion(10, 10)
+	require.Equal(t, float64(completionMethod), vec2Members["distance"])
+	require.Equal(t, float64(completionField), vec2Members["x"])
+
+	visible := c.completion(13, 4)
+	require.Equal(t, float64(completionVariable), visible["mech"])
+	require.Equal(t, float64(completionFunction), visible["closest"])
+	require.Equal(t, float64(completionStruct), visible["target"])
+	require.Equal(t, float64(completionEnum), visible["state"])
+	require.Equal(t, float64(completionFunction), visible["print"])
+
+	resp := c.request("textDocument/references", positionParams(0, 0))
+	require.Equal(t, float64(codeMethodNotFound), resp["error"].(map[string]interface{})["code"])
+
+	c.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+	})
+	require.Empty(t, c.diagnostics())
+	resp = c.request("textDocument/hover", positionParams(0, 0))
+	require.NotNil(t, resp["error"], "document is closed")
+
+	c.result("shutdown", nil)
+	c.notify("exit", nil)
+	require.Nil(t, <-served)
+}
+
+func TestLanguageServerDiagnostics(t *testing.T) {
+	c, served := newTestClient(t)
+	c.result("initialize", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "text": "a = 1\r\nb = \r\nc = a + 1.\r\nd = ünknown"},
+	})
+	diagnostics := c.diagnostics()
+	require.Len(t, diagnostics, 3)
+	lines := make([]float64, 0)
+	for _, d := range diagnostics {
+		diagnostic := d.(map[string]interface{})
+		require.Equal(t, "fda", diagnostic["source"])
+		lines = append(lines, diagnostic["range"].(map[string]interface{})["start"].(map[string]interface{})["line"].(float64))
+	}
+	require.Equal(t, []float64{1, 2, 3}, lines)
+
+	c.notify("exit", nil)
+	require.Nil(t, <-served)
+}
@@ -2,26 +2,79 @@ package main
 
 import (
 	"fmt"
-	"github.com/justclimber/fda-lang/fdalang"
-	"io/ioutil"
-	"log"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
 )
 
+// Exit codes of the commands
+const (
+	exitOK      = 0
+	exitFailure = 1 // the program has errors: syntax, type or runtime, or tests failed
+	exitUsage   = 2 // wrong arguments or unreadable files
+)
+
+type command struct {
+	args        string
+	description string
+	run         func(c *cli, args []string) int
+}
+
+var commands = map[string]command{
+	"run":    {"[flags] [file]", "execute the program", runCommand},
+	"check":  {"[flags] [file]", "check syntax and types without execution", checkCommand},
+	"lint":   {"[flags] [path...]", "report suspicious code, e.g. unused variables or missing returns", lintCommand},
+	"tokens": {"[file]", "print tokens of the lexer", tokensCommand},
+	"ast":    {"[-json] [file]", "print the syntax tree", astCommand},
+	"fmt":    {"[-w] [-l] [file...]", "format sources", fmtCommand},
+	"test":   {"[flags] [path...]", "run tests of the programs and report failed ones", testCommand},
+	"dap":    {"", "serve Debug Adapter Protocol on stdin/stdout", dapCommand},
+	"lsp":    {"", "serve Language Server Protocol on stdin/stdout", lspCommand},
+	"repl":   {"[flags]", "interactive interpreter keeping the environment between inputs", replCommand},
+}
+
+// cli is the environment of the command, it's replaced in tests
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
 func main() {
-	sourceCode, _ := ioutil.ReadFile("example/example1")
-	fmt.Printf("Running source code:\n%s\n", string(sourceCode))
-	l := fdalang.NewLexer(string(sourceCode))
-	p := fdalang.NewParser(l)
-
-	astProgram, err := p.Parse()
-	if err != nil {
-		log.Fatalf("Parsing error: %s\n", err.Error())
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	os.Exit(c.run(os.Args[1:]))
+}
+
+func (c *cli) run(args []string) int {
+	if len(args) == 0 {
+		c.usage()
+		return exitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.usage()
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "fda: unknown command '%s'\n", args[0])
+		c.usage()
+		return exitUsage
+	}
+	return cmd.run(c, args[1:])
+}
+
+func (c *cli) usage() {
+	fmt.Fprintln(c.stderr, "Usage: fda <command> [arguments]\n\nFile '-' or no file means stdin.\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
 	}
-	env := fdalang.NewEnvironment()
-	fmt.Println("Program output:")
-	err = fdalang.NewExecAstVisitor().ExecAst(astProgram, env)
-	if err != nil {
-		log.Fatalf("Runtime error: %s\n", err.Error())
+	sort.Strings(names)
+	w := tabwriter.NewWriter(c.stderr, 0, 0, 3, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s %s\t%s\n", name, commands[name].args, commands[name].description)
 	}
-	env.Print()
+	_ = w.Flush()
+	fmt.Fprintln(c.stderr, "\nRun 'fda <command> -h' for flags of the command.")
 }
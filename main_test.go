@@ -0,0 +1,279 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCli(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	c := &cli{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr}
+	code := c.run(args)
+	return code, stdout.String(), stderr.String()
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+const factorial = `f = fn(int n) int {
+   if n < 2 {
+      return 1
+   }
+   return n * f(n - 1)
+}
+r = f(5)
+print(r)`
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "fact.fda", factorial)
+
+	code, stdout, _ := runCli("", "run", "-json", path)
+	require.Equal(t, exitOK, code)
+	require.Equal(t, "120\n{\"f\":\"function\",\"r\":\"120\"}\n", stdout)
+
+	code, stdout, _ = runCli(factorial, "run")
+	require.Equal(t, exitOK, code)
+	require.Equal(t, "120\n", stdout)
+
+	code, _, stderr := runCli("", "run", "-depth", "3", path)
+	require.Equal(t, exitFailure, code)
+	require.Equal(t, path+":5:16: call depth limit exceeded: 3 nested calls\n", stderr)
+
+	code, _, stderr = runCli("", "run", "-budget", "5", path)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, stderr, "operations limit exceeded")
+
+	code, _, stderr = runCli("a = \n", "run", "-")
+	require.Equal(t, exitFailure, code)
+	require.True(t, strings.HasPrefix(stderr, "<stdin>:1:"), stderr)
+
+	code, _, _ = runCli("", "run", filepath.Join(dir, "missing.fda"))
+	require.Equal(t, exitUsage, code)
+	code, _, _ = runCli("", "run", "-unknown", path)
+	require.Equal(t, exitUsage, code)
+}
+
+func TestRunCommandFixtures(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "bot.fda", `on damaged(int amount) {
+   mech.hp = mech.hp - amount
+   print(mech.hp)
+}
+print(randInt(1, 1000))
+`)
+	fixtures := writeFile(t, dir, "fixtures.json", `{
+  "structs": {"mech": {"hp": "int"}},
+  "vars": {"mech": {"type": "mech", "value": {"hp": 10}}},
+  "events": [{"name": "damaged", "args": [{"type": "int", "value": 3}]}]
+}`)
+
+	code, first, stderr := runCli("", "run", "-fixtures", fixtures, "-seed", "42", path)
+	require.Equal(t, exitOK, code, stderr)
+	require.True(t, strings.HasSuffix(first, "\n7\n"), first)
+	_, second, _ := runCli("", "run", "-fixtures", fixtures, "-seed", "42", path)
+	require.Equal(t, first, second, "the same seed gives the same output")
+}
+
+func TestCheckCommand(t *testing.T) {
+	code, _, stderr := runCli("a = 1\nb = a + 1.\nc = \n", "check")
+	require.Equal(t, exitFailure, code)
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, "<stdin>:2:7: forbidden operation on different types: int and float", lines[0])
+	require.True(t, strings.HasPrefix(lines[1], "<stdin>:3:"), lines[1])
+
+	code, _, stderr = runCli(factorial, "check")
+	require.Equal(t, exitOK, code)
+	require.Empty(t, stderr)
+}
+
+func TestTokensAndAstCommands(t *testing.T) {
+	code, stdout, _ := runCli("a = 1", "tokens")
+	require.Equal(t, exitOK, code)
+	require.Equal(t, "1:1\tident\t\"a\"\n1:3\t=\t\"=\"\n1:5\tint\t\"1\"\n1:6\tenf of line\t\"\"\n", stdout)
+
+	code, _, _ = runCli("a = $", "tokens")
+	require.Equal(t, exitFailure, code)
+
+	code, stdout, _ = runCli("a = 1", "ast")
+	require.Equal(t, exitOK, code)
+	require.Contains(t, stdout, "*AstNumInt {")
+
+	code, stdout, _ = runCli("a = 1", "ast", "-json")
+	require.Equal(t, exitOK, code)
+	require.True(t, strings.HasPrefix(stdout, `{"kind":"StatementsBlock","statements":[`))
+}
+
+func TestFmtCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "a.fda", "a=1")
+
+	code, stdout, _ := runCli("", "fmt", "-l", path)
+	require.Equal(t, exitOK, code)
+	require.Equal(t, path+"\n", stdout)
+
+	code, _, _ = runCli("", "fmt", "-w", path)
+	require.Equal(t, exitOK, code)
+	content, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "a = 1\n", string(content))
+
+	code, stdout, _ = runCli("b  =  2\n", "fmt")
+	require.Equal(t, exitOK, code)
+	require.Equal(t, "b = 2\n", stdout)
+}
+
+func TestTestCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "ok.fda", factorial)
+	writeFile(t, dir, "fail.fda", "a = 1 + true\n")
+	writeFile(t, dir, "readme.txt", "not a program")
+
+	code, stdout, stderr := runCli("", "test", dir)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, stdout, "FAIL\t"+filepath.Join(dir, "fail.fda"))
+	require.Contains(t, stdout, "ok\t"+filepath.Join(dir, "ok.fda"))
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		require.Regexp(t, "^(ok|FAIL)\t", line, "output of programs is printed only with -v")
+	}
+	require.Contains(t, stderr, "fail.fda:1:")
+}
+
+const botTests = `heal = fn(int hp) int {
+   return hp + 10
+}
+test "heal" {
+   assertEq(heal(mech.hp), 60)
+}
+test "broken" {
+   assertEq(heal(1), 12)
+}
+`
+
+func TestTestCommandTests(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "bot.fda", botTests)
+	fixtures := writeFile(t, dir, "fixtures.json", `{
+  "structs": {"mech": {"hp": "int"}},
+  "vars": {"mech": {"type": "mech", "value": {"hp": 50}}}
+}`)
+
+	code, stdout, stderr := runCli("", "test", "-v", "-fixtures", fixtures, path)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, stdout, "--- PASS: heal (")
+	require.Contains(t, stdout, "--- FAIL: broken (")
+	require.Contains(t, stdout, "FAIL\t"+path)
+	require.Contains(t, stderr, path+":8:4: assertEq failed: values are not equal\n   left:  11\n   right: 12\n")
+
+	code, stdout, _ = runCli("", "test", "-fixtures", fixtures, "-run", "^heal$", path)
+	require.Equal(t, exitOK, code)
+	require.NotContains(t, stdout, "broken")
+	require.Contains(t, stdout, "ok\t"+path)
+}
+
+func TestLintCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "bot.fda", `speed = mech.speed
+if speed == 0. {
+   stopped = true
+}
+`)
+	fixtures := writeFile(t, dir, "fixtures.json", `{
+  "structs": {"mech": {"speed": "float"}},
+  "vars": {"mech": {"type": "mech", "value": {"speed": 1.5}}}
+}`)
+
+	code, stdout, _ := runCli("", "lint", "-fixtures", fixtures, dir)
+	require.Equal(t, exitFailure, code)
+	require.Equal(t, path+":2:10: floats are compared with '==', compare the difference with a tolerance instead (float-equality)\n"+
+		path+":3:4: 'stopped' is assigned but never read (unused-var)\n", stdout)
+
+	code, stdout, _ = runCli("", "lint", "-fixtures", fixtures, "-disable", "float-equality", "-json", path)
+	require.Equal(t, exitFailure, code)
+	var issues []map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(stdout), &issues))
+	require.Equal(t, []map[string]interface{}{
+		{"file": path, "rule": "unused-var", "message": "'stopped' is assigned but never read", "line": 3., "col": 4.},
+	}, issues)
+
+	code, _, _ = runCli("", "lint", "-fixtures", fixtures, "-enable", "unreachable", path)
+	require.Equal(t, exitOK, code)
+
+	code, _, stderr := runCli("", "lint", "-enable", "style", path)
+	require.Equal(t, exitUsage, code)
+	require.Contains(t, stderr, "unknown lint rule 'style'")
+
+	code, stdout, _ = runCli("", "lint", "-rules")
+	require.Equal(t, exitOK, code)
+	require.Contains(t, stdout, "missing-return ")
+}
+
+func TestTestCommandCoverage(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "bot.fda", `clamp = fn(int hp) int {
+   if hp > 100 {
+      return 100
+   }
+   return hp
+}
+test "clamp" {
+   assertEq(clamp(50), 50)
+}
+`)
+	profile := filepath.Join(dir, "cover.out")
+	report := filepath.Join(dir, "cover.html")
+
+	code, stdout, _ := runCli("", "test", "-cover", "-coverprofile", profile, "-coverreport", report, path)
+	require.Equal(t, exitOK, code)
+	require.Contains(t, stdout, "coverage: 75.0% of statements, 50.0% of branches")
+
+	content, err := ioutil.ReadFile(profile)
+	require.Nil(t, err)
+	require.Contains(t, string(content), "mode: count\n"+path+":1.1,2.4 1 1\n")
+	content, err = ioutil.ReadFile(report)
+	require.Nil(t, err)
+	require.Contains(t, string(content), `<span class="uncovered" title="0">      return 100</span>`)
+
+	writeFile(t, dir, "bot.fda", `clamp = fn(int hp) int {
+   if hp > 100 {
+      return 100
+   }
+   return hp
+}
+test "clamp" {
+   assertEq(clamp(50), 51)
+}
+`)
+	code, stdout, _ = runCli("", "test", "-cover", path)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, stdout, "FAIL\t"+path+"\tcoverage: 75.0% of statements, 50.0% of branches\n")
+}
+
+func TestReplCommand(t *testing.T) {
+	code, stdout, _ := runCli("a = 2\nif a > 1 {\n   a = a * 10\n}\na\n", "repl", "-seed", "1")
+	require.Equal(t, exitOK, code)
+	require.Equal(t, "20\n", stdout)
+
+	code, _, _ = runCli("", "repl", "file.fda")
+	require.Equal(t, exitUsage, code)
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCli("")
+	require.Equal(t, exitUsage, code)
+	require.Contains(t, stderr, "Usage: fda <command>")
+
+	code, _, stderr = runCli("", "nope")
+	require.Equal(t, exitUsage, code)
+	require.Contains(t, stderr, "unknown command 'nope'")
+}
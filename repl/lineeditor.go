@@ -0,0 +1,321 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by ReadLine on Ctrl-C, the input being typed is dropped
+var errInterrupted = errors.New("interrupted")
+
+const maxHistory = 1000
+
+type lineReader interface {
+	// ReadLine returns the line without the newline or io.EOF
+	ReadLine(prompt string) (string, error)
+	AddHistory(line string)
+	// Close restores the input, e.g. the mode of the terminal, ReadLine could be called again after it
+	Close()
+}
+
+// plainReader reads lines as is, it's used when the input is not a terminal, e.g. a pipe.
+// Prompts are not printed, so the output contains only results.
+type plainReader struct {
+	scanner *bufio.Scanner
+}
+
+func newPlainReader(in io.Reader) *plainReader {
+	return &plainReader{scanner: bufio.NewScanner(in)}
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return strings.TrimRight(r.scanner.Text(), "\r"), nil
+}
+
+func (r *plainReader) AddHistory(line string) {}
+
+func (r *plainReader) Close() {}
+
+// lineEditor edits the line in the terminal switched to raw mode: arrows, Home/End, Backspace/Delete,
+// Ctrl-A/E/B/F/K/U/W, history with Up/Down. Terminal is switched to raw mode by the first ReadLine
+// and stays in it until Close or a termination signal. Output processing is kept on, so output
+// of the program is printed as usual.
+type lineEditor struct {
+	in      *os.File
+	reader  *bufio.Reader
+	out     io.Writer
+	history []string
+	// historyFile is appended with every added line, empty means no persistent history
+	historyFile string
+	// state is the mode of the terminal to restore, empty while the terminal is not in raw mode
+	state   string
+	signals chan os.Signal
+
+	prompt string
+	line   []rune
+	cursor int
+}
+
+// newLineEditor returns nil if the input is not a terminal or raw mode is not supported, e.g. there is no stty
+func newLineEditor(in *os.File, out io.Writer, historyFile string) *lineEditor {
+	info, err := in.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	if _, err = exec.LookPath("stty"); err != nil {
+		return nil
+	}
+	if _, err = stty(in, "-g"); err != nil {
+		return nil
+	}
+	e := &lineEditor{in: in, reader: bufio.NewReader(in), out: out, historyFile: historyFile}
+	e.loadHistory()
+	return e
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// enterRaw switches the terminal to raw mode once, the mode is restored by Close or on termination signal
+func (e *lineEditor) enterRaw() error {
+	if e.state != "" {
+		return nil
+	}
+	state, err := stty(e.in, "-g")
+	if err != nil {
+		return err
+	}
+	if _, err = stty(e.in, "raw", "-echo", "opost"); err != nil {
+		return err
+	}
+	e.state = state
+	e.signals = make(chan os.Signal, 1)
+	signal.Notify(e.signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func(signals chan os.Signal) {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		_, _ = stty(e.in, state)
+		// the signal is raised again to terminate the process the way it would be without the editor
+		signal.Reset(sig)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			_ = p.Signal(sig)
+		}
+	}(e.signals)
+	return nil
+}
+
+func (e *lineEditor) Close() {
+	if e.state == "" {
+		return
+	}
+	signal.Stop(e.signals)
+	close(e.signals)
+	_, _ = stty(e.in, e.state)
+	e.state = ""
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	content, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *lineEditor) AddHistory(line string) {
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.historyFile == "" {
+		return
+	}
+	// history is not important enough to report errors
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	_, _ = f.WriteString(line + "\n")
+	_ = f.Close()
+}
+
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	if err := e.enterRaw(); err != nil {
+		return "", err
+	}
+
+	e.prompt = prompt
+	e.line = e.line[:0]
+	e.cursor = 0
+	// the line being typed is kept when history is browsed
+	historyPos := len(e.history)
+	var typed []rune
+	e.refresh()
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete()
+		case 127, 8: // Backspace
+			if e.cursor > 0 {
+				e.cursor--
+				e.delete()
+			}
+		case 1: // Ctrl-A
+			e.cursor = 0
+		case 5: // Ctrl-E
+			e.cursor = len(e.line)
+		case 2: // Ctrl-B
+			e.move(-1)
+		case 6: // Ctrl-F
+			e.move(1)
+		case 11: // Ctrl-K
+			e.line = e.line[:e.cursor]
+		case 21: // Ctrl-U
+			e.line = append([]rune{}, e.line[e.cursor:]...)
+			e.cursor = 0
+		case 23: // Ctrl-W
+			start := e.cursor
+			for start > 0 && unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+				start--
+			}
+			e.line = append(e.line[:start], e.line[e.cursor:]...)
+			e.cursor = start
+		case 27: // escape sequence
+			switch e.escapeSequence() {
+			case "[A", "OA": // Up
+				if historyPos > 0 {
+					if historyPos == len(e.history) {
+						typed = append([]rune{}, e.line...)
+					}
+					historyPos--
+					e.setLine([]rune(e.history[historyPos]))
+				}
+			case "[B", "OB": // Down
+				if historyPos < len(e.history) {
+					historyPos++
+					if historyPos == len(e.history) {
+						e.setLine(typed)
+					} else {
+						e.setLine([]rune(e.history[historyPos]))
+					}
+				}
+			case "[C", "OC":
+				e.move(1)
+			case "[D", "OD":
+				e.move(-1)
+			case "[H", "OH", "[1~", "[7~":
+				e.cursor = 0
+			case "[F", "OF", "[4~", "[8~":
+				e.cursor = len(e.line)
+			case "[3~": // Delete
+				e.delete()
+			}
+		default:
+			if r == utf8.RuneError || unicode.IsControl(r) {
+				continue
+			}
+			e.line = append(e.line, 0)
+			copy(e.line[e.cursor+1:], e.line[e.cursor:])
+			e.line[e.cursor] = r
+			e.cursor++
+		}
+		e.refresh()
+	}
+}
+
+// escapeSequence reads the rest of the sequence after ESC, e.g. "[A" of the Up arrow
+func (e *lineEditor) escapeSequence() string {
+	first, _, err := e.reader.ReadRune()
+	if err != nil || first != '[' && first != 'O' {
+		return ""
+	}
+	seq := []rune{first}
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		// parameters are digits and ';', the sequence ends with any other char
+		if !unicode.IsDigit(r) && r != ';' {
+			return string(seq)
+		}
+	}
+}
+
+func (e *lineEditor) setLine(line []rune) {
+	e.line = append(e.line[:0], line...)
+	e.cursor = len(e.line)
+}
+
+func (e *lineEditor) move(delta int) {
+	if pos := e.cursor + delta; pos >= 0 && pos <= len(e.line) {
+		e.cursor = pos
+	}
+}
+
+// delete removes the char at the cursor
+func (e *lineEditor) delete() {
+	if e.cursor < len(e.line) {
+		e.line = append(e.line[:e.cursor], e.line[e.cursor+1:]...)
+	}
+}
+
+// refresh redraws the line and places the cursor, chars are assumed to be one column wide
+func (e *lineEditor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.line))
+	if back := len(e.line) - e.cursor; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
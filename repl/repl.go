@@ -0,0 +1,268 @@
+// Package repl implements the interactive interpreter: inputs are executed one by one in the same environment,
+// so variables, functions and definitions of previous inputs are available in the next ones.
+package repl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/justclimber/fda-lang/fdalang"
+)
+
+const (
+	prompt         = "fda> "
+	continuePrompt = "...  "
+	historyFile    = ".fda_history"
+)
+
+const help = `Enter statements or expressions, values of expressions are printed.
+Input is executed when all its brackets are closed.
+
+Commands:
+  :type <expr>   print the type of the expression
+  :env           print variables of the environment
+  :reset         clear the environment
+  :load <file>   execute the file in the environment
+  :help          print this help
+  :quit          exit, Ctrl-D does the same
+`
+
+// Repl reads inputs and executes them in the environment kept between inputs
+type Repl struct {
+	in       lineReader
+	out      io.Writer
+	executor *fdalang.ExecAstVisitor
+	env      *fdalang.Environment
+	fixtures *fdalang.Fixtures
+}
+
+// New returns the REPL reading from the input. Lines are edited in place and history is kept
+// if the input is a terminal, otherwise lines are read as is and prompts are not printed.
+func New(in io.Reader, out io.Writer) *Repl {
+	r := &Repl{out: out, executor: fdalang.NewExecAstVisitor(), env: fdalang.NewEnvironment()}
+	r.executor.SetOutput(out)
+	if f, ok := in.(*os.File); ok {
+		history := ""
+		if home, err := os.UserHomeDir(); err == nil {
+			history = filepath.Join(home, historyFile)
+		}
+		if editor := newLineEditor(f, out, history); editor != nil {
+			r.in = editor
+		}
+	}
+	if r.in == nil {
+		r.in = newPlainReader(in)
+	}
+	return r
+}
+
+// Executor returns the executor of inputs, e.g. to set limits
+func (r *Repl) Executor() *fdalang.ExecAstVisitor {
+	return r.executor
+}
+
+// Env returns the current environment, it's replaced by :reset
+func (r *Repl) Env() *fdalang.Environment {
+	return r.env
+}
+
+// SetFixtures applies host values of the fixtures to the environment, they are applied again after :reset
+func (r *Repl) SetFixtures(fixtures *fdalang.Fixtures) error {
+	r.fixtures = fixtures
+	return fixtures.Apply(r.env)
+}
+
+// Run reads and executes inputs until the end of the input or :quit
+func (r *Repl) Run() error {
+	defer r.in.Close()
+	var input strings.Builder
+	for {
+		p := prompt
+		if input.Len() > 0 {
+			p = continuePrompt
+		}
+		line, err := r.in.ReadLine(p)
+		if err == errInterrupted {
+			input.Reset()
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if input.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, ":") {
+				r.in.AddHistory(trimmed)
+				if quit := r.command(trimmed); quit {
+					return nil
+				}
+				continue
+			}
+		}
+		input.WriteString(line + "\n")
+		if !complete(input.String()) {
+			continue
+		}
+		source := input.String()
+		input.Reset()
+		r.in.AddHistory(strings.TrimSpace(strings.ReplaceAll(source, "\n", " ")))
+		r.execute(source)
+	}
+}
+
+// complete reports if the input could be executed: all brackets are closed and the last line
+// doesn't end with a comma, e.g. in the middle of arguments of a call
+func complete(input string) bool {
+	depth := 0
+	last := ""
+	for _, line := range strings.Split(input, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		for _, r := range line {
+			switch r {
+			case '{', '(', '[':
+				depth++
+			case '}', ')', ']':
+				depth--
+			}
+		}
+		if strings.TrimSpace(line) != "" {
+			last = strings.TrimSpace(line)
+		}
+	}
+	return depth <= 0 && !strings.HasSuffix(last, ",")
+}
+
+// execute evaluates the input if it's a single expression and prints its value,
+// otherwise executes it as statements. Panic of the interpreter is printed as an error of the input
+// and doesn't end the session.
+func (r *Repl) execute(source string) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.error(fmt.Errorf("%v", p))
+		}
+	}()
+	if expr, err := fdalang.NewParser(fdalang.NewLexer(source)).ParseExpression(); err == nil {
+		result, err := r.executor.Eval(r.env, expr)
+		if err != nil {
+			r.error(err)
+			return
+		}
+		if result.Type() != fdalang.TypeVoid {
+			fmt.Fprintln(r.out, result.Inspect())
+		}
+		return
+	}
+
+	program, err := fdalang.NewParser(fdalang.NewLexer(source)).Parse()
+	if err != nil {
+		r.error(err)
+		return
+	}
+	if err = r.executor.ExecAst(program, r.env); err != nil {
+		r.error(err)
+	}
+}
+
+func (r *Repl) error(err error) {
+	fmt.Fprintf(r.out, "error: %s\n", strings.TrimSpace(err.Error()))
+}
+
+// command executes the REPL command starting with ':', it returns true if the REPL should exit
+func (r *Repl) command(line string) bool {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch name {
+	case ":quit", ":q":
+		return true
+	case ":help", ":h":
+		fmt.Fprint(r.out, help)
+	case ":type", ":t":
+		r.printType(arg)
+	case ":env":
+		r.printEnv()
+	case ":reset":
+		r.env = fdalang.NewEnvironment()
+		if r.fixtures != nil {
+			if err := r.fixtures.Apply(r.env); err != nil {
+				r.error(fmt.Errorf("fixtures: %s", err.Error()))
+			}
+		}
+	case ":load", ":l":
+		r.load(arg)
+	default:
+		fmt.Fprintf(r.out, "unknown command '%s', enter :help for the list of commands\n", name)
+	}
+	return false
+}
+
+func (r *Repl) printType(source string) {
+	if source == "" {
+		fmt.Fprintln(r.out, "usage: :type <expr>")
+		return
+	}
+	expr, err := fdalang.NewParser(fdalang.NewLexer(source + "\n")).ParseExpression()
+	if err != nil {
+		r.error(err)
+		return
+	}
+	info := r.executor.Check(&fdalang.AstStatementsBlock{}, r.env)
+	t := info.ExprType(expr, 1)
+	if t == "" {
+		fmt.Fprintln(r.out, "error: type of the expression is unknown")
+		return
+	}
+	fmt.Fprintln(r.out, t)
+}
+
+func (r *Repl) printEnv() {
+	store := r.env.Store()
+	names := make([]string, 0, len(store))
+	for name := range store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		obj := store[name]
+		fmt.Fprintf(r.out, "%s %s = %s\n", obj.Type(), name, obj.Inspect())
+	}
+}
+
+func (r *Repl) load(path string) {
+	if path == "" {
+		fmt.Fprintln(r.out, "usage: :load <file>")
+		return
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.error(err)
+		return
+	}
+	source := string(content)
+	if !strings.HasSuffix(source, "\n") {
+		source += "\n"
+	}
+	program, err := fdalang.NewParser(fdalang.NewLexer(source)).Parse()
+	if err != nil {
+		r.error(fmt.Errorf("%s: %s", path, err.Error()))
+		return
+	}
+	if err = r.executor.ExecAst(program, r.env); err != nil {
+		r.error(fmt.Errorf("%s: %s", path, err.Error()))
+	}
+}
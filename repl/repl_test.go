@@ -0,0 +1,102 @@
+package repl
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, input string) string {
+	var out bytes.Buffer
+	r := New(strings.NewReader(input), &out)
+	require.Nil(t, r.Run())
+	return out.String()
+}
+
+func TestRepl(t *testing.T) {
+	input := `a = 2
+a * 3
+double = fn(int x) int {
+   return x * 2
+}
+double(a)
+print(a)
+struct point {
+   int x
+   int y
+}
+p = point{x = 1, y = a}
+p
+if p.y > 1 {
+   print(p.x)
+} else {
+   print(0)
+}
+b
+`
+	expected := `6
+4
+2
+point{x: 1, y: 2}
+1
+error: identifier not found: b
+line:1, pos 1
+`
+	assert.Equal(t, expected, run(t, input))
+}
+
+func TestReplDivisionByZero(t *testing.T) {
+	input := `x = 1
+x / 0
+x + 1
+`
+	expected := `error: integer division by zero
+line:1, pos 3
+2
+`
+	assert.Equal(t, expected, run(t, input))
+}
+
+func TestReplCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.fda")
+	require.Nil(t, ioutil.WriteFile(file, []byte("inc = fn(int x) int {\n   return x + 1\n}"), 0644))
+
+	input := `a = 1.5
+:type a * 2.
+:type []int{1}
+:type c
+:env
+:load ` + file + `
+inc(2)
+:reset
+a
+:unknown
+:quit
+a
+`
+	out := run(t, input)
+	lines := strings.Split(out, "\n")
+	assert.Equal(t, "float", lines[0])
+	assert.Equal(t, "[]int", lines[1])
+	assert.Equal(t, "error: type of the expression is unknown", lines[2])
+	assert.Equal(t, "float a = 1.50", lines[3])
+	assert.Equal(t, "3", lines[4])
+	assert.True(t, strings.HasPrefix(lines[5], "error: "), "a is cleared by :reset")
+	assert.Contains(t, out, "unknown command ':unknown'")
+	assert.NotContains(t, out, "1.50\n1.50", "input after :quit is not executed")
+}
+
+func TestComplete(t *testing.T) {
+	assert.True(t, complete("a = 1\n"))
+	assert.False(t, complete("if a {\n"))
+	assert.False(t, complete("if a { // }\n"))
+	assert.True(t, complete("if a {\n}\n"))
+	assert.False(t, complete("f(1,\n"))
+	assert.False(t, complete("f(\n   1,\n"))
+	assert.True(t, complete("f(\n   1,\n)\n"))
+}